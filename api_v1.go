@@ -0,0 +1,748 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ================= REST API v1 =================
+//   /api/v1/licenses    GET 列表 / POST 生成         /api/v1/licenses/{id}    GET / PATCH (customer_id, revoked) / DELETE
+//   /api/v1/machines    GET 列表                      /api/v1/machines/{id}    GET / PATCH (customer_id, owner) / DELETE
+//   /api/v1/customers   GET 列表 / POST 创建         /api/v1/customers/{id}   GET / PATCH / DELETE（仍有关联时 409）
+//   /api/v1/tokens      GET 列表 / POST 创建         /api/v1/tokens/{id}      GET / PATCH / DELETE（吊销）
+// 鉴权只看 Authorization 头（Bearer / 客户端证书 / HMAC）或登录会话，不接受请求体里的 token。
+// 成功时返回 {"data": ...}，列表另带 next_cursor / has_more；失败时一律返回
+//   {"error": {"code": "not_found", "message": "...", "status": 404}}
+// 列表按时间倒序，limit 默认 50、最大 200，把 next_cursor 原样放进 cursor 参数取下一页。
+
+const (
+	v1DefaultLimit = 50
+	v1MaxLimit     = 200
+	v1MaxBody      = 1 << 20
+)
+
+type apiErrorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Status  int    `json:"status"`
+}
+
+type listResponse struct {
+	Data       interface{} `json:"data"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+	HasMore    bool        `json:"has_more"`
+}
+
+func isAPIv1(r *http.Request) bool { return r.URL.Path == "/api/v1" || strings.HasPrefix(r.URL.Path, "/api/v1/") }
+
+// writeError 接口错误的统一出口：/api/v1 返回 JSON 错误信封，旧接口和页面保持纯文本
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, msg string) {
+	if !isAPIv1(r) { http.Error(w, msg, status); return }
+	writeJSON(w, status, map[string]apiErrorBody{"error": {Code: code, Message: strings.TrimPrefix(msg, "❌ "), Status: status}})
+}
+
+func codeForStatus(status int) string {
+	switch status {
+	case 400:
+		return "invalid_request"
+	case 401:
+		return "unauthorized"
+	case 403:
+		return "forbidden"
+	case 404:
+		return "not_found"
+	case 405:
+		return "method_not_allowed"
+	case 409:
+		return "conflict"
+	case 413:
+		return "payload_too_large"
+	case 422:
+		return "validation_failed"
+	case 429:
+		return "rate_limited"
+	}
+	return "internal_error"
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeData(w http.ResponseWriter, status int, v interface{}) {
+	writeJSON(w, status, map[string]interface{}{"data": v})
+}
+
+// decodeBody 严格解码请求体，未知字段视为错误，避免字段名拼错被静默忽略
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, v1MaxBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil { writeError(w, r, 400, "invalid_request", "JSON 格式错误: "+err.Error()); return false }
+	return true
+}
+
+// ================= 路由 =================
+
+type v1Handler func(w http.ResponseWriter, r *http.Request, id string)
+
+type v1Resource struct {
+	List, Create, Get, Update, Delete v1Handler
+}
+
+var v1Resources = map[string]v1Resource{
+	"licenses":  {List: v1ListLicenses, Create: v1CreateLicense, Get: v1GetLicense, Update: v1UpdateLicense, Delete: v1DeleteLicense},
+	"machines":  {List: v1ListMachines, Get: v1GetMachine, Update: v1UpdateMachine, Delete: v1DeleteMachine},
+	"customers": {List: v1ListCustomers, Create: v1CreateCustomer, Get: v1GetCustomer, Update: v1UpdateCustomer, Delete: v1DeleteCustomer},
+	"tokens":    {List: v1ListTokens, Create: v1CreateToken, Get: v1GetToken, Update: v1UpdateToken, Delete: v1DeleteToken},
+}
+
+func handleAPIv1(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.EscapedPath(), "/api/v1"), "/"), "/")
+	res, ok := v1Resources[parts[0]]
+	if !ok || len(parts) > 2 { writeError(w, r, 404, "not_found", "接口不存在"); return }
+	id := ""
+	if len(parts) == 2 {
+		var err error
+		if id, err = url.PathUnescape(parts[1]); err != nil || id == "" { writeError(w, r, 400, "invalid_request", "路径格式错误"); return }
+	}
+
+	handlers := map[string]v1Handler{"GET": res.List, "POST": res.Create}
+	if id != "" { handlers = map[string]v1Handler{"GET": res.Get, "PATCH": res.Update, "DELETE": res.Delete} }
+	h := handlers[r.Method]
+	if h == nil {
+		var allow []string
+		for _, m := range []string{"GET", "POST", "PATCH", "DELETE"} {
+			if handlers[m] != nil { allow = append(allow, m) }
+		}
+		w.Header().Set("Allow", strings.Join(allow, ", "))
+		writeError(w, r, 405, "method_not_allowed", r.Method+" 不支持，可用: "+strings.Join(allow, ", "))
+		return
+	}
+	h(w, r, id)
+}
+
+// ================= 分页与筛选 =================
+
+// paginate keys 已按降序排列；cursor 是上一页最后一条的 key，返回本页的下标范围
+func paginate(w http.ResponseWriter, r *http.Request, keys []string) (int, int, string, bool) {
+	q := r.URL.Query()
+	limit := v1DefaultLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > v1MaxLimit { writeError(w, r, 400, "invalid_request", fmt.Sprintf("limit 必须在 1-%d 之间", v1MaxLimit)); return 0, 0, "", false }
+		limit = n
+	}
+	start, end, next, err := pageRange(keys, limit, q.Get("cursor"))
+	if err != nil { writeError(w, r, 400, "invalid_cursor", "cursor 无效"); return 0, 0, "", false }
+	return start, end, next, true
+}
+
+// pageRange 与 gRPC 的 page_token 共用同一种游标格式
+func pageRange(keys []string, limit int, cursor string) (int, int, string, error) {
+	start := 0
+	if cursor != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil { return 0, 0, "", err }
+		after := string(raw)
+		start = sort.Search(len(keys), func(i int) bool { return keys[i] < after })
+	}
+	end := min(start+limit, len(keys))
+	next := ""
+	if end < len(keys) { next = base64.RawURLEncoding.EncodeToString([]byte(keys[end-1])) }
+	return start, end, next, nil
+}
+
+// sortKey 时间相同的记录再按 ID 排，保证翻页稳定
+func sortKey(ts, id string) string { return ts + "|" + id }
+
+// v1Filter 在页面筛选条件的基础上校验格式，格式错误时直接返回 400
+func v1Filter(w http.ResponseWriter, r *http.Request, p *principal) (recordFilter, bool) {
+	f := p.recordFilter(r.URL.Query())
+	if err := f.validate(); err != nil { writeError(w, r, 400, "invalid_request", err.Error()); return f, false }
+	if owner := r.URL.Query().Get("owner"); owner != "" && p.Owner == "" { f.Owner = owner }
+	return f, true
+}
+
+func (f recordFilter) validate() error {
+	for name, day := range map[string]string{"from": f.From, "to": f.To} {
+		if _, err := time.Parse("2006-01-02", day); day != "" && err != nil { return fmt.Errorf("%s 应为 YYYY-MM-DD", name) }
+	}
+	if f.Status != "" && f.Status != "active" && f.Status != "expired" && f.Status != "revoked" { return fmt.Errorf("status 只能是 active、expired 或 revoked") }
+	return nil
+}
+
+func today() string { return time.Now().In(bizLocation()).Format("2006-01-02") }
+
+// ================= 激活码 =================
+
+type licenseV1 struct {
+	ID          string `json:"id"`
+	MachineID   string `json:"machine_id"`
+	ExpiryDate  string `json:"expiry_date"`
+	Status      string `json:"status"` // active / expired / revoked
+	LicenseCode string `json:"license_code"`
+	IssuedBy    string `json:"issued_by,omitempty"`
+	Owner       string `json:"owner,omitempty"`
+	CustomerID  string `json:"customer_id,omitempty"`
+	CreatedAt   string `json:"created_at"`
+	RevokedAt   string `json:"revoked_at,omitempty"`
+}
+
+// licenseStatus 吊销优先于过期
+func licenseStatus(rec HistoryRecord, day string) string {
+	switch {
+	case rec.RevokedAt != "":
+		return "revoked"
+	case rec.ExpiryDate < day:
+		return "expired"
+	}
+	return "active"
+}
+
+func toLicenseV1(rec HistoryRecord, day string) licenseV1 {
+	return licenseV1{ID: rec.ID, MachineID: rec.MachineID, ExpiryDate: rec.ExpiryDate, Status: licenseStatus(rec, day), LicenseCode: rec.LicenseCode,
+		IssuedBy: rec.IssuedBy, Owner: rec.Owner, CustomerID: rec.CustomerID, CreatedAt: rec.GenerateTime, RevokedAt: rec.RevokedAt}
+}
+
+// findLicense 调用方需持有 mutex；不归属于调用者的记录视为不存在
+func findLicense(id string, p *principal) int {
+	for i, h := range historyList {
+		if h.ID == id && (p.Owner == "" || h.Owner == p.Owner) { return i }
+	}
+	return -1
+}
+
+func v1ListLicenses(w http.ResponseWriter, r *http.Request, _ string) {
+	p, ok := authorize(w, r, "", scopeReadHistory)
+	if !ok { return }
+	f, ok := v1Filter(w, r, p)
+	if !ok { return }
+	q := r.URL.Query()
+	list, keys := queryLicenses(f, q.Get("machine_id"), q.Get("customer_id"), q.Get("issued_by"))
+	start, end, next, ok := paginate(w, r, keys)
+	if !ok { return }
+	day := today()
+	data := make([]licenseV1, 0, end-start)
+	for _, rec := range list[start:end] { data = append(data, toLicenseV1(rec, day)) }
+	writeJSON(w, 200, listResponse{Data: data, NextCursor: next, HasMore: next != ""})
+}
+
+// queryLicenses 筛选后按生成时间倒序，同时返回翻页用的 key；空字符串表示不按该字段筛选
+func queryLicenses(f recordFilter, machineID, customerID, issuedBy string) ([]HistoryRecord, []string) {
+	mutex.Lock()
+	var list []HistoryRecord
+	for _, rec := range filterHistory(f) {
+		if machineID != "" && rec.MachineID != machineID { continue }
+		if customerID != "" && rec.CustomerID != customerID { continue }
+		if issuedBy != "" && rec.IssuedBy != issuedBy { continue }
+		list = append(list, rec)
+	}
+	mutex.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return sortKey(list[i].GenerateTime, list[i].ID) > sortKey(list[j].GenerateTime, list[j].ID) })
+	keys := make([]string, len(list))
+	for i, rec := range list { keys[i] = sortKey(rec.GenerateTime, rec.ID) }
+	return list, keys
+}
+
+func v1GetLicense(w http.ResponseWriter, r *http.Request, id string) {
+	p, ok := authorize(w, r, "", scopeReadHistory)
+	if !ok { return }
+	mutex.Lock(); defer mutex.Unlock()
+	i := findLicense(id, p)
+	if i < 0 { writeError(w, r, 404, "not_found", "激活码记录不存在"); return }
+	writeData(w, 200, toLicenseV1(historyList[i], today()))
+}
+
+type createLicenseV1 struct {
+	MachineID  string `json:"machine_id"`
+	Expiry     string `json:"expiry"` // YYYY-MM-DD
+	CustomerID string `json:"customer_id,omitempty"`
+}
+
+func v1CreateLicense(w http.ResponseWriter, r *http.Request, _ string) {
+	p, ok := authorize(w, r, "", scopeGenerate)
+	if !ok { return }
+	var req createLicenseV1
+	if !decodeBody(w, r, &req) { return }
+	req.MachineID = strings.TrimSpace(req.MachineID)
+	withIdempotency(w, r, p, requestFingerprint(req.MachineID, req.Expiry, req.CustomerID), func(w http.ResponseWriter) {
+		if req.MachineID == "" { writeError(w, r, 422, "validation_failed", "machine_id 不能为空"); return }
+		if err := checkStoredValue("machine_id", req.MachineID); err != nil { writeError(w, r, 422, "validation_failed", err.Error()); return }
+		if _, err := parseExpiry(req.Expiry); err != nil { writeError(w, r, 422, "validation_failed", "expiry: "+strings.TrimPrefix(err.Error(), "❌ ")); return }
+		if req.CustomerID != "" && !customerVisible(req.CustomerID, p.Owner) { writeError(w, r, 422, "validation_failed", "customer_id 不存在"); return }
+		if err := p.checkLicenseDuration(req.Expiry); err != nil { writeError(w, r, 403, "license_duration_exceeded", err.Error()); return }
+		if isLongLicense(req.Expiry) && !requireStepUp(w, r, p, "生成长期激活码") { return }
+
+		rec, err := issueLicense(r.Context(), p, req.MachineID, req.Expiry, req.CustomerID)
+		if errors.Is(err, errMachineOwned) { writeError(w, r, 403, "machine_owned_by_other", err.Error()); return }
+		if err != nil { writeError(w, r, 500, "internal_error", err.Error()); return }
+		w.Header().Set("Location", "/api/v1/licenses/"+rec.ID)
+		writeData(w, 201, toLicenseV1(rec, today()))
+	})
+}
+
+type updateLicenseV1 struct {
+	CustomerID *string `json:"customer_id"` // 空字符串表示取消关联
+	Revoked    *bool   `json:"revoked"`     // 只能设为 true，需要 delete 权限
+}
+
+func v1UpdateLicense(w http.ResponseWriter, r *http.Request, id string) {
+	p, ok := authorize(w, r, "", scopeGenerate)
+	if !ok { return }
+	var req updateLicenseV1
+	if !decodeBody(w, r, &req) { return }
+	if req.CustomerID != nil && *req.CustomerID != "" && !customerVisible(*req.CustomerID, p.Owner) { writeError(w, r, 422, "validation_failed", "customer_id 不存在"); return }
+	if req.Revoked != nil {
+		if !*req.Revoked { writeError(w, r, 422, "validation_failed", "吊销后不能恢复，revoked 只能为 true"); return }
+		if !p.HasScope(scopeDelete) { writeScopeError(w, r, p, scopeDelete); return }
+		if _, ok := revokeLicense(r.Context(), id, p); !ok { writeError(w, r, 404, "not_found", "激活码记录不存在"); return }
+	}
+
+	mutex.Lock(); defer mutex.Unlock()
+	i := findLicense(id, p)
+	if i < 0 { writeError(w, r, 404, "not_found", "激活码记录不存在"); return }
+	if req.CustomerID != nil {
+		historyList[i].CustomerID = *req.CustomerID
+		persistHistory()
+		slog.InfoContext(r.Context(), "🧾 激活码记录关联到客户", "principal", p.Name, "id", id, "customer_id", *req.CustomerID)
+	}
+	writeData(w, 200, toLicenseV1(historyList[i], today()))
+}
+
+func v1DeleteLicense(w http.ResponseWriter, r *http.Request, id string) {
+	p, ok := authorize(w, r, "", scopeDelete)
+	if !ok { return }
+	if _, ok := deleteLicense(r.Context(), id, p); !ok { writeError(w, r, 404, "not_found", "激活码记录不存在"); return }
+	w.WriteHeader(204)
+}
+
+// deleteLicense 删除调用者可见的一条签发记录并推送事件，记录不存在时返回 false
+func deleteLicense(ctx context.Context, id string, p *principal) (HistoryRecord, bool) {
+	mutex.Lock(); defer mutex.Unlock()
+	i := findLicense(id, p)
+	if i < 0 { return HistoryRecord{}, false }
+	removed := historyList[i]
+	historyList = append(historyList[:i], historyList[i+1:]...)
+	persistHistory()
+	slog.InfoContext(ctx, "🗑️ 删除了历史记录", "principal", p.Name, "id", removed.ID, "machine_id", removed.MachineID)
+	emitEvent(eventLicenseDeleted, toLicenseV1(removed, today()))
+	return removed, true
+}
+
+// revokeLicense 吊销调用者可见的一条签发记录并推送 license.revoked，记录保留；已吊销的原样返回，不重复推送
+func revokeLicense(ctx context.Context, id string, p *principal) (HistoryRecord, bool) {
+	mutex.Lock(); defer mutex.Unlock()
+	i := findLicense(id, p)
+	if i < 0 { return HistoryRecord{}, false }
+	rec := &historyList[i]
+	if rec.RevokedAt != "" { return *rec, true }
+	rec.RevokedAt, rec.RevokedBy = nowTimestamp(), p.Name
+	persistHistory()
+	slog.InfoContext(ctx, "⛔ 吊销了激活码", "principal", p.Name, "id", rec.ID, "machine_id", rec.MachineID)
+	emitEvent(eventLicenseRevoked, toLicenseV1(*rec, today()))
+	return *rec, true
+}
+
+// licenseRevoked 激活码对应的签发记录是否已吊销；不在历史记录里的激活码视为未吊销
+func licenseRevoked(code string) bool {
+	code = strings.TrimSpace(code)
+	mutex.Lock(); defer mutex.Unlock()
+	for _, h := range historyList {
+		if h.RevokedAt != "" && h.LicenseCode == code { return true }
+	}
+	return false
+}
+
+// ================= 机器 =================
+
+type machineV1 struct {
+	MachineID    string `json:"machine_id"`
+	LastSeen     string `json:"last_seen"`
+	Owner        string `json:"owner,omitempty"`
+	CustomerID   string `json:"customer_id,omitempty"`
+	LatestExpiry string `json:"latest_expiry,omitempty"` // 该机器未吊销的激活码中最晚的到期日
+}
+
+// latestExpiries 调用方需持有 mutex
+func latestExpiries() map[string]string {
+	out := map[string]string{}
+	for _, h := range historyList {
+		if h.RevokedAt == "" && h.ExpiryDate > out[h.MachineID] { out[h.MachineID] = h.ExpiryDate }
+	}
+	return out
+}
+
+func toMachineV1(m MachineRecord, expiries map[string]string) machineV1 {
+	return machineV1{MachineID: m.MachineID, LastSeen: m.LastSeen, Owner: m.Owner, CustomerID: m.CustomerID, LatestExpiry: expiries[m.MachineID]}
+}
+
+// findMachine 调用方需持有 mutex
+func findMachine(id string, p *principal) int {
+	for i, m := range machineList {
+		if m.MachineID == id && (p.Owner == "" || m.Owner == p.Owner) { return i }
+	}
+	return -1
+}
+
+func v1ListMachines(w http.ResponseWriter, r *http.Request, _ string) {
+	p, ok := authorize(w, r, "", scopeReadHistory)
+	if !ok { return }
+	f, ok := v1Filter(w, r, p)
+	if !ok { return }
+	list, keys, expiries := queryMachines(f, r.URL.Query().Get("customer_id"))
+	start, end, next, ok := paginate(w, r, keys)
+	if !ok { return }
+	data := make([]machineV1, 0, end-start)
+	for _, m := range list[start:end] { data = append(data, toMachineV1(m, expiries)) }
+	writeJSON(w, 200, listResponse{Data: data, NextCursor: next, HasMore: next != ""})
+}
+
+// queryMachines 筛选后按最近生成时间倒序，另外返回每台机器最晚的到期日
+func queryMachines(f recordFilter, customerID string) ([]MachineRecord, []string, map[string]string) {
+	mutex.Lock()
+	var list []MachineRecord
+	for _, m := range filterMachines(f) {
+		if customerID == "" || m.CustomerID == customerID { list = append(list, m) }
+	}
+	expiries := latestExpiries()
+	mutex.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return sortKey(list[i].LastSeen, list[i].MachineID) > sortKey(list[j].LastSeen, list[j].MachineID) })
+	keys := make([]string, len(list))
+	for i, m := range list { keys[i] = sortKey(m.LastSeen, m.MachineID) }
+	return list, keys, expiries
+}
+
+func v1GetMachine(w http.ResponseWriter, r *http.Request, id string) {
+	p, ok := authorize(w, r, "", scopeReadHistory)
+	if !ok { return }
+	mutex.Lock(); defer mutex.Unlock()
+	i := findMachine(id, p)
+	if i < 0 { writeError(w, r, 404, "not_found", "机器码不存在"); return }
+	writeData(w, 200, toMachineV1(machineList[i], latestExpiries()))
+}
+
+type updateMachineV1 struct {
+	CustomerID *string `json:"customer_id"`
+	Owner      *string `json:"owner"` // 只有管理员可以改归属
+}
+
+func v1UpdateMachine(w http.ResponseWriter, r *http.Request, id string) {
+	p, ok := authorize(w, r, "", scopeGenerate)
+	if !ok { return }
+	var req updateMachineV1
+	if !decodeBody(w, r, &req) { return }
+	if req.Owner != nil && !p.HasScope(scopeAdmin) { writeScopeError(w, r, p, scopeAdmin); return }
+	if req.CustomerID != nil && *req.CustomerID != "" && !customerVisible(*req.CustomerID, p.Owner) { writeError(w, r, 422, "validation_failed", "customer_id 不存在"); return }
+
+	mutex.Lock(); defer mutex.Unlock()
+	i := findMachine(id, p)
+	if i < 0 { writeError(w, r, 404, "not_found", "机器码不存在"); return }
+	m := &machineList[i]
+	if req.CustomerID != nil { m.CustomerID = *req.CustomerID }
+	if req.Owner != nil { m.Owner = *req.Owner }
+	if req.CustomerID != nil || req.Owner != nil {
+		persistMachines()
+		slog.InfoContext(r.Context(), "💻 修改了机器码", "principal", p.Name, "machine_id", id, "customer_id", m.CustomerID, "owner", m.Owner)
+	}
+	writeData(w, 200, toMachineV1(*m, latestExpiries()))
+}
+
+func v1DeleteMachine(w http.ResponseWriter, r *http.Request, id string) {
+	p, ok := authorize(w, r, "", scopeDelete)
+	if !ok { return }
+	if _, ok := deleteMachine(r.Context(), id, p); !ok { writeError(w, r, 404, "not_found", "机器码不存在"); return }
+	w.WriteHeader(204)
+}
+
+// deleteMachine 删除调用者可见的机器码并推送事件，不存在时返回 false
+func deleteMachine(ctx context.Context, id string, p *principal) (MachineRecord, bool) {
+	mutex.Lock(); defer mutex.Unlock()
+	i := findMachine(id, p)
+	if i < 0 { return MachineRecord{}, false }
+	removed := machineList[i]
+	machineList = append(machineList[:i], machineList[i+1:]...)
+	persistMachines()
+	slog.InfoContext(ctx, "🗑️ 删除了机器码", "principal", p.Name, "machine_id", id)
+	emitEvent(eventMachineDeleted, toMachineV1(removed, nil))
+	return removed, true
+}
+
+// ================= 客户 =================
+
+func v1ListCustomers(w http.ResponseWriter, r *http.Request, _ string) {
+	p, ok := authorize(w, r, "", scopeReadHistory)
+	if !ok { return }
+	q := r.URL.Query()
+	owner := p.Owner
+	if owner == "" { owner = q.Get("owner") }
+	text := strings.ToLower(strings.TrimSpace(q.Get("q")))
+
+	customerMutex.Lock()
+	var list []Customer
+	for _, c := range customerList {
+		if owner != "" && c.Owner != owner { continue }
+		if v := q.Get("external_id"); v != "" && !strings.EqualFold(c.ExternalID, v) { continue }
+		if text != "" && !strings.Contains(strings.ToLower(c.Name), text) && !strings.Contains(strings.ToLower(c.Email), text) { continue }
+		list = append(list, c)
+	}
+	customerMutex.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return sortKey(list[i].CreatedAt, list[i].ID) > sortKey(list[j].CreatedAt, list[j].ID) })
+	keys := make([]string, len(list))
+	for i, c := range list { keys[i] = sortKey(c.CreatedAt, c.ID) }
+	start, end, next, ok := paginate(w, r, keys)
+	if !ok { return }
+	writeJSON(w, 200, listResponse{Data: append([]Customer{}, list[start:end]...), NextCursor: next, HasMore: next != ""})
+}
+
+func v1GetCustomer(w http.ResponseWriter, r *http.Request, id string) {
+	p, ok := authorize(w, r, "", scopeReadHistory)
+	if !ok { return }
+	customerMutex.Lock(); defer customerMutex.Unlock()
+	i := findCustomer(id, p.Owner)
+	if i < 0 { writeError(w, r, 404, "not_found", "客户不存在"); return }
+	writeData(w, 200, customerList[i])
+}
+
+type customerV1Request struct {
+	Name       *string `json:"name"`
+	Email      *string `json:"email"`
+	ExternalID *string `json:"external_id"`
+	Notes      *string `json:"notes"`
+}
+
+// apply 把填写了的字段写入 c，校验失败时返回错误信息
+func (req customerV1Request) apply(c *Customer) string {
+	if req.Name != nil { c.Name = strings.TrimSpace(*req.Name) }
+	if req.Email != nil { c.Email = strings.TrimSpace(*req.Email) }
+	if req.ExternalID != nil { c.ExternalID = strings.TrimSpace(*req.ExternalID) }
+	if req.Notes != nil { c.Notes = *req.Notes }
+	if c.Name == "" { return "name 不能为空" }
+	if c.Email != "" && !strings.Contains(c.Email, "@") { return "email 格式错误" }
+	return ""
+}
+
+func v1CreateCustomer(w http.ResponseWriter, r *http.Request, _ string) {
+	p, ok := authorize(w, r, "", scopeGenerate)
+	if !ok { return }
+	var req customerV1Request
+	if !decodeBody(w, r, &req) { return }
+	c := Customer{ID: newRecordID(), Owner: p.Owner, CreatedAt: nowTimestamp()}
+	if msg := req.apply(&c); msg != "" { writeError(w, r, 422, "validation_failed", msg); return }
+
+	customerMutex.Lock(); defer customerMutex.Unlock()
+	if externalIDTaken(c.ExternalID, c.Owner, "") { writeError(w, r, 409, "conflict", "external_id「"+c.ExternalID+"」已存在"); return }
+	customerList = append(customerList, c)
+	persistCustomers()
+	slog.InfoContext(r.Context(), "🧾 创建了客户", "principal", p.Name, "customer", c.Name)
+	w.Header().Set("Location", "/api/v1/customers/"+c.ID)
+	writeData(w, 201, c)
+}
+
+func v1UpdateCustomer(w http.ResponseWriter, r *http.Request, id string) {
+	p, ok := authorize(w, r, "", scopeGenerate)
+	if !ok { return }
+	var req customerV1Request
+	if !decodeBody(w, r, &req) { return }
+
+	customerMutex.Lock(); defer customerMutex.Unlock()
+	i := findCustomer(id, p.Owner)
+	if i < 0 { writeError(w, r, 404, "not_found", "客户不存在"); return }
+	c := customerList[i]
+	if msg := req.apply(&c); msg != "" { writeError(w, r, 422, "validation_failed", msg); return }
+	if externalIDTaken(c.ExternalID, c.Owner, c.ID) { writeError(w, r, 409, "conflict", "external_id「"+c.ExternalID+"」已存在"); return }
+	c.UpdatedAt = nowTimestamp()
+	customerList[i] = c
+	persistCustomers()
+	slog.InfoContext(r.Context(), "🧾 修改了客户", "principal", p.Name, "customer", c.Name)
+	writeData(w, 200, c)
+}
+
+func v1DeleteCustomer(w http.ResponseWriter, r *http.Request, id string) {
+	p, ok := authorize(w, r, "", scopeDelete)
+	if !ok { return }
+	customerMutex.Lock(); defer customerMutex.Unlock()
+	i := findCustomer(id, p.Owner)
+	if i < 0 { writeError(w, r, 404, "not_found", "客户不存在"); return }
+	if customerInUse(id) { writeError(w, r, 409, "customer_in_use", "仍有激活码或机器关联该客户，请先取消关联"); return }
+	removed := customerList[i]
+	customerList = append(customerList[:i], customerList[i+1:]...)
+	persistCustomers()
+	slog.InfoContext(r.Context(), "🗑️ 删除了客户", "principal", p.Name, "customer", removed.Name)
+	w.WriteHeader(204)
+}
+
+// ================= Token =================
+
+type tokenV1 struct {
+	ID                 string   `json:"id"`
+	Name               string   `json:"name"`
+	Prefix             string   `json:"prefix"`
+	Scopes             []string `json:"scopes"`
+	Status             string   `json:"status"` // active / expired / revoked
+	CreatedAt          string   `json:"created_at"`
+	ExpiresAt          string   `json:"expires_at,omitempty"`
+	MaxDays            int      `json:"max_days,omitempty"`
+	AllowedIPs         []string `json:"allowed_ips,omitempty"`
+	ClientCertSubjects []string `json:"client_cert_subjects,omitempty"`
+	HMAC               bool     `json:"hmac"`
+	LastUsedAt         string   `json:"last_used_at,omitempty"`
+	RevokedAt          string   `json:"revoked_at,omitempty"`
+
+	// 只在创建时返回一次
+	Secret     string `json:"secret,omitempty"`
+	HMACSecret string `json:"hmac_secret,omitempty"`
+}
+
+func toTokenV1(t APIToken) tokenV1 {
+	return tokenV1{ID: t.ID, Name: t.Name, Prefix: t.Prefix, Scopes: t.Scopes, Status: t.Status(time.Now()), CreatedAt: t.CreatedAt, ExpiresAt: t.ExpiresAt,
+		MaxDays: t.MaxDays, AllowedIPs: t.AllowedIPs, ClientCertSubjects: t.ClientCertSubjects, HMAC: t.HMACSecret != "", LastUsedAt: t.LastUsedAt, RevokedAt: t.RevokedAt}
+}
+
+// findToken 调用方需持有 tokenMutex
+func findToken(id string) int {
+	for i, t := range tokenList {
+		if t.ID == id { return i }
+	}
+	return -1
+}
+
+func v1ListTokens(w http.ResponseWriter, r *http.Request, _ string) {
+	if _, ok := authorize(w, r, "", scopeAdmin); !ok { return }
+	status := r.URL.Query().Get("status")
+	now := time.Now()
+
+	tokenMutex.Lock()
+	var list []APIToken
+	for _, t := range tokenList {
+		if status == "" || t.Status(now) == status { list = append(list, t) }
+	}
+	tokenMutex.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return sortKey(list[i].CreatedAt, list[i].ID) > sortKey(list[j].CreatedAt, list[j].ID) })
+	keys := make([]string, len(list))
+	for i, t := range list { keys[i] = sortKey(t.CreatedAt, t.ID) }
+	start, end, next, ok := paginate(w, r, keys)
+	if !ok { return }
+	data := make([]tokenV1, 0, end-start)
+	for _, t := range list[start:end] { data = append(data, toTokenV1(t)) }
+	writeJSON(w, 200, listResponse{Data: data, NextCursor: next, HasMore: next != ""})
+}
+
+func v1GetToken(w http.ResponseWriter, r *http.Request, id string) {
+	if _, ok := authorize(w, r, "", scopeAdmin); !ok { return }
+	tokenMutex.Lock(); defer tokenMutex.Unlock()
+	i := findToken(id)
+	if i < 0 { writeError(w, r, 404, "not_found", "Token 不存在"); return }
+	writeData(w, 200, toTokenV1(tokenList[i]))
+}
+
+type createTokenV1 struct {
+	Name               string   `json:"name"`
+	Scopes             []string `json:"scopes"`
+	ExpiresAt          string   `json:"expires_at,omitempty"` // YYYY-MM-DD
+	MaxDays            int      `json:"max_days,omitempty"`
+	AllowedIPs         []string `json:"allowed_ips,omitempty"`
+	ClientCertSubjects []string `json:"client_cert_subjects,omitempty"`
+	HMAC               bool     `json:"hmac,omitempty"`
+}
+
+func v1CreateToken(w http.ResponseWriter, r *http.Request, _ string) {
+	admin, ok := authorize(w, r, "", scopeAdmin)
+	if !ok { return }
+	var req createTokenV1
+	if !decodeBody(w, r, &req) { return }
+	t, secret, hmacKey, err := createToken(CreateTokenRequest{Name: req.Name, Scopes: req.Scopes, ExpiresAt: req.ExpiresAt, MaxDays: req.MaxDays,
+		AllowedIPs: req.AllowedIPs, ClientCertSubjects: req.ClientCertSubjects, HMAC: req.HMAC})
+	if err != nil {
+		status, code := 422, "validation_failed"
+		if _, ok := err.(conflictError); ok { status, code = 409, "conflict" }
+		writeError(w, r, status, code, err.Error())
+		return
+	}
+	slog.InfoContext(r.Context(), "🔑 创建了 Token", "principal", admin.Name, "token_name", t.Name, "scopes", strings.Join(t.Scopes, ","))
+	out := toTokenV1(t)
+	out.Secret, out.HMACSecret = secret, hmacKey
+	w.Header().Set("Location", "/api/v1/tokens/"+t.ID)
+	writeData(w, 201, out)
+}
+
+type updateTokenV1 struct {
+	Name               *string   `json:"name"`
+	Scopes             *[]string `json:"scopes"`
+	ExpiresAt          *string   `json:"expires_at"` // YYYY-MM-DD，空字符串表示永不过期
+	MaxDays            *int      `json:"max_days"`
+	AllowedIPs         *[]string `json:"allowed_ips"`
+	ClientCertSubjects *[]string `json:"client_cert_subjects"`
+}
+
+func v1UpdateToken(w http.ResponseWriter, r *http.Request, id string) {
+	admin, ok := authorize(w, r, "", scopeAdmin)
+	if !ok { return }
+	var req updateTokenV1
+	if !decodeBody(w, r, &req) { return }
+
+	tokenMutex.Lock(); defer tokenMutex.Unlock()
+	i := findToken(id)
+	if i < 0 { writeError(w, r, 404, "not_found", "Token 不存在"); return }
+	t := tokenList[i]
+	if t.RevokedAt != "" { writeError(w, r, 409, "conflict", "Token 已吊销，不能修改"); return }
+	fail := func(err error) { writeError(w, r, 422, "validation_failed", err.Error()) }
+	if req.Name != nil {
+		if t.Name = strings.TrimSpace(*req.Name); t.Name == "" { fail(fmt.Errorf("名称不能为空")); return }
+	}
+	if req.Scopes != nil {
+		if err := validateScopes(*req.Scopes); err != nil { fail(err); return }
+		t.Scopes = *req.Scopes
+	}
+	if req.ExpiresAt != nil {
+		exp, err := tokenExpiry(*req.ExpiresAt)
+		if err != nil { fail(err); return }
+		t.ExpiresAt = exp
+	}
+	if req.MaxDays != nil {
+		if *req.MaxDays < 0 { fail(fmt.Errorf("max_days 不能为负数")); return }
+		t.MaxDays = *req.MaxDays
+	}
+	if req.AllowedIPs != nil {
+		ips, err := normalizeIPs(*req.AllowedIPs)
+		if err != nil { fail(err); return }
+		t.AllowedIPs = ips
+	}
+	if req.ClientCertSubjects != nil { t.ClientCertSubjects = normalizeSubjects(*req.ClientCertSubjects) }
+	if err := checkTokenConflicts(t.ID, t.Name, t.ClientCertSubjects); err != nil { writeError(w, r, 409, "conflict", err.Error()); return }
+
+	tokenList[i] = t
+	persistTokens()
+	destroySessionsForToken(t.ID)
+	slog.InfoContext(r.Context(), "🔑 修改了 Token", "principal", admin.Name, "token_name", t.Name, "scopes", strings.Join(t.Scopes, ","))
+	writeData(w, 200, toTokenV1(t))
+}
+
+func v1DeleteToken(w http.ResponseWriter, r *http.Request, id string) {
+	admin, ok := authorize(w, r, "", scopeAdmin)
+	if !ok { return }
+	tokenMutex.Lock(); defer tokenMutex.Unlock()
+	i := findToken(id)
+	if i < 0 { writeError(w, r, 404, "not_found", "Token 不存在"); return }
+	if tokenList[i].RevokedAt != "" { writeError(w, r, 409, "conflict", "Token 已吊销"); return }
+	tokenList[i].RevokedAt = nowTimestamp()
+	persistTokens()
+	destroySessionsForToken(id)
+	slog.InfoContext(r.Context(), "🔑 吊销了 Token", "principal", admin.Name, "token_name", tokenList[i].Name)
+	w.WriteHeader(204)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// withStore 把历史记录和 Webhook 投递队列换成测试数据，落盘写到临时目录
+func withStore(t *testing.T, history []HistoryRecord, hooks []Webhook) {
+	t.Helper()
+	dir := t.TempDir()
+	savedHistory, savedMachines, savedHooks, savedDeliveries := historyList, machineList, webhookList, deliveryList
+	savedHistoryFile, savedMachineFile, savedDeliveryFile := historyFile, machineFile, webhookDeliveryFile
+	historyList, machineList, webhookList, deliveryList = history, nil, hooks, nil
+	historyFile, machineFile, webhookDeliveryFile = filepath.Join(dir, "history.json"), filepath.Join(dir, "machines.json"), filepath.Join(dir, "webhook_deliveries.json")
+	t.Cleanup(func() {
+		historyList, machineList, webhookList, deliveryList = savedHistory, savedMachines, savedHooks, savedDeliveries
+		historyFile, machineFile, webhookDeliveryFile = savedHistoryFile, savedMachineFile, savedDeliveryFile
+	})
+}
+
+func TestRevokeLicenseKeepsRecord(t *testing.T) {
+	withStore(t, []HistoryRecord{{ID: "h1", MachineID: "M-1", ExpiryDate: "2999-01-01", GenerateTime: "2026-01-01T00:00:00Z"}},
+		[]Webhook{{ID: "w1", URL: "https://example.com/hook", Events: []string{eventLicenseRevoked}}})
+	p := &principal{Name: "ops", Scopes: []string{scopeDelete}}
+
+	rec, ok := revokeLicense(context.Background(), "h1", p)
+	if !ok || rec.RevokedAt == "" || rec.RevokedBy != "ops" { t.Fatalf("吊销失败: %+v", rec) }
+	if len(historyList) != 1 || historyList[0].RevokedAt == "" { t.Fatal("吊销后记录应保留并标记") }
+	if got := toLicenseV1(historyList[0], today()).Status; got != "revoked" { t.Fatalf("status = %q，期望 revoked", got) }
+	if len(deliveryList) != 1 || deliveryList[0].Event != eventLicenseRevoked { t.Fatalf("应推送一次 license.revoked，实际 %+v", deliveryList) }
+
+	if _, ok := revokeLicense(context.Background(), "h1", p); !ok || len(deliveryList) != 1 { t.Fatal("重复吊销不应再次推送") }
+	if _, ok := revokeLicense(context.Background(), "missing", p); ok { t.Fatal("不存在的记录应返回 false") }
+}
+
+func TestLicenseStatusFilter(t *testing.T) {
+	day := "2026-06-01"
+	recs := map[string]HistoryRecord{
+		"active":  {ExpiryDate: "2026-07-01"},
+		"expired": {ExpiryDate: "2026-05-01"},
+		"revoked": {ExpiryDate: "2026-07-01", RevokedAt: "2026-05-20T00:00:00Z"},
+	}
+	for want, rec := range recs {
+		if got := licenseStatus(rec, day); got != want { t.Errorf("licenseStatus = %q，期望 %q", got, want) }
+		for status := range recs {
+			if got := (recordFilter{Status: status}).MatchHistory(rec, day); got != (status == want) { t.Errorf("status=%s 筛选 %s 记录: %v", status, want, got) }
+		}
+	}
+}
+
+func TestResellerCannotTakeOverMachine(t *testing.T) {
+	withStore(t, nil, nil)
+	machineList = []MachineRecord{{MachineID: "M-1", LastSeen: "2026-01-01T00:00:00Z", Owner: "alice"}, {MachineID: "M-2", LastSeen: "2026-01-01T00:00:00Z"}}
+	bob := &principal{Name: "bob", Owner: "bob", Scopes: []string{scopeGenerate}}
+
+	if _, err := issueLicense(context.Background(), bob, "M-1", "2999-01-01", ""); !errors.Is(err, errMachineOwned) { t.Fatalf("期望 errMachineOwned，实际 %v", err) }
+	if _, err := saveData("M-1", "2999-01-01", "C", "bob", "bob", ""); !errors.Is(err, errMachineOwned) { t.Fatalf("saveData 也应拒绝，实际 %v", err) }
+	if len(historyList) != 0 || machineList[0].Owner != "alice" { t.Fatalf("不应写入记录或改归属: %+v %+v", historyList, machineList[0]) }
+
+	// 未归属的机器可以认领；管理员生成不改变已有归属
+	if _, err := saveData("M-2", "2999-01-01", "C", "bob", "bob", ""); err != nil || machineList[1].Owner != "bob" { t.Fatalf("认领失败: %v %+v", err, machineList[1]) }
+	if _, err := saveData("M-1", "2999-01-01", "C", "admin", "", ""); err != nil || machineList[0].Owner != "alice" { t.Fatalf("管理员生成后归属应不变: %v %+v", err, machineList[0]) }
+}
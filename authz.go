@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ================= 统一鉴权 =================
+// 所有接口和页面都通过 authorize / authorizePage 检查权限，不在各个 handler 里单独判断。
+// 调用者可能是登录的用户（按角色授予权限），也可能是 API Token（按 Token 自身的权限范围）。
+// API 调用统一使用 Authorization: Bearer <Token>（或客户端证书 / HMAC 签名，见 machineauth.go），
+// 由 authMiddleware 在 handler 之前完成认证；请求体、表单和 URL 里的 token 字段只作为已弃用的兼容方式保留。
+// 401 表示没有凭据或凭据无效（带 WWW-Authenticate），403 表示身份有效但不允许这样做。
+
+const (
+	roleAdmin    = "admin"    // 全部权限：密钥、Token、用户管理
+	roleOperator = "operator" // 生成激活码、查看记录
+	roleViewer   = "viewer"   // 只读
+	roleReseller = "reseller" // 生成激活码，只能看到自己客户的机器和记录
+)
+
+var allRoles = []string{roleAdmin, roleOperator, roleViewer, roleReseller}
+
+// scopeSelf 只要求已登录，用于账号自己的设置（例如两步验证），必须开启两步验证但尚未开启的用户也能访问
+const scopeSelf = "self"
+
+var roleScopes = map[string][]string{
+	roleAdmin:    {scopeAdmin},
+	roleOperator: {scopeGenerate, scopeReadHistory},
+	roleViewer:   {scopeReadHistory},
+	roleReseller: {scopeGenerate, scopeReadHistory},
+}
+
+// principal 当前请求的调用者
+type principal struct {
+	Name   string
+	Role   string // 用户登录时为角色，API Token 为空
+	Scopes []string
+	Owner  string // 非空时只能访问归属于该经销商的数据
+	Token  *APIToken
+	User   *User
+
+	MFAEnrollRequired bool // 必须开启两步验证但尚未开启，只能访问 scopeSelf
+}
+
+func tokenPrincipal(t *APIToken) *principal {
+	return &principal{Name: t.Name, Scopes: t.Scopes, Token: t}
+}
+
+func userPrincipal(u *User) *principal {
+	p := &principal{Name: u.Username, Role: u.Role, Scopes: roleScopes[u.Role], User: u}
+	if u.Role == roleReseller { p.Owner = u.Username }
+	p.MFAEnrollRequired = u.totpRequired() && !u.totpEnabled()
+	return p
+}
+
+func (p *principal) HasScope(scope string) bool {
+	if scope == scopeSelf { return true }
+	for _, s := range p.Scopes {
+		if s == scope || s == scopeAdmin { return true }
+	}
+	return false
+}
+
+// checkLicenseDuration 只有 Token 有最长授权天数限制
+func (p *principal) checkLicenseDuration(expiry string) error {
+	if p.Token == nil { return nil }
+	err := p.Token.checkLicenseDuration(expiry)
+	if err != nil { metricGenerateErrors.WithLabelValues("duration_exceeded").Inc() }
+	return err
+}
+
+// recordFilter 解析页面/导出的筛选条件，经销商只能看到自己的数据
+func (p *principal) recordFilter(q url.Values) recordFilter {
+	f := parseRecordFilter(q)
+	f.Owner = p.Owner
+	return f
+}
+
+const authRealm = "license-server"
+
+type ctxKey int
+
+const (
+	ctxPrincipal ctxKey = iota
+	ctxRequestInfo
+)
+
+var errNoCredentials = &authError{401, "缺少 Token，请使用 Authorization: Bearer <Token>"}
+
+// writeAuthError 按 RFC 6750 写出认证失败：401 带上 Bearer 质询，凭据无效时注明 invalid_token
+func writeAuthError(w http.ResponseWriter, r *http.Request, err error) {
+	ae, ok := err.(*authError)
+	if !ok { ae = &authError{500, err.Error()} }
+	if ae.Status == 401 {
+		challenge := `Bearer realm="` + authRealm + `"`
+		if ae != errNoCredentials { challenge += `, error="invalid_token"` }
+		w.Header().Set("WWW-Authenticate", challenge)
+	}
+	writeError(w, r, ae.Status, ae.Code(), ae.Message)
+}
+
+// Code 机器可读的错误码，见 api_v1.go
+func (e *authError) Code() string {
+	switch {
+	case e == errNoCredentials:
+		return "unauthorized"
+	case e.Status == 401:
+		return "invalid_token"
+	}
+	return codeForStatus(e.Status)
+}
+
+func writeScopeError(w http.ResponseWriter, r *http.Request, p *principal, scope string) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s", error="insufficient_scope", scope="%s"`, authRealm, scope))
+	writeError(w, r, 403, "insufficient_scope", fmt.Sprintf("「%s」没有 %s 权限", p.Name, scope))
+}
+
+// bearerToken 读取 Authorization: Bearer，其他认证方式（例如代理的 Basic）忽略
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") { return "" }
+	return strings.TrimSpace(token)
+}
+
+// authMiddleware 在 handler 读取请求体之前依次尝试客户端证书、HMAC 签名和 Bearer Token，
+// 认证通过的调用者放进请求上下文交给 authorize；带了凭据但无效时直接拒绝。没有凭据的请求原样放行（页面走登录会话）。
+func authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t, err := certToken(r)
+		method := "mTLS"
+		if err == nil && t == nil && r.Header.Get("X-Auth-Signature") != "" {
+			if !checkLockout(w, r, "") { return }
+			method = "HMAC"
+			t, err = hmacToken(w, r)
+		}
+		if err == nil && t == nil {
+			if secret := bearerToken(r); secret != "" {
+				if !checkLockout(w, r, "") { return }
+				method = "Bearer"
+				t, err = authenticateToken(r, secret)
+			}
+		}
+		if err != nil {
+			recordAuthFailure(r, "", method+": "+err.Error())
+			writeAuthError(w, r, err)
+			return
+		}
+		if t != nil {
+			recordAuthSuccess(r, "")
+			p := tokenPrincipal(t)
+			noteRequestPrincipal(r.Context(), p)
+			r = r.WithContext(context.WithValue(r.Context(), ctxPrincipal, p))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func requestPrincipal(r *http.Request) *principal {
+	p, _ := r.Context().Value(ctxPrincipal).(*principal)
+	return p
+}
+
+var (
+	deprecatedWarned = map[string]time.Time{}
+	deprecatedMutex  sync.Mutex
+)
+
+// warnDeprecatedToken 每个 Token 每小时最多提醒一次，避免刷屏
+func warnDeprecatedToken(r *http.Request, p *principal) {
+	deprecatedMutex.Lock()
+	last := deprecatedWarned[p.Name]
+	warn := time.Since(last) > time.Hour
+	if warn { deprecatedWarned[p.Name] = time.Now() }
+	deprecatedMutex.Unlock()
+	if warn { slog.WarnContext(r.Context(), "⚠️ 在请求体/URL 中传递 Token，该方式已弃用，请改用 Authorization: Bearer", "principal", p.Name, "method", r.Method, "path", r.URL.Path) }
+}
+
+// sessionPrincipal 每次请求都重新检查登录的用户或 Token，禁用、吊销或过期后会话立即失效
+func sessionPrincipal(r *http.Request, s *session) (*principal, error) {
+	if s.MFAPending { return nil, &authError{401, "需要完成两步验证"} }
+	if s.UserID != "" {
+		u, err := activeUser(s.UserID)
+		if err != nil { return nil, err }
+		return userPrincipal(u), nil
+	}
+	t, err := sessionToken(r, s)
+	if err != nil { return nil, err }
+	return tokenPrincipal(t), nil
+}
+
+// authorize 校验请求并要求指定权限，失败时直接写入错误响应。
+// authMiddleware 已认证的调用者直接使用；secret 是请求体/表单/URL 里的旧式 token 字段，
+// 没有 Authorization 头时才按它鉴权（已弃用）；都没有时使用登录会话（页面调用）。
+func authorize(w http.ResponseWriter, r *http.Request, secret, scope string) (*principal, bool) {
+	var p *principal
+	var err error
+	if rp := requestPrincipal(r); rp != nil {
+		p = rp
+	} else if secret != "" {
+		if !checkLockout(w, r, "") { return nil, false }
+		var t *APIToken
+		if t, err = authenticateToken(r, secret); err == nil {
+			p = tokenPrincipal(t)
+			recordAuthSuccess(r, "")
+			warnDeprecatedToken(r, p)
+		} else {
+			recordAuthFailure(r, "", "Token: "+err.Error())
+		}
+	} else if s := currentSession(r); s != nil {
+		if !isSafeMethod(r.Method) && !validCSRF(r, s) {
+			err = &authError{403, "CSRF 校验失败"}
+		} else {
+			p, err = sessionPrincipal(r, s)
+		}
+	} else {
+		err = errNoCredentials
+	}
+	if err != nil {
+		writeAuthError(w, r, err)
+		return nil, false
+	}
+	noteRequestPrincipal(r.Context(), p)
+	if !allowPrincipal(w, r, p) { return nil, false }
+	if p.MFAEnrollRequired && scope != scopeSelf {
+		writeError(w, r, 403, "mfa_enrollment_required", "请先在 /account 启用两步验证")
+		return nil, false
+	}
+	if !p.HasScope(scope) {
+		writeScopeError(w, r, p, scope)
+		return nil, false
+	}
+	return p, true
+}
+
+// authorizePage 页面专用：未登录时跳转到登录页，scope 为空表示只要求已登录
+func authorizePage(w http.ResponseWriter, r *http.Request, scope string) (*principal, *session, bool) {
+	s := currentSession(r)
+	if s == nil {
+		http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+		return nil, nil, false
+	}
+	if s.MFAPending {
+		http.Redirect(w, r, "/login/otp?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+		return nil, nil, false
+	}
+	p, err := sessionPrincipal(r, s)
+	if err != nil {
+		destroySession(s.ID)
+		http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+		return nil, nil, false
+	}
+	noteRequestPrincipal(r.Context(), p)
+	if !allowPrincipal(w, r, p) { return nil, nil, false }
+	if p.MFAEnrollRequired && scope != scopeSelf {
+		http.Redirect(w, r, "/account", http.StatusSeeOther)
+		return nil, nil, false
+	}
+	if scope != "" && !p.HasScope(scope) {
+		http.Error(w, fmt.Sprintf("「%s」没有 %s 权限", p.Name, scope), 403)
+		return nil, nil, false
+	}
+	return p, s, true
+}
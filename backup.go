@@ -0,0 +1,478 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ================= 备份配置 =================
+// BACKUP_TARGET 为空时不启用定时备份。
+//   本地目录:  BACKUP_TARGET=/data/backups
+//   S3 兼容:   BACKUP_TARGET=s3://bucket/prefix （配合 S3_* 变量，MinIO 亦可）
+
+var (
+	BackupTarget        = getEnv("BACKUP_TARGET", "")
+	BackupInterval      = getEnvDuration("BACKUP_INTERVAL", 24*time.Hour)
+	BackupKeepDaily     = getEnvInt("BACKUP_KEEP_DAILY", 7)
+	BackupKeepWeekly    = getEnvInt("BACKUP_KEEP_WEEKLY", 4)
+	BackupIncludeKey    = isTruthy(getEnv("BACKUP_INCLUDE_KEY", ""))
+	BackupKeyPassphrase = getEnv("BACKUP_KEY_PASSPHRASE", "")
+)
+
+const (
+	backupPrefix     = "backup-"
+	backupSuffix     = ".tar.gz"
+	backupTimeLayout = "20060102-150405"
+	backupKeyEntry   = "private.pem.enc" // 当前签名密钥，恢复时设为 ACTIVE
+	backupKeysPrefix = "keys/"           // KEYS_DIR 中的全部密钥，逐个用口令加密
+	// 备份包里的文件名固定，与 HISTORY_FILE / MACHINES_FILE 配置的路径无关
+	backupHistoryEntry  = "history.json"
+	backupMachinesEntry = "machines.json"
+)
+
+type backupManifest struct {
+	CreatedAt    string `json:"created_at"`
+	HistoryCount int    `json:"history_count"`
+	MachineCount int    `json:"machine_count"`
+	IncludesKey  bool   `json:"includes_key"`
+	KeyCount     int    `json:"key_count,omitempty"`
+}
+
+// backupDataFile 除历史记录和机器码外一同备份的数据文件
+type backupDataFile struct {
+	entry, kind string
+	path        *string
+	snapshot    func() ([]byte, error) // 在对应的锁里序列化内存中的数据
+	target      func() interface{}     // 恢复前校验用
+}
+
+func backupDataFiles() []backupDataFile {
+	return []backupDataFile{
+		{"tokens.json", "tokens", &tokensFile,
+			func() ([]byte, error) { tokenMutex.Lock(); defer tokenMutex.Unlock(); return encodeDataFile("tokens", tokenList) },
+			func() interface{} { return &[]APIToken{} }},
+		{"users.json", kindUsers, &usersFile,
+			func() ([]byte, error) { userMutex.Lock(); defer userMutex.Unlock(); return encodeDataFile(kindUsers, userList) },
+			func() interface{} { return &[]User{} }},
+		{"customers.json", kindCustomers, &customersFile,
+			func() ([]byte, error) { customerMutex.Lock(); defer customerMutex.Unlock(); return encodeDataFile(kindCustomers, customerList) },
+			func() interface{} { return &[]Customer{} }},
+		{"webhooks.json", kindWebhooks, &webhooksFile,
+			func() ([]byte, error) { webhookMutex.Lock(); defer webhookMutex.Unlock(); return encodeDataFile(kindWebhooks, webhookList) },
+			func() interface{} { return &[]Webhook{} }},
+		{"webhook_deliveries.json", kindWebhookDeliveries, &webhookDeliveryFile,
+			func() ([]byte, error) { webhookMutex.Lock(); defer webhookMutex.Unlock(); return encodeDataFile(kindWebhookDeliveries, deliveryList) },
+			func() interface{} { return &[]WebhookDelivery{} }},
+	}
+}
+
+// keyDirFiles KEYS_DIR 中的私钥和公钥文件名；ACTIVE 不备份，恢复时由 private.pem.enc 决定
+func keyDirFiles() ([]string, error) {
+	entries, err := os.ReadDir(KeysDir)
+	if os.IsNotExist(err) { return nil, nil }
+	if err != nil { return nil, err }
+	var names []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && strings.HasSuffix(e.Name(), ".pem") { names = append(names, e.Name()) }
+	}
+	return names, nil
+}
+
+// ================= 存储后端 =================
+
+type backupStorage interface {
+	Put(name string, data []byte) error
+	Get(name string) ([]byte, error)
+	List() ([]string, error)
+	Delete(name string) error
+	String() string
+}
+
+func openBackupStorage(target string) (backupStorage, error) {
+	if target == "" { return nil, fmt.Errorf("未配置 BACKUP_TARGET") }
+	if strings.HasPrefix(target, "s3://") { return newS3Storage(target) }
+	dir := strings.TrimPrefix(target, "file://")
+	if err := os.MkdirAll(dir, 0700); err != nil { return nil, fmt.Errorf("创建备份目录失败: %v", err) }
+	return localStorage{dir: dir}, nil
+}
+
+type localStorage struct{ dir string }
+
+func (s localStorage) String() string { return s.dir }
+
+func (s localStorage) Put(name string, data []byte) error {
+	return writeFileAtomic(filepath.Join(s.dir, name), data, 0600)
+}
+
+func (s localStorage) Get(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(s.dir, filepath.Base(name)))
+}
+
+func (s localStorage) List() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil { return nil, err }
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() { names = append(names, e.Name()) }
+	}
+	return names, nil
+}
+
+func (s localStorage) Delete(name string) error { return os.Remove(filepath.Join(s.dir, filepath.Base(name))) }
+
+// ================= 生成与恢复快照 =================
+
+func backupName(t time.Time) string { return backupPrefix + t.UTC().Format(backupTimeLayout) + backupSuffix }
+
+func parseBackupName(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, backupPrefix) || !strings.HasSuffix(name, backupSuffix) { return time.Time{}, false }
+	t, err := time.Parse(backupTimeLayout, strings.TrimSuffix(strings.TrimPrefix(name, backupPrefix), backupSuffix))
+	return t, err == nil
+}
+
+// buildSnapshot 打包当前内存中的全部数据，密钥只在配置了口令时加密后附带
+func buildSnapshot(includeKey bool) ([]byte, error) {
+	// 与数据文件相同，启用字段加密时备份里也是密文
+	mutex.Lock()
+	hist, err1 := encodeHistoryForDisk(historyList)
+	machines, err2 := encodeMachinesForDisk(machineList)
+	manifest := backupManifest{CreatedAt: time.Now().UTC().Format(time.RFC3339), HistoryCount: len(historyList), MachineCount: len(machineList)}
+	mutex.Unlock()
+	if err1 != nil || err2 != nil { return nil, fmt.Errorf("加密数据失败") }
+	historyJSON, err1 := encodeDataFile(kindHistory, hist)
+	machineJSON, err2 := encodeDataFile(kindMachines, machines)
+	if err1 != nil || err2 != nil { return nil, fmt.Errorf("序列化数据失败") }
+
+	files := map[string][]byte{backupHistoryEntry: historyJSON, backupMachinesEntry: machineJSON}
+	for _, f := range backupDataFiles() {
+		data, err := f.snapshot()
+		if err != nil { return nil, fmt.Errorf("序列化 %s 失败: %v", f.entry, err) }
+		files[f.entry] = data
+	}
+	if includeKey {
+		if BackupKeyPassphrase == "" { return nil, fmt.Errorf("备份私钥需要设置 BACKUP_KEY_PASSPHRASE") }
+		keyPem, err := readPrivateKeyPEM()
+		if err != nil { return nil, err }
+		enc, err := encryptWithPassphrase(keyPem, BackupKeyPassphrase)
+		if err != nil { return nil, err }
+		files[backupKeyEntry] = enc
+		names, err := keyDirFiles()
+		if err != nil { return nil, fmt.Errorf("读取 KEYS_DIR 失败: %v", err) }
+		for _, name := range names {
+			raw, err := os.ReadFile(filepath.Join(KeysDir, name))
+			if err != nil { return nil, err }
+			if files[backupKeysPrefix+name+".enc"], err = encryptWithPassphrase(raw, BackupKeyPassphrase); err != nil { return nil, err }
+		}
+		manifest.IncludesKey, manifest.KeyCount = true, len(names)
+	}
+	files["manifest.json"], _ = json.MarshalIndent(manifest, "", "  ")
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	names := make([]string, 0, len(files))
+	for name := range files { names = append(names, name) }
+	sort.Strings(names)
+	for _, name := range names {
+		data := files[name]
+		hdr := &tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: time.Now()}
+		if err := tw.WriteHeader(hdr); err != nil { return nil, err }
+		if _, err := tw.Write(data); err != nil { return nil, err }
+	}
+	if err := tw.Close(); err != nil { return nil, err }
+	if err := gz.Close(); err != nil { return nil, err }
+	return buf.Bytes(), nil
+}
+
+func readSnapshot(data []byte) (map[string][]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil { return nil, fmt.Errorf("备份文件格式错误: %v", err) }
+	tr := tar.NewReader(gz)
+	files := map[string][]byte{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF { break }
+		if err != nil { return nil, fmt.Errorf("备份文件损坏: %v", err) }
+		name := path.Clean(hdr.Name)
+		if path.IsAbs(name) || strings.HasPrefix(name, "../") { return nil, fmt.Errorf("备份文件包含非法路径 %q", hdr.Name) }
+		b, err := io.ReadAll(tr)
+		if err != nil { return nil, err }
+		files[name] = b
+	}
+	return files, nil
+}
+
+// runBackup 生成一次快照、上传并按保留策略清理旧备份
+func runBackup(store backupStorage) (string, error) {
+	data, err := buildSnapshot(BackupIncludeKey)
+	if err != nil { return "", err }
+	name := backupName(time.Now())
+	if err := store.Put(name, data); err != nil { return "", fmt.Errorf("上传备份失败: %v", err) }
+
+	names, err := store.List()
+	if err != nil { return name, fmt.Errorf("列出备份失败: %v", err) }
+	for _, old := range backupsToPrune(names, BackupKeepDaily, BackupKeepWeekly) {
+		if err := store.Delete(old); err != nil {
+			slog.Warn("⚠️ 删除旧备份失败", "file", old, "err", err)
+		} else {
+			slog.Info("🧹 已清理旧备份", "file", old)
+		}
+	}
+	return name, nil
+}
+
+// backupsToPrune 每天保留最新一份（最近 daily 天），每周保留最新一份（最近 weekly 周），其余删除
+func backupsToPrune(names []string, daily, weekly int) []string {
+	type item struct {
+		name string
+		t    time.Time
+	}
+	var items []item
+	for _, n := range names {
+		if t, ok := parseBackupName(n); ok { items = append(items, item{n, t}) }
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].t.After(items[j].t) })
+
+	keep := map[string]bool{}
+	if len(items) > 0 { keep[items[0].name] = true } // 最新一份永远保留
+	days, weeks := map[string]bool{}, map[string]bool{}
+	loc := bizLocation()
+	for _, it := range items {
+		local := it.t.In(loc)
+		day := local.Format("2006-01-02")
+		if !days[day] && len(days) < daily { days[day] = true; keep[it.name] = true }
+		y, w := local.ISOWeek()
+		week := fmt.Sprintf("%d-W%02d", y, w)
+		if !weeks[week] && len(weeks) < weekly { weeks[week] = true; keep[it.name] = true }
+	}
+	var prune []string
+	for _, it := range items {
+		if !keep[it.name] { prune = append(prune, it.name) }
+	}
+	return prune
+}
+
+// startBackupScheduler 启动时先备份一次，之后按 BACKUP_INTERVAL 定时备份；
+// 否则间隔较长时，重启频繁的部署可能一直没有备份
+func startBackupScheduler() {
+	if BackupTarget == "" { return }
+	store, err := openBackupStorage(BackupTarget)
+	if err != nil { slog.Error("❌ 备份未启用", "err", err); return }
+	slog.Info("✅ 定时备份已启用", "target", store.String(), "interval", BackupInterval, "keep_daily", BackupKeepDaily, "keep_weekly", BackupKeepWeekly)
+
+	interval := BackupInterval
+	go func() {
+		if !scheduledBackup(store) { return }
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-shutdownCh:
+				return
+			}
+			if !scheduledBackup(store) { return }
+		}
+	}()
+}
+
+// scheduledBackup 正在关闭时返回 false
+func scheduledBackup(store backupStorage) bool {
+	if !beginJob() { return false }
+	defer endJob()
+	if name, err := runBackup(store); err != nil {
+		slog.Error("❌ 定时备份失败", "err", err)
+	} else {
+		slog.Info("💾 定时备份完成", "file", name)
+	}
+	return true
+}
+
+// ================= 命令行: backup / restore =================
+
+// ./server backup [-target ...]  直接读本地数据文件和密钥，没有 -remote，见 cli.go 开头的说明
+func cmdBackup(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	target := fs.String("target", BackupTarget, "备份目标（本地目录或 s3://bucket/prefix）")
+	fs.Parse(args)
+
+	store, err := openBackupStorage(*target)
+	if err != nil { return err }
+	// 快照包含全部数据文件，都要先读进内存，否则会备份出空的 Token / 用户列表
+	safeLoadData()
+	loadTokens()
+	loadUsers()
+	loadCustomers()
+	loadWebhooks()
+	name, err := runBackup(store)
+	if err != nil { return err }
+	slog.Info("💾 备份完成", "target", store.String(), "file", name)
+	return nil
+}
+
+// ./server restore [-target ...] [-with-key] [-list] <备份名|latest>
+// 需在服务停止时执行，直接覆盖数据文件
+func cmdRestore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	target := fs.String("target", BackupTarget, "备份目标（本地目录或 s3://bucket/prefix）")
+	withKey := fs.Bool("with-key", false, "同时恢复 KEYS_DIR 中的密钥，并把备份时的签名密钥设为当前密钥（需要 BACKUP_KEY_PASSPHRASE）")
+	list := fs.Bool("list", false, "只列出可用备份")
+	fs.Parse(args)
+
+	store, err := openBackupStorage(*target)
+	if err != nil { return err }
+	names, err := store.List()
+	if err != nil { return err }
+	var backups []string
+	for _, n := range names {
+		if _, ok := parseBackupName(n); ok { backups = append(backups, n) }
+	}
+	sort.Strings(backups)
+
+	if *list {
+		for _, n := range backups { fmt.Println(n) }
+		return nil
+	}
+	name := fs.Arg(0)
+	if name == "" { return fmt.Errorf("用法: restore [-with-key] <备份名|latest>") }
+	if name == "latest" {
+		if len(backups) == 0 { return fmt.Errorf("没有可用的备份") }
+		name = backups[len(backups)-1]
+	}
+
+	data, err := store.Get(name)
+	if err != nil { return fmt.Errorf("读取备份失败: %v", err) }
+	files, err := readSnapshot(data)
+	if err != nil { return err }
+
+	// 先校验再落盘，避免恢复出一半的数据
+	var hist []HistoryRecord
+	var machines []MachineRecord
+	// 旧版本的备份原样写回，下次启动时由迁移升级
+	if _, err := decodeDataFile(kindHistory, files[backupHistoryEntry], &hist); err != nil { return fmt.Errorf("备份中的历史记录无效: %v", err) }
+	if _, err := decodeDataFile(kindMachines, files[backupMachinesEntry], &machines); err != nil { return fmt.Errorf("备份中的机器码无效: %v", err) }
+	// 确认当前密钥能解开备份，避免恢复后服务无法启动
+	if err := decodeHistoryFromDisk(hist); err != nil { return err }
+	if err := decodeMachinesFromDisk(machines); err != nil { return err }
+	// 旧备份只有历史记录和机器码，缺少的文件保持现状
+	var extra []backupDataFile
+	for _, f := range backupDataFiles() {
+		data, ok := files[f.entry]
+		if !ok { slog.Warn("⚠️ 备份中没有该文件，保留现有数据", "entry", f.entry); continue }
+		if _, err := decodeDataFile(f.kind, data, f.target()); err != nil { return fmt.Errorf("备份中的 %s 无效: %v", f.entry, err) }
+		extra = append(extra, f)
+	}
+	var keyPem []byte
+	keyFiles := map[string][]byte{}
+	if *withKey {
+		enc, ok := files[backupKeyEntry]
+		if !ok { return fmt.Errorf("该备份不包含私钥") }
+		if BackupKeyPassphrase == "" { return fmt.Errorf("恢复私钥需要设置 BACKUP_KEY_PASSPHRASE") }
+		if keyPem, err = decryptWithPassphrase(enc, BackupKeyPassphrase); err != nil { return err }
+		for entry, enc := range files {
+			if !strings.HasPrefix(entry, backupKeysPrefix) { continue }
+			keyName := strings.TrimSuffix(strings.TrimPrefix(entry, backupKeysPrefix), ".enc")
+			if strings.Contains(keyName, "/") || !strings.HasSuffix(keyName, ".pem") { return fmt.Errorf("备份中的密钥文件名无效: %q", entry) }
+			if keyFiles[keyName], err = decryptWithPassphrase(enc, BackupKeyPassphrase); err != nil { return err }
+		}
+	}
+
+	if err := writeFileAtomic(historyFile, files[backupHistoryEntry], 0600); err != nil { return err }
+	if err := writeFileAtomic(machineFile, files[backupMachinesEntry], 0600); err != nil { return err }
+	for _, f := range extra {
+		if err := writeFileAtomic(*f.path, files[f.entry], 0600); err != nil { return err }
+	}
+	keyID := "未恢复"
+	if keyPem != nil {
+		if err := os.MkdirAll(KeysDir, 0700); err != nil { return err }
+		for keyName, raw := range keyFiles {
+			if err := writeFileAtomic(filepath.Join(KeysDir, keyName), raw, 0600); err != nil { return err }
+		}
+		if keyID, err = installKey(keyPem); err != nil { return err }
+	}
+	slog.Info("♻️ 已从备份恢复", "file", name, "history", len(hist), "machines", len(machines), "files", len(extra), "keys", len(keyFiles), "key_id", keyID)
+	return nil
+}
+
+// ================= 工具函数 =================
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil { return err }
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil { tmp.Close(); return err }
+	if err := tmp.Chmod(perm); err != nil { tmp.Close(); return err }
+	if err := tmp.Close(); err != nil { return err }
+	return os.Rename(tmp.Name(), path)
+}
+
+// 口令加密格式: "JHM1" | salt(16) | nonce(12) | AES-256-GCM 密文
+const passphraseMagic = "JHM1"
+const pbkdf2Iterations = 200000
+
+func encryptWithPassphrase(plain []byte, passphrase string) ([]byte, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil { return nil, err }
+	gcm, err := newGCM(pbkdf2SHA256([]byte(passphrase), salt, pbkdf2Iterations, 32))
+	if err != nil { return nil, err }
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil { return nil, err }
+	out := append([]byte(passphraseMagic), salt...)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, plain, []byte(passphraseMagic)), nil
+}
+
+func decryptWithPassphrase(data []byte, passphrase string) ([]byte, error) {
+	if len(data) < len(passphraseMagic)+16+12 || string(data[:4]) != passphraseMagic { return nil, fmt.Errorf("加密数据格式错误") }
+	salt, rest := data[4:20], data[20:]
+	gcm, err := newGCM(pbkdf2SHA256([]byte(passphrase), salt, pbkdf2Iterations, 32))
+	if err != nil { return nil, err }
+	plain, err := gcm.Open(nil, rest[:gcm.NonceSize()], rest[gcm.NonceSize():], []byte(passphraseMagic))
+	if err != nil { return nil, fmt.Errorf("解密失败，口令错误或数据损坏") }
+	return plain, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil { return nil, err }
+	return cipher.NewGCM(block)
+}
+
+// PBKDF2-HMAC-SHA256 (RFC 8018)
+func pbkdf2SHA256(password, salt []byte, iter, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	var out []byte
+	for block := uint32(1); len(out) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write([]byte{byte(block >> 24), byte(block >> 16), byte(block >> 8), byte(block)})
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < iter; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t { t[j] ^= u[j] }
+		}
+		out = append(out, t...)
+	}
+	return out[:keyLen]
+}
+
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// withDataDir 把所有数据文件和 KEYS_DIR 指向 dir，返回时恢复
+func withDataDir(t *testing.T, dir string) {
+	t.Helper()
+	paths := []*string{&historyFile, &machineFile, &tokensFile, &usersFile, &customersFile, &webhooksFile, &webhookDeliveryFile, &KeysDir}
+	saved := make([]string, len(paths))
+	for i, p := range paths { saved[i] = *p; *p = filepath.Join(dir, filepath.Base(*p)) }
+	t.Cleanup(func() {
+		for i, p := range paths { *p = saved[i] }
+	})
+}
+
+func TestBackupRestoreRoundTrip(t *testing.T) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	withStore(t, []HistoryRecord{{ID: "h1", MachineID: "M-1", ExpiryDate: "2999-01-01", LicenseCode: "C", GenerateTime: now}},
+		[]Webhook{{ID: "w1", URL: "https://example.com/hook", Events: []string{eventLicenseGenerated}, CreatedAt: now}})
+	machineList = []MachineRecord{{MachineID: "M-1", LastSeen: now}}
+	deliveryList = []WebhookDelivery{{ID: "d1", WebhookID: "w1", EventID: "e1", Event: eventLicenseGenerated, Payload: "{}", CreatedAt: now}}
+	savedTokens, savedUsers, savedCustomers := tokenList, userList, customerList
+	tokenList = []APIToken{{ID: "t1", Name: "billing", Scopes: []string{scopeGenerate}, LastUsedAt: now}}
+	userList = []User{{ID: "u1", Username: "alice", Role: "admin", CreatedAt: now}}
+	customerList = []Customer{{ID: "c1", Name: "ACME"}}
+	t.Cleanup(func() { tokenList, userList, customerList = savedTokens, savedUsers, savedCustomers })
+	savedPass := BackupKeyPassphrase
+	BackupKeyPassphrase = "correct horse battery staple"
+	t.Cleanup(func() { BackupKeyPassphrase = savedPass })
+
+	src := t.TempDir()
+	withDataDir(t, src)
+	oldID, _, _, err := generateSigningKey()
+	if err != nil { t.Fatal(err) }
+	activeID, _, _, err := generateSigningKey()
+	if err != nil { t.Fatal(err) }
+
+	data, err := buildSnapshot(true)
+	if err != nil { t.Fatal(err) }
+	store := localStorage{dir: t.TempDir()}
+	if err := store.Put(backupName(time.Now()), data); err != nil { t.Fatal(err) }
+
+	dst := t.TempDir()
+	withDataDir(t, dst)
+	if err := cmdRestore([]string{"-target", store.dir, "-with-key", "latest"}); err != nil { t.Fatal(err) }
+
+	files, err := readSnapshot(data)
+	if err != nil { t.Fatal(err) }
+	for _, name := range []string{"tokens.json", "users.json", "customers.json", "webhooks.json", "webhook_deliveries.json"} {
+		if !reflect.DeepEqual(files[name], mustRead(t, filepath.Join(dst, name))) { t.Errorf("%s 恢复内容不一致", name) }
+	}
+	var tokens []APIToken
+	if _, err := loadDataFile(tokensFile, "tokens", &tokens); err != nil || !reflect.DeepEqual(tokens, tokenList) { t.Errorf("Token 读回 %+v %v", tokens, err) }
+	var hist []HistoryRecord
+	if _, err := loadDataFile(historyFile, kindHistory, &hist); err != nil || len(hist) != 1 || hist[0].ID != "h1" { t.Errorf("历史记录读回 %+v %v", hist, err) }
+	for _, name := range []string{"history.json", "machines.json", "tokens.json", "users.json"} {
+		fi, err := os.Stat(filepath.Join(dst, name))
+		if err != nil { t.Fatal(err) }
+		if fi.Mode().Perm() != 0600 { t.Errorf("%s 权限 %v，期望 0600", name, fi.Mode().Perm()) }
+	}
+	for _, id := range []string{oldID, activeID} {
+		if _, err := os.Stat(keyPath(id)); err != nil { t.Errorf("密钥 %s 未恢复: %v", id, err) }
+	}
+	if got := activeKeyID(); got != activeID { t.Errorf("ACTIVE = %q，期望 %q", got, activeID) }
+}
+
+func mustRead(t *testing.T, path string) []byte {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil { t.Fatal(err) }
+	return b
+}
+
+func TestBackupSchedulerRunsAtStart(t *testing.T) {
+	withStore(t, nil, nil)
+	withDataDir(t, t.TempDir())
+	target := t.TempDir()
+	savedTarget, savedInterval, savedKey := BackupTarget, BackupInterval, BackupIncludeKey
+	BackupTarget, BackupInterval, BackupIncludeKey = target, time.Hour, false
+	t.Cleanup(func() { BackupTarget, BackupInterval, BackupIncludeKey = savedTarget, savedInterval, savedKey })
+
+	startBackupScheduler()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if names, _ := (localStorage{dir: target}).List(); len(names) > 0 { backgroundJobs.Wait(); return }
+	}
+	t.Fatal("启动后没有立即备份")
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// ================= 首次启动引导 =================
+// 没有配置任何管理员凭据（管理员 Token 或管理员用户）时，自动生成一个管理员 Token，只保存哈希，明文只出现一次：
+//   - 设置了 BOOTSTRAP_SECRET_FILE 时写入该文件（权限 0600，文件已存在则拒绝覆盖）
+//   - 否则打印到启动日志
+// STRICT_AUTH=1 时，发现弱口令或默认口令直接拒绝启动。
+
+var (
+	StrictAuth          = isTruthy(getEnv("STRICT_AUTH", ""))
+	BootstrapSecretFile = getEnv("BOOTSTRAP_SECRET_FILE", "")
+)
+
+const bootstrapTokenName = "bootstrap-admin"
+
+// 旧版本的默认值，任何模式下都不允许使用
+const legacyDefaultToken = "123456"
+
+var weakSecrets = []string{legacyDefaultToken, "12345678", "password", "admin", "changeme", "secret", "token", "test", "qwerty"}
+
+// weakSecretReason 返回口令太弱的原因，足够强时返回空字符串
+func weakSecretReason(secret string) string {
+	lower := strings.ToLower(secret)
+	for _, w := range weakSecrets {
+		if lower == w { return "是常见的默认口令" }
+	}
+	if len(secret) < 16 { return fmt.Sprintf("长度只有 %d 位（至少 16 位）", len(secret)) }
+	distinct := map[rune]bool{}
+	for _, r := range secret { distinct[r] = true }
+	if len(distinct) < 8 { return "字符种类太少" }
+	return ""
+}
+
+// checkCredentials 在开始监听之前调用，返回错误时服务不应启动
+func checkCredentials() error {
+	if SecurityToken == legacyDefaultToken {
+		return fmt.Errorf("SECURITY_TOKEN 仍是默认值 %s，请更换为随机生成的长口令，或改用 /tokens 创建的 Token、/users 创建的账号", legacyDefaultToken)
+	}
+	if SecurityToken != "" {
+		if reason := weakSecretReason(SecurityToken); reason != "" {
+			if StrictAuth { return fmt.Errorf("STRICT_AUTH 已开启，SECURITY_TOKEN %s", reason) }
+			slog.Warn("⚠️ SECURITY_TOKEN 强度不足，建议更换 (设置 STRICT_AUTH=1 可强制要求)", "reason", reason)
+		}
+		return nil
+	}
+
+	if hasAdminToken() || hasAdminUser() { return nil }
+	return bootstrapAdminToken()
+}
+
+func hasAdminToken() bool {
+	now := time.Now()
+	tokenMutex.Lock(); defer tokenMutex.Unlock()
+	for i := range tokenList {
+		if tokenList[i].Status(now) == "active" && containsString(tokenList[i].Scopes, scopeAdmin) { return true }
+	}
+	return false
+}
+
+func bootstrapAdminToken() error {
+	// 先确认明文有地方放，再落盘哈希，避免生成一个谁也不知道的管理员 Token
+	var secretOut *os.File
+	if BootstrapSecretFile != "" {
+		f, err := os.OpenFile(BootstrapSecretFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil { return fmt.Errorf("无法写入 BOOTSTRAP_SECRET_FILE: %v", err) }
+		secretOut = f
+	}
+
+	t, secret, _, err := createToken(CreateTokenRequest{Name: bootstrapTokenName, Scopes: []string{scopeAdmin}})
+	if err != nil {
+		if secretOut != nil { secretOut.Close(); os.Remove(BootstrapSecretFile) }
+		return fmt.Errorf("生成初始管理员 Token 失败: %v", err)
+	}
+
+	if secretOut != nil {
+		_, err := secretOut.WriteString(secret + "\n")
+		if cerr := secretOut.Close(); err == nil { err = cerr }
+		if err != nil { return fmt.Errorf("写入 BOOTSTRAP_SECRET_FILE 失败: %v", err) }
+		slog.Info("🔐 未配置任何管理员凭据，已生成初始管理员 Token，密钥已写入文件", "token_name", t.Name, "prefix", t.Prefix, "file", BootstrapSecretFile)
+		return nil
+	}
+	slog.Warn("🔐 未配置任何管理员凭据，已生成初始管理员 Token，密钥只显示这一次（见下一行），请立即保存", "token_name", t.Name)
+	// 密钥直接写 stderr，不经过日志脱敏
+	fmt.Fprintf(os.Stderr, "🔐 %s\n", secret)
+	return nil
+}
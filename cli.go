@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	"license-server/client"
+)
+
+// ================= 命令行管理工具 =================
+// 同一个二进制既是服务也是管理工具，方便通过 SSH 或 cron 脚本化运维：
+//   ./server [server]                                        启动服务（默认）
+//   ./server keygen [-force]                                 生成签名密钥，代替 /setup 页面
+//   ./server generate -machine <机器码> -expiry <YYYY-MM-DD> [-customer <客户 ID>]
+//   ./server verify [-machine <机器码>] [-pubkey <公钥文件>] <激活码>   验签，无效时退出码非 0
+//   ./server decode <激活码>                                   只解码不验签
+//   ./server history list|export [筛选条件]
+//   ./server machines list|export [筛选条件] | machines delete <机器码>
+//   ./server backup / restore                                见 backup.go
+// 默认直接读写当前目录下的数据文件。服务运行期间不要在本地执行写操作（generate、keygen、machines delete），
+// 运行中的服务不会重新读取文件，下一次保存会把改动覆盖掉；这时加 -remote https://license.example.com
+// （或设置 LICENSE_SERVER_URL）改为调用服务的 API，Token 从 LICENSE_SERVER_TOKEN 读取。
+// backup / restore 没有 -remote，只能在服务所在的机器上执行：快照里有私钥，API 不会返回私钥；
+// restore 直接覆盖数据文件，必须先停服务，运行中的服务没有整体替换数据的接口。备份只读文件，服务运行时也可以执行。
+// 选项需写在位置参数之前；激活码参数为 - 时从标准输入读取。日志输出到标准错误，标准输出只有结果。
+
+const cliPageSize = v1MaxLimit
+
+// cliFlags 每个子命令都支持 -remote
+func cliFlags(name string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	remote := fs.String("remote", os.Getenv("LICENSE_SERVER_URL"), "服务地址，为空时直接操作本地数据文件")
+	return fs, remote
+}
+
+func remoteClient(baseURL string) (*client.Client, error) {
+	token := os.Getenv("LICENSE_SERVER_TOKEN")
+	if token == "" { return nil, fmt.Errorf("远程模式需要设置环境变量 LICENSE_SERVER_TOKEN") }
+	c := client.New(baseURL, token)
+	c.UserAgent = "license-server-cli"
+	return c, nil
+}
+
+// cliPrincipal 本地操作记在「cli:系统用户名」名下，拥有全部权限
+func cliPrincipal() *principal {
+	name := "cli"
+	if u, err := user.Current(); err == nil { name += ":" + u.Username }
+	return &principal{Name: name, Scopes: []string{scopeAdmin}}
+}
+
+func cliContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 2*time.Minute)
+}
+
+// cliCodeArg 读取激活码参数，- 表示从标准输入读取
+func cliCodeArg(fs *flag.FlagSet, usage string) (string, error) {
+	code := fs.Arg(0)
+	if code == "" { return "", fmt.Errorf("用法: %s", usage) }
+	if code == "-" {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" { return "", fmt.Errorf("读取激活码失败: %v", err) }
+		code = line
+	}
+	return strings.TrimSpace(code), nil
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// ================= keygen / generate =================
+
+// ./server keygen [-force] [-remote URL]
+func cmdKeygen(args []string) error {
+	fs, remote := cliFlags("keygen")
+	force := fs.Bool("force", false, "已有签名密钥时仍然生成新密钥并设为当前密钥（旧客户端将无法验证新激活码）")
+	fs.Parse(args)
+
+	if *remote != "" {
+		c, err := remoteClient(*remote)
+		if err != nil { return err }
+		ctx, cancel := cliContext()
+		defer cancel()
+		res, err := c.SetupSigningKey(ctx, &client.SetupRequest{Force: *force})
+		if err != nil { return err }
+		slog.Info("🔑 已生成签名密钥", "key_id", res.KeyID)
+		fmt.Print(res.PublicKey)
+		return nil
+	}
+
+	if hasSigningKey() && !*force { return fmt.Errorf("已存在签名密钥，更换密钥会导致客户端无法验证新激活码；确需更换请加 -force") }
+	id, _, pubPem, err := generateSigningKey()
+	if err != nil { return err }
+	slog.Info("🔑 生成了新的签名密钥并设为当前密钥", "principal", cliPrincipal().Name, "key_id", id, "file", keyPath(id))
+	fmt.Print(string(pubPem))
+	return nil
+}
+
+// ./server generate -machine <机器码> -expiry <YYYY-MM-DD> [-customer <id>] [-idempotency-key <key>]
+// 标准输出只有激活码
+func cmdGenerate(args []string) error {
+	fs, remote := cliFlags("generate")
+	machineID := fs.String("machine", "", "机器码")
+	expiry := fs.String("expiry", "", "到期日 YYYY-MM-DD")
+	customerID := fs.String("customer", "", "关联的客户 ID")
+	idemKey := fs.String("idempotency-key", "", "远程模式下的 Idempotency-Key，脚本重试时避免重复生成")
+	fs.Parse(args)
+
+	*machineID = strings.TrimSpace(*machineID)
+	if *machineID == "" || *expiry == "" { return fmt.Errorf("用法: generate -machine <机器码> -expiry <YYYY-MM-DD>") }
+
+	if *remote != "" {
+		c, err := remoteClient(*remote)
+		if err != nil { return err }
+		ctx, cancel := cliContext()
+		defer cancel()
+		res, err := c.CreateLicense(ctx, &client.CreateLicenseParams{IdempotencyKey: *idemKey}, &client.LicenseCreate{MachineID: *machineID, Expiry: *expiry, CustomerID: *customerID})
+		if err != nil { return err }
+		fmt.Println(res.Data.LicenseCode)
+		return nil
+	}
+
+	if _, err := parseExpiry(*expiry); err != nil { return err }
+	safeLoadData()
+	loadCustomers()
+	loadWebhooks() // 事件写进投递队列，服务下次启动时发送
+	if *customerID != "" && !customerVisible(*customerID, "") { return fmt.Errorf("客户 %s 不存在", *customerID) }
+	rec, err := issueLicense(context.Background(), cliPrincipal(), *machineID, *expiry, *customerID)
+	if err != nil { return err }
+	slog.Info("✅ 已生成激活码", "id", rec.ID, "machine_id", rec.MachineID, "expiry", rec.ExpiryDate)
+	fmt.Println(rec.LicenseCode)
+	return nil
+}
+
+// ================= verify / decode =================
+
+type decodedLicense struct {
+	MachineID  string `json:"machine_id"`
+	ExpiryUTC  int64  `json:"expiry_utc"`
+	ExpiryDate string `json:"expiry_date"` // 业务时区
+	Expired    bool   `json:"expired"`
+	Signature  string `json:"signature"`
+}
+
+func toDecodedLicense(data LicenseData, signature []byte) decodedLicense {
+	expiry := time.Unix(data.ExpiryUTC, 0)
+	return decodedLicense{MachineID: data.MachineID, ExpiryUTC: data.ExpiryUTC, ExpiryDate: expiry.In(bizLocation()).Format("2006-01-02"),
+		Expired: time.Now().After(expiry), Signature: base64.StdEncoding.EncodeToString(signature)}
+}
+
+// ./server verify [-machine <机器码>] [-pubkey <公钥文件>] <激活码>
+// 默认用本地所有签名密钥验签；-pubkey 只需要公钥，可以在没有私钥的机器上使用
+func cmdVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	machineID := fs.String("machine", "", "要求激活码属于该机器码")
+	pubkeyFile := fs.String("pubkey", "", "PEM 公钥文件，为空时使用本地的签名密钥")
+	fs.Parse(args)
+	code, err := cliCodeArg(fs, "verify [-machine <机器码>] [-pubkey <公钥文件>] <激活码>")
+	if err != nil { return err }
+
+	var data LicenseData
+	keyID := ""
+	if *pubkeyFile != "" {
+		raw, err := os.ReadFile(*pubkeyFile)
+		if err != nil { return err }
+		pub, err := parsePublicKeyPEM(raw)
+		if err != nil { return err }
+		var dataJSON, signature []byte
+		if data, dataJSON, signature, err = decodeLicenseCode(code); err != nil { return err }
+		if !verifySignature(pub, dataJSON, signature) { return fmt.Errorf("签名无效（不是该公钥对应的私钥签发的）") }
+		keyID = keyIDFor(pub)
+	} else if data, keyID, err = verifyLicenseCode(code); err != nil {
+		return err
+	}
+
+	d := toDecodedLicense(data, nil)
+	if *machineID != "" && *machineID != data.MachineID { return fmt.Errorf("激活码属于机器码 %s，不是 %s", data.MachineID, *machineID) }
+	if d.Expired { return fmt.Errorf("激活码已于 %s 过期 (机器码 %s)", d.ExpiryDate, data.MachineID) }
+	fmt.Printf("✅ 有效  机器码 %s  到期 %s  密钥 %s\n", data.MachineID, d.ExpiryDate, keyID)
+	return nil
+}
+
+// ./server decode <激活码>  输出 JSON，不验签
+func cmdDecode(args []string) error {
+	fs := flag.NewFlagSet("decode", flag.ExitOnError)
+	fs.Parse(args)
+	code, err := cliCodeArg(fs, "decode <激活码>")
+	if err != nil { return err }
+	data, _, signature, err := decodeLicenseCode(code)
+	if err != nil { return err }
+	return printJSON(toDecodedLicense(data, signature))
+}
+
+// ================= history / machines =================
+
+// cliFilter 列表和导出共用的筛选条件，与页面上的筛选相同
+type cliFilter struct {
+	filter     recordFilter
+	customerID string
+	limit      int
+	asJSON     bool
+}
+
+func addFilterFlags(fs *flag.FlagSet, withStatus bool) *cliFilter {
+	cf := &cliFilter{}
+	fs.StringVar(&cf.filter.Query, "q", "", "机器码模糊匹配")
+	fs.StringVar(&cf.filter.From, "from", "", "起始日期 YYYY-MM-DD（含当天）")
+	fs.StringVar(&cf.filter.To, "to", "", "结束日期 YYYY-MM-DD（含当天）")
+	if withStatus { fs.StringVar(&cf.filter.Status, "status", "", "active / expired") }
+	fs.StringVar(&cf.filter.Owner, "owner", "", "只看归属于该经销商的数据")
+	return cf
+}
+
+// addListFlags list 子命令额外的选项
+func (cf *cliFilter) addListFlags(fs *flag.FlagSet) {
+	fs.StringVar(&cf.customerID, "customer", "", "客户 ID")
+	fs.IntVar(&cf.limit, "limit", v1DefaultLimit, "最多显示多少条，0 表示全部")
+	fs.BoolVar(&cf.asJSON, "json", false, "输出 JSON")
+}
+
+// more 还需要再取几条，limit 为 0 时不限
+func (cf *cliFilter) more(have int) int {
+	if cf.limit <= 0 { return cliPageSize }
+	return min(cf.limit-have, cliPageSize)
+}
+
+// ./server history list [-q -from -to -status -machine -customer -owner -limit -json]
+// ./server history export [-format csv|json|xlsx] [-o 文件] [-q -from -to -status]
+func cmdHistory(args []string) error {
+	if len(args) == 0 { return fmt.Errorf("用法: history list|export [选项]") }
+	switch args[0] {
+	case "list":
+		fs, remote := cliFlags("history list")
+		cf := addFilterFlags(fs, true)
+		cf.addListFlags(fs)
+		machineID := fs.String("machine", "", "机器码（精确匹配）")
+		fs.Parse(args[1:])
+
+		var list []licenseV1
+		if *remote != "" {
+			c, err := remoteClient(*remote)
+			if err != nil { return err }
+			ctx, cancel := cliContext()
+			defer cancel()
+			if cf.filter.Owner != "" { return fmt.Errorf("远程模式下按经销商筛选请使用该经销商的 Token") }
+			params := &client.ListLicensesParams{Q: cf.filter.Query, From: cf.filter.From, To: cf.filter.To, Status: cf.filter.Status, MachineID: *machineID, CustomerID: cf.customerID}
+			for n := cf.more(0); n > 0; n = cf.more(len(list)) {
+				params.Limit = n
+				page, err := c.ListLicenses(ctx, params)
+				if err != nil { return err }
+				for _, l := range page.Data { list = append(list, licenseV1(l)) }
+				if !page.HasMore { break }
+				params.Cursor = page.NextCursor
+			}
+		} else {
+			f := cf.filter
+			if err := f.validate(); err != nil { return err }
+			safeLoadData()
+			recs, _ := queryLicenses(f, *machineID, cf.customerID, "")
+			if cf.limit > 0 && len(recs) > cf.limit { recs = recs[:cf.limit] }
+			day := today()
+			for _, rec := range recs { list = append(list, toLicenseV1(rec, day)) }
+		}
+
+		if cf.asJSON {
+			if list == nil { list = []licenseV1{} }
+			return printJSON(list)
+		}
+		for _, l := range list { fmt.Printf("%s  %s  %-24s  %s  %-7s  %s\n", l.ID, displayTime(l.CreatedAt), l.MachineID, l.ExpiryDate, l.Status, l.IssuedBy) }
+		return nil
+	case "export":
+		return cmdExport("history", args[1:])
+	}
+	return fmt.Errorf("未知子命令: %s", args[0])
+}
+
+// ./server machines list [-q -from -to -customer -owner -limit -json]
+// ./server machines export [-format csv|json|xlsx] [-o 文件] [-q -from -to]
+// ./server machines delete <机器码>
+func cmdMachines(args []string) error {
+	if len(args) == 0 { return fmt.Errorf("用法: machines list|export|delete [选项]") }
+	switch args[0] {
+	case "list":
+		fs, remote := cliFlags("machines list")
+		cf := addFilterFlags(fs, false)
+		cf.addListFlags(fs)
+		fs.Parse(args[1:])
+
+		var list []machineV1
+		if *remote != "" {
+			c, err := remoteClient(*remote)
+			if err != nil { return err }
+			ctx, cancel := cliContext()
+			defer cancel()
+			params := &client.ListMachinesParams{Q: cf.filter.Query, From: cf.filter.From, To: cf.filter.To, CustomerID: cf.customerID, Owner: cf.filter.Owner}
+			for n := cf.more(0); n > 0; n = cf.more(len(list)) {
+				params.Limit = n
+				page, err := c.ListMachines(ctx, params)
+				if err != nil { return err }
+				for _, m := range page.Data { list = append(list, machineV1(m)) }
+				if !page.HasMore { break }
+				params.Cursor = page.NextCursor
+			}
+		} else {
+			f := cf.filter
+			if err := f.validate(); err != nil { return err }
+			safeLoadData()
+			recs, _, expiries := queryMachines(f, cf.customerID)
+			if cf.limit > 0 && len(recs) > cf.limit { recs = recs[:cf.limit] }
+			for _, m := range recs { list = append(list, toMachineV1(m, expiries)) }
+		}
+
+		if cf.asJSON {
+			if list == nil { list = []machineV1{} }
+			return printJSON(list)
+		}
+		for _, m := range list { fmt.Printf("%-24s  %s  %-10s  %s\n", m.MachineID, displayTime(m.LastSeen), m.LatestExpiry, m.Owner) }
+		return nil
+	case "export":
+		return cmdExport("machines", args[1:])
+	case "delete":
+		fs, remote := cliFlags("machines delete")
+		fs.Parse(args[1:])
+		id := fs.Arg(0)
+		if id == "" { return fmt.Errorf("用法: machines delete <机器码>") }
+
+		if *remote != "" {
+			c, err := remoteClient(*remote)
+			if err != nil { return err }
+			ctx, cancel := cliContext()
+			defer cancel()
+			if err := c.DeleteMachine(ctx, id); err != nil { return err }
+		} else {
+			safeLoadData()
+			loadWebhooks()
+			if _, ok := deleteMachine(context.Background(), id, cliPrincipal()); !ok { return fmt.Errorf("机器码 %s 不存在", id) }
+		}
+		fmt.Printf("✅ 机器码 %s 已删除\n", id)
+		return nil
+	}
+	return fmt.Errorf("未知子命令: %s", args[0])
+}
+
+// cmdExport 与页面上的导出相同；-o 为空时写到标准输出
+func cmdExport(target string, args []string) error {
+	fs, remote := cliFlags(target + " export")
+	format := fs.String("format", "csv", "csv / json / xlsx")
+	out := fs.String("o", "", "输出文件，为空时写到标准输出")
+	cf := addFilterFlags(fs, target == "history")
+	fs.Parse(args)
+	if _, ok := exportContentTypes[*format]; !ok { return fmt.Errorf("不支持的格式: %s", *format) }
+
+	var data []byte
+	if *remote != "" {
+		if cf.filter.Owner != "" { return fmt.Errorf("远程模式下按经销商筛选请使用该经销商的 Token") }
+		c, err := remoteClient(*remote)
+		if err != nil { return err }
+		ctx, cancel := cliContext()
+		defer cancel()
+		if data, err = c.ExportRecords(ctx, target, &client.ExportRecordsParams{Format: *format, Q: cf.filter.Query, From: cf.filter.From, To: cf.filter.To, Status: cf.filter.Status}); err != nil { return err }
+	} else {
+		f := cf.filter
+		if err := f.validate(); err != nil { return err }
+		safeLoadData()
+		var jsonData interface{}
+		var rows [][]string
+		mutex.Lock()
+		if target == "history" {
+			list := filterHistory(f)
+			jsonData, rows = list, historyRows(list)
+		} else {
+			list := filterMachines(f)
+			jsonData, rows = list, machineRows(list)
+		}
+		mutex.Unlock()
+		var buf bytes.Buffer
+		if err := encodeExport(&buf, target, *format, jsonData, rows); err != nil { return err }
+		data = buf.Bytes()
+	}
+
+	if *out == "" { _, err := os.Stdout.Write(data); return err }
+	if err := writeFileAtomic(*out, data, 0600); err != nil { return err }
+	slog.Info("📤 已导出", "file", *out, "bytes", len(data))
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 子进程里真正执行 main()，用来检查命令行的退出码；直接运行测试时什么都不做
+func TestCLIHelperProcess(t *testing.T) {
+	if os.Getenv("CLI_HELPER_PROCESS") != "1" { return }
+	args := os.Args
+	for i, a := range args {
+		if a == "--" { args = args[i+1:]; break }
+	}
+	os.Args = append([]string{"server"}, args...)
+	main()
+	os.Exit(0)
+}
+
+// runCLI 在临时目录里执行 ./server <args>，返回标准输出和退出码
+func runCLI(t *testing.T, keysDir, stdin string, args ...string) (string, int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], append([]string{"-test.run=^TestCLIHelperProcess$", "--"}, args...)...)
+	cmd.Dir = t.TempDir()
+	cmd.Env = append(os.Environ(), "CLI_HELPER_PROCESS=1", "KEYS_DIR="+keysDir, "CONFIG_FILE=", "PRIVATE_KEY=", "TG_BOT_TOKEN=")
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) { return stdout.String(), exitErr.ExitCode() }
+	if err != nil { t.Fatal(err) }
+	return stdout.String(), 0
+}
+
+// cliKeys 在临时 KEYS_DIR 里生成签名密钥，返回目录和公钥文件
+func cliKeys(t *testing.T) (string, string) {
+	t.Helper()
+	withDataDir(t, t.TempDir())
+	id, _, pub, err := generateSigningKey()
+	if err != nil { t.Fatal(err) }
+	pubFile := filepath.Join(t.TempDir(), id+".pub.pem")
+	if err := os.WriteFile(pubFile, pub, 0644); err != nil { t.Fatal(err) }
+	return KeysDir, pubFile
+}
+
+// cliExpiry 一周后到期，在 LICENSE_MAX_MONTHS 限制之内
+func cliExpiry() string { return time.Now().In(bizLocation()).AddDate(0, 0, 7).Format("2006-01-02") }
+
+// forgeLicense 改掉激活码里的机器码，保留原签名
+func forgeLicense(t *testing.T, code, machineID string) string {
+	t.Helper()
+	data, _, signature, err := decodeLicenseCode(code)
+	if err != nil { t.Fatal(err) }
+	data.MachineID = machineID
+	dataJSON, _ := json.Marshal(data)
+	licenseJSON, _ := json.Marshal(License{Data: base64.StdEncoding.EncodeToString(dataJSON), Signature: base64.StdEncoding.EncodeToString(signature)})
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf); gz.Write(licenseJSON); gz.Close()
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func TestCLIVerifyExitCodes(t *testing.T) {
+	keysDir, pubFile := cliKeys(t)
+	valid, err := generateLicenseCore("M-CLI", cliExpiry())
+	if err != nil { t.Fatal(err) }
+	expired, err := generateLicenseCore("M-CLI", "2000-01-01")
+	if err != nil { t.Fatal(err) }
+	_, otherPub := cliKeys(t) // 另一把密钥，KeysDir 已切换，上面的激活码不是它签的
+
+	for _, c := range []struct {
+		name  string
+		stdin string
+		args  []string
+		code  int
+	}{
+		{"有效", "", []string{"verify", valid}, 0},
+		{"标准输入", valid + "\n", []string{"verify", "-"}, 0},
+		{"机器码匹配", "", []string{"verify", "-machine", "M-CLI", valid}, 0},
+		{"只用公钥", "", []string{"verify", "-pubkey", pubFile, valid}, 0},
+		{"机器码不符", "", []string{"verify", "-machine", "M-OTHER", valid}, 1},
+		{"已过期", "", []string{"verify", expired}, 1},
+		{"其他公钥", "", []string{"verify", "-pubkey", otherPub, valid}, 1},
+		{"篡改", "", []string{"verify", forgeLicense(t, valid, "M-EVIL")}, 1},
+		{"篡改且只用公钥", "", []string{"verify", "-pubkey", pubFile, forgeLicense(t, valid, "M-EVIL")}, 1},
+		{"缺少参数", "", []string{"verify"}, 1},
+		{"未知选项", "", []string{"verify", "-bogus", valid}, 2},
+	} {
+		out, code := runCLI(t, keysDir, c.stdin, c.args...)
+		if code != c.code { t.Errorf("%s: 退出码 %d，期望 %d", c.name, code, c.code) }
+		if code == 0 && !strings.Contains(out, "M-CLI") { t.Errorf("%s: 输出里没有机器码: %q", c.name, out) }
+	}
+
+	// 本地没有签发它的密钥
+	if _, code := runCLI(t, t.TempDir(), "", "verify", valid); code != 1 { t.Errorf("未知密钥: 退出码 %d，期望 1", code) }
+}
+
+func TestCLIDecodeExitCodes(t *testing.T) {
+	keysDir, _ := cliKeys(t)
+	valid, err := generateLicenseCore("M-CLI", cliExpiry())
+	if err != nil { t.Fatal(err) }
+
+	out, code := runCLI(t, keysDir, "", "decode", valid)
+	if code != 0 { t.Fatalf("decode 退出码 %d", code) }
+	var d decodedLicense
+	if err := json.Unmarshal([]byte(out), &d); err != nil || d.MachineID != "M-CLI" || d.ExpiryDate != cliExpiry() || d.Signature == "" { t.Fatalf("decode 输出不对: %v %q", err, out) }
+
+	// decode 不验签，没有密钥也能解
+	if _, code := runCLI(t, t.TempDir(), valid+"\n", "decode", "-"); code != 0 { t.Errorf("标准输入: 退出码 %d，期望 0", code) }
+	for name, args := range map[string][]string{"格式错误": {"decode", "not-a-license"}, "缺少参数": {"decode"}} {
+		if out, code := runCLI(t, keysDir, "", args...); code != 1 || out != "" { t.Errorf("%s: 退出码 %d，输出 %q", name, code, out) }
+	}
+}
@@ -0,0 +1,95 @@
+// Package client 是 license-server 的 Go 客户端。
+//
+// 接口方法和数据类型（api.gen.go）由 gen.go 根据仓库根目录的 openapi.json 生成，
+// 修改文档后在本目录运行 go generate 重新生成；本文件是手写的传输层。
+//
+//	c := client.New("https://license.example.com", os.Getenv("LICENSE_TOKEN"))
+//	res, err := c.CreateLicense(ctx, nil, &client.LicenseCreate{MachineID: "abc", Expiry: "2026-01-31"})
+//	var apiErr *client.APIError
+//	if errors.As(err, &apiErr) && apiErr.Code == "validation_failed" { ... }
+package client
+
+//go:generate go run gen.go
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type Client struct {
+	BaseURL    string
+	Token      string       // 以 Authorization: Bearer 发送
+	HTTPClient *http.Client // 为空时使用 30 秒超时的默认客户端；需要客户端证书时传入自己的 Transport
+	UserAgent  string
+}
+
+func New(baseURL, token string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), Token: token, HTTPClient: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// APIError 服务端返回的非 2xx 响应。/api/v1 的错误带有 Code，旧接口只有纯文本 Message。
+type APIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Code != "" { return fmt.Sprintf("license-server: %d %s: %s", e.StatusCode, e.Code, e.Message) }
+	return fmt.Sprintf("license-server: %d: %s", e.StatusCode, e.Message)
+}
+
+// do 发送请求；out 为 *string 时按文本读取，为 *[]byte 时原样读取，其他按 JSON 解码，nil 时丢弃响应体
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, header http.Header, body, out interface{}) error {
+	u := c.BaseURL + path
+	if len(query) > 0 { u += "?" + query.Encode() }
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil { return err }
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil { return err }
+	for k, v := range header { req.Header[k] = v }
+	if body != nil { req.Header.Set("Content-Type", "application/json") }
+	if c.Token != "" { req.Header.Set("Authorization", "Bearer "+c.Token) }
+	if c.UserAgent != "" { req.Header.Set("User-Agent", c.UserAgent) }
+
+	hc := c.HTTPClient
+	if hc == nil { hc = http.DefaultClient }
+	resp, err := hc.Do(req)
+	if err != nil { return err }
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil { return err }
+	if resp.StatusCode < 200 || resp.StatusCode > 299 { return parseError(resp.StatusCode, data) }
+
+	switch v := out.(type) {
+	case nil:
+		return nil
+	case *string:
+		*v = string(data)
+		return nil
+	case *[]byte:
+		*v = data
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil { return fmt.Errorf("license-server: 解析响应失败: %v", err) }
+	return nil
+}
+
+func parseError(status int, data []byte) error {
+	var env struct {
+		Error *ErrorBody `json:"error"`
+	}
+	if json.Unmarshal(data, &env) == nil && env.Error != nil { return &APIError{StatusCode: status, Code: env.Error.Code, Message: env.Error.Message} }
+	return &APIError{StatusCode: status, Message: strings.TrimSpace(string(data))}
+}
@@ -0,0 +1,300 @@
+//go:build ignore
+
+// gen.go 根据 ../openapi.json 生成 api.gen.go：components/schemas 生成结构体，每个带 operationId 的接口生成一个方法。
+// 只支持本项目文档里用到的写法；multipart 上传的接口不生成，需要时直接发请求。
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+type schema struct {
+	Ref                  string           `json:"$ref"`
+	Type                 string           `json:"type"`
+	Format               string           `json:"format"`
+	Description          string           `json:"description"`
+	Enum                 []string         `json:"enum"`
+	Items                *schema          `json:"items"`
+	Properties           ordered[*schema] `json:"properties"`
+	Required             []string         `json:"required"`
+	AdditionalProperties *schema          `json:"additionalProperties"`
+	OneOf                []*schema        `json:"oneOf"`
+	Deprecated           bool             `json:"deprecated"`
+	Patch                bool             `json:"x-go-patch"` // PATCH 请求体：可选字段生成指针，区分「不修改」和「清空」
+}
+
+type parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Required    bool    `json:"required"`
+	Description string  `json:"description"`
+	Schema      *schema `json:"schema"`
+}
+
+type mediaType struct {
+	Schema *schema `json:"schema"`
+}
+
+type response struct {
+	Ref     string             `json:"$ref"`
+	Content ordered[mediaType] `json:"content"`
+}
+
+type operation struct {
+	OperationID string      `json:"operationId"`
+	Summary     string      `json:"summary"`
+	Description string      `json:"description"`
+	Scope       string      `json:"x-scope"`
+	Parameters  []parameter `json:"parameters"`
+	RequestBody *struct {
+		Content ordered[mediaType] `json:"content"`
+	} `json:"requestBody"`
+	Responses ordered[response] `json:"responses"`
+}
+
+type pathItem struct {
+	Parameters []parameter `json:"parameters"`
+	Get        *operation  `json:"get"`
+	Post       *operation  `json:"post"`
+	Patch      *operation  `json:"patch"`
+	Delete     *operation  `json:"delete"`
+}
+
+type document struct {
+	Paths      ordered[pathItem] `json:"paths"`
+	Components struct {
+		Schemas ordered[*schema] `json:"schemas"`
+	} `json:"components"`
+}
+
+// ordered 保留 JSON 对象的键顺序，生成的字段顺序和文档一致
+type ordered[T any] struct {
+	Keys   []string
+	Values map[string]T
+}
+
+func (o *ordered[T]) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &o.Values); err != nil { return err }
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.Token()
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil { return err }
+		o.Keys = append(o.Keys, tok.(string))
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil { return err }
+	}
+	return nil
+}
+
+var initialisms = map[string]string{"id": "ID", "ids": "IDs", "ip": "IP", "ips": "IPs", "url": "URL", "uri": "URI", "utc": "UTC", "hmac": "HMAC", "qr": "QR", "totp": "TOTP", "oidc": "OIDC", "otp": "OTP", "api": "API"}
+
+// goName machine_id -> MachineID, X-OTP-Code -> OTPCode, listLicenses -> ListLicenses
+func goName(s string) string {
+	s = strings.TrimPrefix(s, "X-")
+	var b strings.Builder
+	for _, w := range strings.FieldsFunc(s, func(r rune) bool { return r == '_' || r == '-' || r == ' ' || r == '.' }) {
+		if v, ok := initialisms[strings.ToLower(w)]; ok { b.WriteString(v); continue }
+		b.WriteString(strings.ToUpper(w[:1]) + w[1:])
+	}
+	return b.String()
+}
+
+func refName(ref string) string { return ref[strings.LastIndex(ref, "/")+1:] }
+
+func goType(s *schema) string {
+	switch {
+	case s.Ref != "":
+		return refName(s.Ref)
+	case s.Type == "array":
+		return "[]" + goType(s.Items)
+	case s.Type == "integer" && s.Format == "int64":
+		return "int64"
+	case s.Type == "integer":
+		return "int"
+	case s.Type == "boolean":
+		return "bool"
+	case s.Type == "string":
+		return "string"
+	case s.Type == "object" && s.AdditionalProperties != nil:
+		return "map[string]" + goType(s.AdditionalProperties)
+	}
+	return "json.RawMessage"
+}
+
+func comment(b *bytes.Buffer, indent, text string) {
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") { fmt.Fprintf(b, "%s// %s\n", indent, line) }
+}
+
+func genSchema(b *bytes.Buffer, name string, s *schema) {
+	if s.Description != "" { comment(b, "", name+" "+s.Description) }
+	if s.Type == "string" {
+		fmt.Fprintf(b, "type %s string\n\nconst (\n", name)
+		for _, v := range s.Enum { fmt.Fprintf(b, "\t%s%s %s = %q\n", name, goName(v), name, v) }
+		b.WriteString(")\n\n")
+		return
+	}
+	fmt.Fprintf(b, "type %s struct {\n", name)
+	for _, key := range s.Properties.Keys {
+		p := s.Properties.Values[key]
+		if p.Deprecated || p.Format == "binary" { continue }
+		required := contains(s.Required, key)
+		typ, tag := goType(p), key
+		if !required {
+			tag += ",omitempty"
+			if s.Patch { typ = "*" + typ }
+		}
+		if p.Description != "" { comment(b, "\t", p.Description) }
+		fmt.Fprintf(b, "\t%s %s `json:%q`\n", goName(key), typ, tag)
+	}
+	b.WriteString("}\n\n")
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s { return true }
+	}
+	return false
+}
+
+// resultType 第一个 2xx 响应的 Go 类型，空字符串表示没有响应体
+func resultType(op *operation) string {
+	for _, code := range op.Responses.Keys {
+		if !strings.HasPrefix(code, "2") { continue }
+		r := op.Responses.Values[code]
+		if len(r.Content.Keys) == 0 { return "" }
+		if len(r.Content.Keys) > 1 { return "[]byte" }
+		ct := r.Content.Keys[0]
+		if ct == "text/plain" { return "string" }
+		if ct == "application/json" { return goType(r.Content.Values[ct].Schema) }
+		return "[]byte"
+	}
+	return ""
+}
+
+// byValue 切片、map、字符串和原始 JSON 直接返回值，结构体返回指针
+func byValue(typ string) bool {
+	return strings.HasPrefix(typ, "[]") || strings.HasPrefix(typ, "map[") || typ == "string" || typ == "json.RawMessage"
+}
+
+func genOperation(b *bytes.Buffer, path, method string, item pathItem, op *operation) {
+	name := goName(op.OperationID)
+	params := append(append([]parameter{}, item.Parameters...), op.Parameters...)
+	var pathParams, otherParams []parameter
+	for _, p := range params {
+		if p.In == "path" { pathParams = append(pathParams, p) } else { otherParams = append(otherParams, p) }
+	}
+
+	var body string
+	if op.RequestBody != nil {
+		ct := op.RequestBody.Content.Keys[0]
+		if ct != "application/json" { fmt.Fprintf(b, "// %s (%s %s) 使用 %s，不生成方法。\n\n", name, method, path, ct); return }
+		body = goType(op.RequestBody.Content.Values[ct].Schema)
+	}
+
+	if len(otherParams) > 0 {
+		fmt.Fprintf(b, "// %sParams %s 的查询参数和请求头，零值表示不传。\ntype %sParams struct {\n", name, name, name)
+		for _, p := range otherParams {
+			if p.Description != "" { comment(b, "\t", p.Description) }
+			fmt.Fprintf(b, "\t%s %s\n", goName(p.Name), goType(p.Schema))
+		}
+		b.WriteString("}\n\n")
+	}
+
+	args := []string{"ctx context.Context"}
+	for _, p := range pathParams { args = append(args, p.Name+" string") }
+	if len(otherParams) > 0 { args = append(args, "params *"+name+"Params") }
+	if body != "" { args = append(args, "body *"+body) }
+	result := resultType(op)
+
+	doc := op.Summary
+	if op.Scope != "" { doc += "（需要 " + op.Scope + " 权限）" }
+	comment(b, "", name+" "+method+" "+path+" "+doc)
+	if op.Description != "" { b.WriteString("//\n"); comment(b, "", op.Description) }
+	if result == "" {
+		fmt.Fprintf(b, "func (c *Client) %s(%s) error {\n", name, strings.Join(args, ", "))
+	} else if byValue(result) {
+		fmt.Fprintf(b, "func (c *Client) %s(%s) (%s, error) {\n\tvar out %s\n", name, strings.Join(args, ", "), result, result)
+	} else {
+		fmt.Fprintf(b, "func (c *Client) %s(%s) (*%s, error) {\n\tout := new(%s)\n", name, strings.Join(args, ", "), result, result)
+	}
+
+	pathExpr := fmt.Sprintf("%q", path)
+	for _, p := range pathParams {
+		pathExpr = strings.Replace(pathExpr, "{"+p.Name+"}", `" + url.PathEscape(`+p.Name+`) + "`, 1)
+	}
+	pathExpr = strings.TrimSuffix(strings.TrimPrefix(pathExpr, `"" + `), ` + ""`)
+
+	query, header := "nil", "nil"
+	if len(otherParams) > 0 {
+		b.WriteString("\tq, h := url.Values{}, http.Header{}\n\tif params != nil {\n")
+		for _, p := range otherParams {
+			field := "params." + goName(p.Name)
+			var cond, val string
+			switch goType(p.Schema) {
+			case "int":
+				cond, val = field+" != 0", "strconv.Itoa("+field+")"
+			case "bool":
+				cond, val = field, `"1"`
+			default:
+				cond, val = field+` != ""`, field
+			}
+			target := "q"
+			if p.In == "header" { target = "h" }
+			fmt.Fprintf(b, "\t\tif %s {\n\t\t\t%s.Set(%q, %s)\n\t\t}\n", cond, target, p.Name, val)
+		}
+		b.WriteString("\t}\n")
+		query, header = "q", "h"
+	}
+	bodyArg := "nil"
+	if body != "" { bodyArg = "body" }
+
+	switch {
+	case result == "":
+		fmt.Fprintf(b, "\treturn c.do(ctx, %q, %s, %s, %s, %s, nil)\n}\n\n", strings.ToUpper(method), pathExpr, query, header, bodyArg)
+	case byValue(result):
+		fmt.Fprintf(b, "\terr := c.do(ctx, %q, %s, %s, %s, %s, &out)\n\treturn out, err\n}\n\n", strings.ToUpper(method), pathExpr, query, header, bodyArg)
+	default:
+		fmt.Fprintf(b, "\tif err := c.do(ctx, %q, %s, %s, %s, %s, out); err != nil {\n\t\treturn nil, err\n\t}\n\treturn out, nil\n}\n\n", strings.ToUpper(method), pathExpr, query, header, bodyArg)
+	}
+}
+
+func main() {
+	data, err := os.ReadFile("../openapi.json")
+	if err != nil { log.Fatal(err) }
+	var doc document
+	if err := json.Unmarshal(data, &doc); err != nil { log.Fatal(err) }
+
+	var b bytes.Buffer
+
+	names := append([]string{}, doc.Components.Schemas.Keys...)
+	sort.Strings(names)
+	for _, name := range names { genSchema(&b, name, doc.Components.Schemas.Values[name]) }
+
+	for _, path := range doc.Paths.Keys {
+		item := doc.Paths.Values[path]
+		for _, m := range []struct {
+			name string
+			op   *operation
+		}{{"get", item.Get}, {"post", item.Post}, {"patch", item.Patch}, {"delete", item.Delete}} {
+			if m.op != nil && m.op.OperationID != "" { genOperation(&b, path, strings.ToUpper(m.name), item, m.op) }
+		}
+	}
+
+	// 只导入实际用到的包
+	var imports []string
+	for _, pkg := range []string{"context", "encoding/json", "net/http", "net/url", "strconv"} {
+		if strings.Contains(b.String(), pkg[strings.LastIndex(pkg, "/")+1:]+".") { imports = append(imports, fmt.Sprintf("%q", pkg)) }
+	}
+	head := "// Code generated by gen.go from openapi.json; DO NOT EDIT.\n\npackage client\n\nimport (\n" + strings.Join(imports, "\n") + "\n)\n\n"
+	src, err := format.Source(append([]byte(head), b.Bytes()...))
+	if err != nil { os.WriteFile("api.gen.go", b.Bytes(), 0644); log.Fatalf("格式化失败: %v", err) }
+	if err := os.WriteFile("api.gen.go", src, 0644); err != nil { log.Fatal(err) }
+}
@@ -0,0 +1,521 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ================= 配置 =================
+// 所有配置项都可以写在一个 YAML 文件里（CONFIG_FILE，默认当前目录的 config.yaml，不存在时只用环境变量），
+// 格式见 config.example.yaml。同名环境变量优先于配置文件，方便在容器里临时覆盖；键和环境变量的对应关系见 configSettings。
+// 启动时一次性校验全部配置（未知的键、类型、取值范围），有错误时全部列出后退出；./server config check 只做校验不启动。
+// 收到 SIGHUP 时重新读取配置文件，只应用标记为 Reload 的项（通知、限流、锁定、会话、分页、Webhook 重试等）；
+// 端口、证书、密钥、数据文件和时区需要重启，改了会在日志里提示，在重启前继续使用旧值。
+
+type settingType int
+
+const (
+	cfgString   settingType = iota
+	cfgInt
+	cfgDuration // 例如 30s、15m、24h
+	cfgBool
+	cfgList     // YAML 列表或逗号分隔
+	cfgMap      // YAML 映射或 k=v 逗号分隔
+)
+
+type configSetting struct {
+	Key    string // 配置文件中的路径，例如 telegram.bot_token
+	Env    string
+	Type   settingType
+	Reload bool // SIGHUP 时生效，见 applyReloadable
+	Secret bool // config check 不显示值
+	Check  func(v string) error
+}
+
+var configSettings = []configSetting{
+	{Key: "server.port", Env: "PORT", Type: cfgInt, Check: portRange},
+	{Key: "server.grpc_port", Env: "GRPC_PORT", Type: cfgInt, Check: portRange},
+	{Key: "server.timezone", Env: "TIMEZONE", Check: checkTimezone},
+	{Key: "server.page_size", Env: "PAGE_SIZE", Type: cfgInt, Reload: true, Check: atLeast(1)},
+	{Key: "server.trust_proxy_headers", Env: "TRUST_PROXY_HEADERS", Type: cfgBool},
+	{Key: "server.security_token", Env: "SECURITY_TOKEN", Secret: true},
+	{Key: "server.strict_auth", Env: "STRICT_AUTH", Type: cfgBool},
+	{Key: "server.bootstrap_secret_file", Env: "BOOTSTRAP_SECRET_FILE"},
+	{Key: "server.shutdown_timeout", Env: "SHUTDOWN_TIMEOUT", Type: cfgDuration},
+	{Key: "log.level", Env: "LOG_LEVEL", Reload: true, Check: checkLogLevel},
+	{Key: "log.format", Env: "LOG_FORMAT", Check: checkLogFormat},
+	{Key: "server.metrics_token", Env: "METRICS_TOKEN", Secret: true},
+
+	{Key: "license.max_months", Env: "LICENSE_MAX_MONTHS", Type: cfgInt, Reload: true, Check: atLeast(1)},
+	{Key: "license.keys_dir", Env: "KEYS_DIR"},
+	{Key: "license.private_key", Env: "PRIVATE_KEY", Secret: true},
+	{Key: "license.setup_export_private_key", Env: "SETUP_EXPORT_PRIVATE_KEY", Type: cfgBool},
+
+	{Key: "storage.history_file", Env: "HISTORY_FILE"},
+	{Key: "storage.machines_file", Env: "MACHINES_FILE"},
+	{Key: "storage.archive_dir", Env: "ARCHIVE_DIR"},
+
+	{Key: "encryption.key", Env: "DATA_ENCRYPTION_KEY", Secret: true},
+	{Key: "encryption.old_keys", Env: "DATA_ENCRYPTION_OLD_KEYS", Type: cfgList, Secret: true},
+	{Key: "encryption.index_key", Env: "DATA_INDEX_KEY", Secret: true},
+	{Key: "encryption.keys_file", Env: "DATA_KEYS_FILE", Check: fileExists},
+	{Key: "encryption.encrypt_machine_id", Env: "ENCRYPT_MACHINE_ID", Type: cfgBool},
+
+	{Key: "tls.cert_file", Env: "TLS_CERT_FILE", Check: fileExists},
+	{Key: "tls.key_file", Env: "TLS_KEY_FILE", Check: fileExists},
+	{Key: "tls.client_ca_file", Env: "TLS_CLIENT_CA_FILE", Check: fileExists},
+	{Key: "tls.client_cert_required", Env: "TLS_CLIENT_CERT_REQUIRED", Type: cfgBool},
+
+	{Key: "telegram.bot_token", Env: "TELEGRAM_BOT_TOKEN", Reload: true, Secret: true},
+	{Key: "telegram.chat_id", Env: "TELEGRAM_CHAT_ID", Type: cfgList, Reload: true},
+
+	{Key: "rate_limit.ip_per_min", Env: "RATE_LIMIT_IP_PER_MIN", Type: cfgInt, Reload: true, Check: atLeast(0)},
+	{Key: "rate_limit.ip_burst", Env: "RATE_LIMIT_IP_BURST", Type: cfgInt, Reload: true, Check: atLeast(0)},
+	{Key: "rate_limit.token_per_min", Env: "RATE_LIMIT_TOKEN_PER_MIN", Type: cfgInt, Reload: true, Check: atLeast(0)},
+	{Key: "rate_limit.token_burst", Env: "RATE_LIMIT_TOKEN_BURST", Type: cfgInt, Reload: true, Check: atLeast(0)},
+
+	{Key: "auth.lockout_threshold", Env: "AUTH_LOCKOUT_THRESHOLD", Type: cfgInt, Reload: true, Check: atLeast(0)},
+	{Key: "auth.lockout_base", Env: "AUTH_LOCKOUT_BASE", Type: cfgDuration, Reload: true},
+	{Key: "auth.lockout_max", Env: "AUTH_LOCKOUT_MAX", Type: cfgDuration, Reload: true},
+	{Key: "auth.failure_window", Env: "AUTH_FAILURE_WINDOW", Type: cfgDuration, Reload: true},
+	{Key: "auth.hmac_max_skew", Env: "HMAC_MAX_SKEW", Type: cfgDuration, Reload: true},
+
+	{Key: "session.idle_timeout", Env: "SESSION_IDLE_TIMEOUT", Type: cfgDuration, Reload: true},
+	{Key: "session.max_age", Env: "SESSION_MAX_AGE", Type: cfgDuration, Reload: true},
+	{Key: "session.cookie_secure", Env: "SESSION_COOKIE_SECURE", Type: cfgBool},
+
+	{Key: "totp.issuer", Env: "TOTP_ISSUER", Reload: true},
+	{Key: "totp.required_roles", Env: "TOTP_REQUIRED_ROLES", Type: cfgList, Reload: true, Check: checkRoleList},
+	{Key: "totp.step_up_window", Env: "TOTP_STEP_UP_WINDOW", Type: cfgDuration, Reload: true},
+	{Key: "totp.step_up_days", Env: "TOTP_STEP_UP_DAYS", Type: cfgInt, Reload: true, Check: atLeast(0)},
+
+	{Key: "oidc.issuer", Env: "OIDC_ISSUER"},
+	{Key: "oidc.client_id", Env: "OIDC_CLIENT_ID"},
+	{Key: "oidc.client_secret", Env: "OIDC_CLIENT_SECRET", Secret: true},
+	{Key: "oidc.redirect_url", Env: "OIDC_REDIRECT_URL"},
+	{Key: "oidc.scopes", Env: "OIDC_SCOPES"},
+	{Key: "oidc.username_claim", Env: "OIDC_USERNAME_CLAIM"},
+	{Key: "oidc.groups_claim", Env: "OIDC_GROUPS_CLAIM"},
+	{Key: "oidc.role_map", Env: "OIDC_ROLE_MAP", Type: cfgMap, Check: checkRoleMap},
+	{Key: "oidc.default_role", Env: "OIDC_DEFAULT_ROLE", Check: checkRoleList},
+	{Key: "oidc.jwks_cache_ttl", Env: "OIDC_JWKS_CACHE_TTL", Type: cfgDuration},
+
+	{Key: "backup.target", Env: "BACKUP_TARGET"},
+	{Key: "backup.interval", Env: "BACKUP_INTERVAL", Type: cfgDuration},
+	{Key: "backup.keep_daily", Env: "BACKUP_KEEP_DAILY", Type: cfgInt, Check: atLeast(0)},
+	{Key: "backup.keep_weekly", Env: "BACKUP_KEEP_WEEKLY", Type: cfgInt, Check: atLeast(0)},
+	{Key: "backup.include_key", Env: "BACKUP_INCLUDE_KEY", Type: cfgBool},
+	{Key: "backup.key_passphrase", Env: "BACKUP_KEY_PASSPHRASE", Secret: true},
+
+	{Key: "s3.region", Env: "S3_REGION"},
+	{Key: "s3.endpoint", Env: "S3_ENDPOINT"},
+	{Key: "s3.access_key_id", Env: "S3_ACCESS_KEY_ID"},
+	{Key: "s3.secret_access_key", Env: "S3_SECRET_ACCESS_KEY", Secret: true},
+	{Key: "s3.force_path_style", Env: "S3_FORCE_PATH_STYLE", Type: cfgBool},
+
+	{Key: "retention.archive_days", Env: "RETENTION_ARCHIVE_DAYS", Type: cfgInt, Check: atLeast(0)},
+	{Key: "retention.machine_days", Env: "RETENTION_MACHINE_DAYS", Type: cfgInt, Check: atLeast(0)},
+	{Key: "retention.redact", Env: "RETENTION_REDACT", Type: cfgBool},
+	{Key: "retention.interval", Env: "RETENTION_INTERVAL", Type: cfgDuration},
+
+	{Key: "webhooks.max_attempts", Env: "WEBHOOK_MAX_ATTEMPTS", Type: cfgInt, Reload: true, Check: atLeast(1)},
+	{Key: "webhooks.timeout", Env: "WEBHOOK_TIMEOUT", Type: cfgDuration},
+	{Key: "webhooks.expiring_days", Env: "WEBHOOK_EXPIRING_DAYS", Type: cfgInt, Reload: true, Check: atLeast(0)},
+	{Key: "webhooks.log_limit", Env: "WEBHOOK_LOG_LIMIT", Type: cfgInt, Reload: true, Check: atLeast(0)},
+
+	{Key: "idempotency.ttl", Env: "IDEMPOTENCY_TTL", Type: cfgDuration, Reload: true},
+}
+
+// configFile 解析后的配置文件，值按环境变量名索引
+type configFile struct {
+	Path   string
+	Found  bool
+	Values map[string]string
+	Lines  map[string]int // 报错时指出行号
+	Errors []string
+}
+
+var (
+	configPath     = getenvDefault("CONFIG_FILE", "config.yaml")
+	configRequired = os.Getenv("CONFIG_FILE") != "" // 显式指定的配置文件必须存在
+	// 包级变量初始化时（getEnv 等）就要用到，Go 会保证它先于这些变量初始化
+	currentConfig   = readConfigFile(configPath, configRequired)
+	settingDefaults = map[string]string{} // 各处 getEnv* 传入的默认值，热加载和 config check 使用
+	configMutex     sync.RWMutex
+)
+
+func getenvDefault(k, def string) string { if v := os.Getenv(k); v != "" { return v }; return def }
+
+func findSetting(key string) *configSetting {
+	for i := range configSettings {
+		if configSettings[i].Key == key { return &configSettings[i] }
+	}
+	return nil
+}
+
+func isConfigSection(prefix string) bool {
+	for _, s := range configSettings {
+		if strings.HasPrefix(s.Key, prefix+".") { return true }
+	}
+	return false
+}
+
+func readConfigFile(path string, required bool) *configFile {
+	cf := &configFile{Path: path, Values: map[string]string{}, Lines: map[string]int{}}
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) && !required { return cf }
+	if err != nil { cf.Errors = append(cf.Errors, fmt.Sprintf("读取配置文件失败: %v", err)); return cf }
+	cf.Found = true
+	var doc yaml.Node
+	if err := yaml.Unmarshal(raw, &doc); err != nil { cf.Errors = append(cf.Errors, fmt.Sprintf("%s: %v", path, err)); return cf }
+	if len(doc.Content) == 0 { return cf } // 空文件
+	cf.walk(doc.Content[0], "")
+	return cf
+}
+
+func (cf *configFile) errorf(n *yaml.Node, format string, args ...interface{}) {
+	cf.Errors = append(cf.Errors, fmt.Sprintf("%s 第 %d 行: ", cf.Path, n.Line)+fmt.Sprintf(format, args...))
+}
+
+func (cf *configFile) walk(n *yaml.Node, prefix string) {
+	if n.Kind != yaml.MappingNode { cf.errorf(n, "%s 应为映射", strings.TrimSuffix(prefix, ".")); return }
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, v := n.Content[i], n.Content[i+1]
+		key := prefix + k.Value
+		s := findSetting(key)
+		if s == nil {
+			if isConfigSection(key) { cf.walk(v, key+"."); continue }
+			cf.errorf(k, "未知的配置项 %s", key)
+			continue
+		}
+		value, ok := cf.scalar(v, s)
+		if !ok { continue }
+		cf.Values[s.Env] = value
+		cf.Lines[s.Env] = k.Line
+	}
+}
+
+// scalar 把配置文件里的值转成与环境变量相同的字符串形式
+func (cf *configFile) scalar(v *yaml.Node, s *configSetting) (string, bool) {
+	switch {
+	case v.Kind == yaml.ScalarNode && v.Tag == "!!null":
+		return "", true
+	case v.Kind == yaml.ScalarNode:
+		return v.Value, true
+	case v.Kind == yaml.SequenceNode && s.Type == cfgList:
+		var items []string
+		for _, item := range v.Content {
+			if item.Kind != yaml.ScalarNode { cf.errorf(item, "%s 的元素应为字符串", s.Key); return "", false }
+			items = append(items, item.Value)
+		}
+		return strings.Join(items, ","), true
+	case v.Kind == yaml.MappingNode && s.Type == cfgMap:
+		var items []string
+		for i := 0; i+1 < len(v.Content); i += 2 {
+			if v.Content[i+1].Kind != yaml.ScalarNode { cf.errorf(v.Content[i+1], "%s 的值应为字符串", s.Key); return "", false }
+			items = append(items, v.Content[i].Value+"="+v.Content[i+1].Value)
+		}
+		return strings.Join(items, ","), true
+	}
+	cf.errorf(v, "%s 应为单个值", s.Key)
+	return "", false
+}
+
+// settingValue 环境变量优先，其次是配置文件；都没有时返回空字符串
+func (cf *configFile) settingValue(env string) (string, string) {
+	if v := os.Getenv(env); v != "" { return v, "环境变量 " + env }
+	if v := cf.Values[env]; v != "" { return v, fmt.Sprintf("%s 第 %d 行", cf.Path, cf.Lines[env]) }
+	return "", ""
+}
+
+func lookupSetting(env string) string {
+	configMutex.RLock(); defer configMutex.RUnlock()
+	v, _ := currentConfig.settingValue(env)
+	return v
+}
+
+// getEnv 读取配置项：环境变量优先，其次是配置文件，都没有时用默认值
+func getEnv(k, def string) string {
+	rememberDefault(k, def)
+	if v := lookupSetting(k); v != "" { return v }
+	return def
+}
+
+func getEnvInt(k string, def int) int {
+	rememberDefault(k, strconv.Itoa(def))
+	if v, err := strconv.Atoi(lookupSetting(k)); err == nil { return v }
+	return def
+}
+
+func getEnvDuration(k string, def time.Duration) time.Duration {
+	rememberDefault(k, def.String())
+	if v, err := time.ParseDuration(lookupSetting(k)); err == nil && v > 0 { return v }
+	return def
+}
+
+func rememberDefault(k, def string) {
+	configMutex.Lock(); defer configMutex.Unlock()
+	if _, ok := settingDefaults[k]; !ok { settingDefaults[k] = def }
+}
+
+// ================= 校验 =================
+
+// validateConfig 返回所有错误，不在第一个错误处停下
+func validateConfig(cf *configFile) []string {
+	errs := append([]string(nil), cf.Errors...)
+	for _, s := range configSettings {
+		v, where := cf.settingValue(s.Env)
+		if v == "" { continue }
+		err := checkSettingType(s.Type, v)
+		if err == nil && s.Check != nil { err = s.Check(v) }
+		if err != nil { errs = append(errs, fmt.Sprintf("%s (%s): %v", s.Key, where, err)) }
+	}
+	// 需要成对出现的配置
+	value := func(env string) string { v, _ := cf.settingValue(env); return v }
+	if (value("TLS_CERT_FILE") == "") != (value("TLS_KEY_FILE") == "") { errs = append(errs, "tls.cert_file 和 tls.key_file 需要同时设置") }
+	if value("TLS_CLIENT_CA_FILE") != "" && value("TLS_CERT_FILE") == "" { errs = append(errs, "设置了 tls.client_ca_file 但没有设置 tls.cert_file / tls.key_file") }
+	if isTruthy(value("TLS_CLIENT_CERT_REQUIRED")) && value("TLS_CLIENT_CA_FILE") == "" { errs = append(errs, "tls.client_cert_required 需要同时设置 tls.client_ca_file") }
+	if value("OIDC_ISSUER") != "" && value("OIDC_CLIENT_ID") == "" { errs = append(errs, "启用 OIDC 需要设置 oidc.client_id") }
+	if value("DATA_KEYS_FILE") != "" && value("DATA_ENCRYPTION_KEY") != "" { errs = append(errs, "encryption.keys_file 和 encryption.key 只能设置一个") }
+	return errs
+}
+
+func checkSettingType(t settingType, v string) error {
+	switch t {
+	case cfgInt:
+		if _, err := strconv.Atoi(v); err != nil { return fmt.Errorf("%q 不是整数", v) }
+	case cfgDuration:
+		d, err := time.ParseDuration(v)
+		if err != nil { return fmt.Errorf("%q 不是有效的时长（例如 30s、15m、24h）", v) }
+		if d <= 0 { return fmt.Errorf("时长必须大于 0") }
+	case cfgBool:
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "1", "true", "yes", "on", "0", "false", "no", "off":
+		default:
+			return fmt.Errorf("%q 不是布尔值（true / false）", v)
+		}
+	case cfgMap:
+		for _, item := range strings.Split(v, ",") {
+			if k, val, ok := strings.Cut(item, "="); !ok || strings.TrimSpace(k) == "" || strings.TrimSpace(val) == "" { return fmt.Errorf("%q 应为 key=value", item) }
+		}
+	}
+	return nil
+}
+
+func atLeast(min int) func(string) error {
+	return func(v string) error {
+		if n, _ := strconv.Atoi(v); n < min { return fmt.Errorf("不能小于 %d", min) }
+		return nil
+	}
+}
+
+func portRange(v string) error {
+	if n, _ := strconv.Atoi(v); n < 1 || n > 65535 { return fmt.Errorf("端口应在 1-65535 之间") }
+	return nil
+}
+
+func checkTimezone(v string) error {
+	if _, err := time.LoadLocation(v); err != nil { return fmt.Errorf("未知的时区 %q", v) }
+	return nil
+}
+
+func checkLogLevel(v string) error {
+	if _, ok := parseLogLevel(v); !ok { return fmt.Errorf("未知的日志级别 %q（可用: debug, info, warn, error）", v) }
+	return nil
+}
+
+func checkLogFormat(v string) error {
+	if v != "text" && v != "json" { return fmt.Errorf("未知的日志格式 %q（可用: text, json）", v) }
+	return nil
+}
+
+func fileExists(v string) error {
+	if _, err := os.Stat(v); err != nil { return fmt.Errorf("文件不可读: %v", err) }
+	return nil
+}
+
+func checkRoleList(v string) error {
+	for _, r := range strings.Split(v, ",") {
+		if r = strings.TrimSpace(r); r != "" && !containsString(allRoles, r) { return fmt.Errorf("未知的角色 %q（可用: %s）", r, strings.Join(allRoles, ", ")) }
+	}
+	return nil
+}
+
+func checkRoleMap(v string) error {
+	for _, item := range strings.Split(v, ",") {
+		_, role, _ := strings.Cut(item, "=")
+		if err := checkRoleList(role); err != nil { return err }
+	}
+	return nil
+}
+
+// mustValidConfig 启动时调用，有错误时全部列出后退出
+func mustValidConfig() {
+	errs := validateConfig(currentConfig)
+	if len(errs) > 0 {
+		for _, e := range errs { slog.Error("❌ 配置错误", "detail", e) }
+		log.Fatalf(">>> ❌ 配置有 %d 处错误，请修正后重新启动（可用 ./server config check 检查）", len(errs))
+	}
+	if currentConfig.Found { slog.Info("⚙️ 已加载配置文件", "file", currentConfig.Path, "settings", len(currentConfig.Values)) }
+}
+
+// ================= 热加载 =================
+
+func startConfigReloader() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	go func() {
+		for range ch { reloadConfig() }
+	}()
+}
+
+// reloadConfig 校验通过才替换；不能热加载的项保留旧值，直到重启
+func reloadConfig() {
+	cf := readConfigFile(configPath, configRequired)
+	if errs := validateConfig(cf); len(errs) > 0 {
+		for _, e := range errs { slog.Error("❌ 配置错误", "detail", e) }
+		slog.Warn("⚠️ 重新加载配置失败，继续使用原配置", "errors", len(errs))
+		return
+	}
+
+	configMutex.Lock()
+	old := currentConfig
+	var changed, needRestart []string
+	for _, s := range configSettings {
+		if old.Values[s.Env] == cf.Values[s.Env] { continue }
+		if !s.Reload {
+			needRestart = append(needRestart, s.Key)
+			if v, ok := old.Values[s.Env]; ok { cf.Values[s.Env] = v } else { delete(cf.Values, s.Env) }
+			continue
+		}
+		if os.Getenv(s.Env) == "" { changed = append(changed, s.Key) }
+	}
+	currentConfig = cf
+	configMutex.Unlock()
+
+	applyReloadable()
+	slog.Info("🔄 已重新加载配置", "file", configPath, "changed", joinOrNone(changed))
+	if len(needRestart) > 0 { slog.Warn("⚠️ 以下配置需要重启才能生效", "keys", strings.Join(needRestart, ", ")) }
+}
+
+func joinOrNone(items []string) string {
+	if len(items) == 0 { return "无" }
+	return strings.Join(items, ", ")
+}
+
+// reloadable 可热加载的配置值。SIGHUP 在信号处理 goroutine 里重新赋值，请求处理同时在读，
+// 所以不能用普通变量，读写都经过原子指针
+type reloadable[T any] struct{ p atomic.Pointer[T] }
+
+func newReloadable[T any](v T) *reloadable[T] {
+	r := &reloadable[T]{}
+	r.Set(v)
+	return r
+}
+
+func (r *reloadable[T]) Get() T  { return *r.p.Load() }
+func (r *reloadable[T]) Set(v T) { r.p.Store(&v) }
+
+func reloadString(p *reloadable[string], env string) { p.Set(getEnv(env, settingDefault(env))) }
+
+func reloadInt(p *reloadable[int], env string) {
+	def, _ := strconv.Atoi(settingDefault(env))
+	p.Set(getEnvInt(env, def))
+}
+
+func reloadDuration(p *reloadable[time.Duration], env string) {
+	def, _ := time.ParseDuration(settingDefault(env))
+	p.Set(getEnvDuration(env, def))
+}
+
+func settingDefault(env string) string {
+	configMutex.RLock(); defer configMutex.RUnlock()
+	return settingDefaults[env]
+}
+
+// applyReloadable 重新给可热加载的配置变量赋值，与 configSettings 里的 Reload 标记一一对应
+func applyReloadable() {
+	reloadInt(PageSize, "PAGE_SIZE")
+	reloadInt(LicenseMaxMonths, "LICENSE_MAX_MONTHS")
+	reloadString(TgBotToken, "TELEGRAM_BOT_TOKEN")
+	reloadString(TgChatID, "TELEGRAM_CHAT_ID")
+
+	reloadInt(RateLimitIPPerMin, "RATE_LIMIT_IP_PER_MIN")
+	reloadInt(RateLimitIPBurst, "RATE_LIMIT_IP_BURST")
+	reloadInt(RateLimitTokenPerMin, "RATE_LIMIT_TOKEN_PER_MIN")
+	reloadInt(RateLimitTokenBurst, "RATE_LIMIT_TOKEN_BURST")
+	ipLimiter.setRate(RateLimitIPPerMin.Get(), RateLimitIPBurst.Get())
+	tokenLimiter.setRate(RateLimitTokenPerMin.Get(), RateLimitTokenBurst.Get())
+
+	reloadInt(AuthLockoutThreshold, "AUTH_LOCKOUT_THRESHOLD")
+	reloadDuration(AuthLockoutBase, "AUTH_LOCKOUT_BASE")
+	reloadDuration(AuthLockoutMax, "AUTH_LOCKOUT_MAX")
+	reloadDuration(AuthFailureWindow, "AUTH_FAILURE_WINDOW")
+	reloadDuration(HMACMaxSkew, "HMAC_MAX_SKEW")
+
+	reloadDuration(SessionIdleTimeout, "SESSION_IDLE_TIMEOUT")
+	reloadDuration(SessionMaxAge, "SESSION_MAX_AGE")
+
+	reloadString(TOTPIssuer, "TOTP_ISSUER")
+	TOTPRequiredRoles.Set(strings.Split(getEnv("TOTP_REQUIRED_ROLES", ""), ","))
+	reloadDuration(TOTPStepUpWindow, "TOTP_STEP_UP_WINDOW")
+	reloadInt(TOTPStepUpDays, "TOTP_STEP_UP_DAYS")
+
+	reloadInt(WebhookMaxAttempts, "WEBHOOK_MAX_ATTEMPTS")
+	reloadInt(WebhookExpiringDays, "WEBHOOK_EXPIRING_DAYS")
+	reloadInt(WebhookLogLimit, "WEBHOOK_LOG_LIMIT")
+
+	reloadDuration(IdempotencyTTL, "IDEMPOTENCY_TTL")
+
+	reloadString(LogLevel, "LOG_LEVEL")
+	level, _ := parseLogLevel(LogLevel.Get())
+	logLevel.Set(level)
+}
+
+// ================= 命令行: config =================
+
+// ./server config check [-file config.yaml] [-v]
+func cmdConfig(args []string) error {
+	if len(args) == 0 || args[0] != "check" { return fmt.Errorf("用法: config check [-file 配置文件] [-v]") }
+	fs := flag.NewFlagSet("config check", flag.ExitOnError)
+	path := fs.String("file", configPath, "要检查的配置文件")
+	verbose := fs.Bool("v", false, "列出所有已设置的配置项及来源")
+	fs.Parse(args[1:])
+
+	explicit := configRequired
+	fs.Visit(func(f *flag.Flag) { if f.Name == "file" { explicit = true } })
+	cf := readConfigFile(*path, explicit)
+	if !cf.Found && len(cf.Errors) == 0 { fmt.Printf("ℹ️ 没有找到 %s，只检查环境变量\n", *path) }
+
+	if *verbose {
+		keys := make([]string, 0, len(configSettings))
+		for _, s := range configSettings { keys = append(keys, s.Key) }
+		sort.Strings(keys)
+		for _, k := range keys {
+			s := findSetting(k)
+			v, where := cf.settingValue(s.Env)
+			if v == "" { continue }
+			if s.Secret { v = "******" }
+			fmt.Printf("%-36s %-40s %s\n", s.Key, v, where)
+		}
+	}
+
+	errs := validateConfig(cf)
+	for _, e := range errs { fmt.Println("❌ " + e) }
+	if len(errs) > 0 { return fmt.Errorf("配置有 %d 处错误", len(errs)) }
+	fmt.Println("✅ 配置有效")
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func writeConfig(t *testing.T, yaml string) *configFile {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0600); err != nil { t.Fatal(err) }
+	return readConfigFile(path, true)
+}
+
+func hasError(errs []string, parts ...string) bool {
+	for _, e := range errs {
+		ok := true
+		for _, p := range parts { ok = ok && strings.Contains(e, p) }
+		if ok { return true }
+	}
+	return false
+}
+
+func TestValidateConfigReportsAllErrors(t *testing.T) {
+	cf := writeConfig(t, "server:\n  page_size: 0\nwebhooks:\n  max_attempts: abc\nlog:\n  level: loud\n")
+	errs := validateConfig(cf)
+	if len(errs) != 3 { t.Fatalf("期望 3 处错误，实际 %d: %v", len(errs), errs) }
+	for _, want := range [][]string{{"server.page_size", "第 2 行"}, {"webhooks.max_attempts", "第 4 行"}, {"log.level", "第 6 行"}} {
+		if !hasError(errs, want...) { t.Errorf("缺少错误 %v: %v", want, errs) }
+	}
+}
+
+func TestValidateConfigRejectsUnknownKeys(t *testing.T) {
+	cf := writeConfig(t, "server:\n  port: 8080\n  bogus: 1\nnope: 2\n")
+	errs := validateConfig(cf)
+	if !hasError(errs, "第 3 行", "未知的配置项 server.bogus") || !hasError(errs, "第 4 行", "未知的配置项 nope") { t.Fatalf("未知的键应带行号报错: %v", errs) }
+	if cf.Values["PORT"] != "8080" { t.Fatal("其他键照常读取") }
+}
+
+func TestConfigEnvOverridesFile(t *testing.T) {
+	cf := writeConfig(t, "server:\n  page_size: 50\n  grpc_port: 0\n")
+	t.Setenv("PAGE_SIZE", "30")
+	t.Setenv("GRPC_PORT", "9090")
+	if v, where := cf.settingValue("PAGE_SIZE"); v != "30" || where != "环境变量 PAGE_SIZE" { t.Fatalf("环境变量应优先: %q %q", v, where) }
+	if errs := validateConfig(cf); len(errs) != 0 { t.Fatalf("文件里的无效值被环境变量覆盖，不应报错: %v", errs) }
+	t.Setenv("PAGE_SIZE", "0")
+	if errs := validateConfig(cf); !hasError(errs, "server.page_size", "环境变量 PAGE_SIZE") { t.Fatalf("错误应指出来自环境变量: %v", errs) }
+}
+
+// go test -race 下检查 SIGHUP 重新加载与请求处理并发时没有数据竞争
+func TestApplyReloadableConcurrentReads(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				_ = PageSize.Get() + LicenseMaxMonths.Get()
+				_ = TgBotToken.Get() + strings.Join(TOTPRequiredRoles.Get(), ",")
+				_ = HMACMaxSkew.Get() + SessionIdleTimeout.Get() + IdempotencyTTL.Get()
+			}
+		}()
+	}
+	for j := 0; j < 50; j++ { applyReloadable() }
+	wg.Wait()
+}
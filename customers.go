@@ -0,0 +1,84 @@
+package main
+
+import (
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ================= 客户 =================
+// 对应计费系统里的客户，激活码和机器可以通过 customer_id 关联到客户，接口见 api_v1.go。
+// 经销商创建的客户归属于该经销商（Owner），其他经销商看不到。
+
+var customersFile = "customers.json"
+
+const kindCustomers = "customers"
+
+type Customer struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Email      string `json:"email,omitempty"`
+	ExternalID string `json:"external_id,omitempty"` // 计费系统中的客户编号，同一归属下唯一
+	Notes      string `json:"notes,omitempty"`
+	Owner      string `json:"owner,omitempty"`
+	CreatedAt  string `json:"created_at"`
+	UpdatedAt  string `json:"updated_at,omitempty"`
+}
+
+var (
+	customerList  []Customer
+	customerMutex sync.Mutex
+)
+
+func loadCustomers() {
+	customerMutex.Lock(); defer customerMutex.Unlock()
+	res, err := loadDataFile(customersFile, kindCustomers, &customerList)
+	if os.IsNotExist(err) { return }
+	if err != nil { log.Fatalf(">>> ❌ %v", err) }
+	if res.Migrated() { persistCustomers() }
+	slog.Info("🧾 已加载客户", "count", len(customerList))
+}
+
+// 调用方需持有 customerMutex
+func persistCustomers() {
+	data, err := encodeDataFile(kindCustomers, customerList)
+	if err != nil { slog.Error("❌ 序列化客户失败", "err", err); return }
+	if err := writeFileAtomic(customersFile, data, 0600); err != nil { slog.Error("❌ 保存客户失败", "err", err) }
+}
+
+// findCustomer 返回下标，找不到或不归属于 owner（非空时）返回 -1；调用方需持有 customerMutex
+func findCustomer(id, owner string) int {
+	for i, c := range customerList {
+		if c.ID == id && (owner == "" || c.Owner == owner) { return i }
+	}
+	return -1
+}
+
+// customerVisible 调用方能否把记录关联到这个客户
+func customerVisible(id, owner string) bool {
+	customerMutex.Lock(); defer customerMutex.Unlock()
+	return findCustomer(id, owner) >= 0
+}
+
+// externalIDTaken 同一归属下 external_id 不能重复；调用方需持有 customerMutex
+func externalIDTaken(externalID, owner, exceptID string) bool {
+	if externalID == "" { return false }
+	for _, c := range customerList {
+		if c.ID != exceptID && c.Owner == owner && strings.EqualFold(c.ExternalID, externalID) { return true }
+	}
+	return false
+}
+
+// customerInUse 还有激活码或机器关联着该客户
+func customerInUse(id string) bool {
+	mutex.Lock(); defer mutex.Unlock()
+	for _, h := range historyList {
+		if h.CustomerID == id { return true }
+	}
+	for _, m := range machineList {
+		if m.CustomerID == id { return true }
+	}
+	return false
+}
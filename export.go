@@ -0,0 +1,466 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ================= 筛选条件 =================
+
+// 与历史/机器页面共用，导出时沿用页面上当前的筛选
+type recordFilter struct {
+	Query  string // 机器码模糊匹配
+	From   string // YYYY-MM-DD，含当天
+	To     string // YYYY-MM-DD，含当天
+	Status string // active / expired / revoked，仅对历史记录生效
+	Owner  string // 经销商只能看到自己的数据，不来自查询参数
+}
+
+func parseRecordFilter(q url.Values) recordFilter {
+	return recordFilter{
+		Query:  strings.TrimSpace(q.Get("q")),
+		From:   strings.TrimSpace(q.Get("from")),
+		To:     strings.TrimSpace(q.Get("to")),
+		Status: strings.TrimSpace(q.Get("status")),
+	}
+}
+
+// Encode 生成可拼接在链接后面的查询串（不含 token/page）
+func (f recordFilter) Encode() string {
+	v := url.Values{}
+	if f.Query != "" { v.Set("q", f.Query) }
+	if f.From != "" { v.Set("from", f.From) }
+	if f.To != "" { v.Set("to", f.To) }
+	if f.Status != "" { v.Set("status", f.Status) }
+	return v.Encode()
+}
+
+// 按北京时间的日期比较
+func (f recordFilter) matchDate(ts string) bool {
+	day := localDay(ts)
+	if f.From != "" && day < f.From { return false }
+	if f.To != "" && day > f.To { return false }
+	return true
+}
+
+func (f recordFilter) MatchHistory(rec HistoryRecord, today string) bool {
+	if f.Owner != "" && rec.Owner != f.Owner { return false }
+	if f.Query != "" && !strings.Contains(strings.ToLower(rec.MachineID), strings.ToLower(f.Query)) { return false }
+	if !f.matchDate(rec.GenerateTime) { return false }
+	return f.Status == "" || licenseStatus(rec, today) == f.Status
+}
+
+func (f recordFilter) MatchMachine(rec MachineRecord) bool {
+	if f.Owner != "" && rec.Owner != f.Owner { return false }
+	if f.Query != "" && !strings.Contains(strings.ToLower(rec.MachineID), strings.ToLower(f.Query)) { return false }
+	return f.matchDate(rec.LastSeen)
+}
+
+// 调用方需持有 mutex
+func filterHistory(f recordFilter) []HistoryRecord {
+	today := time.Now().In(bizLocation()).Format("2006-01-02")
+	out := make([]HistoryRecord, 0, len(historyList))
+	for _, rec := range historyList {
+		if f.MatchHistory(rec, today) { out = append(out, rec) }
+	}
+	return out
+}
+
+// 调用方需持有 mutex
+func filterMachines(f recordFilter) []MachineRecord {
+	out := make([]MachineRecord, 0, len(machineList))
+	for _, rec := range machineList {
+		if f.MatchMachine(rec) { out = append(out, rec) }
+	}
+	return out
+}
+
+// ================= 导出 =================
+
+var historyColumns = []string{"id", "generate_time", "machine_id", "expiry_date", "license_code", "issued_by", "owner", "customer_id"}
+
+// 旧版本导出的文件没有这些列
+var optionalColumns = map[string]bool{"id": true, "issued_by": true, "owner": true, "customer_id": true}
+var machineColumns = []string{"machine_id", "last_seen", "owner", "customer_id"}
+
+func historyRows(list []HistoryRecord) [][]string {
+	rows := [][]string{historyColumns}
+	for _, rec := range list { rows = append(rows, []string{rec.ID, rec.GenerateTime, rec.MachineID, rec.ExpiryDate, rec.LicenseCode, rec.IssuedBy, rec.Owner, rec.CustomerID}) }
+	return rows
+}
+
+func machineRows(list []MachineRecord) [][]string {
+	rows := [][]string{machineColumns}
+	for _, rec := range list { rows = append(rows, []string{rec.MachineID, rec.LastSeen, rec.Owner, rec.CustomerID}) }
+	return rows
+}
+
+// GET /api/export/history?format=csv|json|xlsx&q=&from=&to=&status=
+// GET /api/export/machines?format=csv|json|xlsx&q=&from=&to=
+// 只接受 Authorization 头（Bearer / 客户端证书 / HMAC）或登录会话；URL 里的 token 会留在代理日志和浏览器历史中，直接拒绝
+func handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" { http.Error(w, "Method Not Allowed", 405); return }
+	if r.URL.Query().Has("token") { http.Error(w, "不再支持 URL 中的 token 参数，请使用 Authorization: Bearer", 400); return }
+	p, ok := authorize(w, r, "", scopeReadHistory)
+	if !ok { return }
+
+	target := strings.TrimPrefix(r.URL.Path, "/api/export/")
+	format := r.URL.Query().Get("format")
+	if format == "" { format = "csv" }
+	filter := p.recordFilter(r.URL.Query())
+
+	var jsonData interface{}
+	var rows [][]string
+	mutex.Lock()
+	switch target {
+	case "history":
+		list := filterHistory(filter)
+		jsonData, rows = list, historyRows(list)
+	case "machines":
+		list := filterMachines(filter)
+		jsonData, rows = list, machineRows(list)
+	default:
+		mutex.Unlock()
+		http.NotFound(w, r)
+		return
+	}
+	mutex.Unlock()
+
+	contentType, ok := exportContentTypes[format]
+	if !ok { http.Error(w, "不支持的格式: "+format, 400); return }
+	filename := fmt.Sprintf("%s-%s.%s", target, time.Now().In(bizLocation()).Format("20060102-150405"), format)
+	var buf bytes.Buffer
+	if err := encodeExport(&buf, target, format, jsonData, rows); err != nil { http.Error(w, err.Error(), 500); return }
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.Write(buf.Bytes())
+}
+
+var exportContentTypes = map[string]string{
+	"csv":  "text/csv; charset=utf-8",
+	"json": "application/json; charset=utf-8",
+	"xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// encodeExport 页面导出和命令行 history export 共用；format 需先用 exportContentTypes 校验
+func encodeExport(buf *bytes.Buffer, target, format string, jsonData interface{}, rows [][]string) error {
+	switch format {
+	case "csv":
+		buf.WriteString(utf8BOM) // 让 Excel 正确识别 UTF-8
+		cw := csv.NewWriter(buf)
+		for _, row := range rows {
+			cells := make([]string, len(row))
+			for i, v := range row { cells[i] = csvEscapeCell(v) }
+			cw.Write(cells)
+		}
+		cw.Flush()
+		return cw.Error()
+	case "json":
+		enc := json.NewEncoder(buf)
+		enc.SetIndent("", "  ")
+		return enc.Encode(jsonData)
+	case "xlsx":
+		return writeXLSX(buf, target, rows)
+	}
+	return fmt.Errorf("不支持的格式: %s", format)
+}
+
+// Excel 打开 CSV 时，以 = + - @ 等开头的单元格会被当作公式执行（CSV 注入）。导出时在这类单元格前加 '，
+// 导入时再去掉，导出的文件原样导入内容不变。XLSX 写的是字符串单元格，不会被当作公式。
+const csvFormulaChars = "=+-@\t\r"
+
+// csvNeedsQuote 本身以 ' 开头的值也要加，否则导入时分不清是原值还是转义
+func csvNeedsQuote(s string) bool {
+	if s == "" { return false }
+	if s[0] == '\'' { return csvNeedsQuote(s[1:]) }
+	return strings.IndexByte(csvFormulaChars, s[0]) >= 0
+}
+
+func csvEscapeCell(s string) string {
+	if csvNeedsQuote(s) { return "'" + s }
+	return s
+}
+
+func csvUnescapeCell(s string) string {
+	if strings.HasPrefix(s, "'") && csvNeedsQuote(s[1:]) { return s[1:] }
+	return s
+}
+
+// ================= 导入 =================
+
+const maxImportSize = 32 << 20
+
+const utf8BOM = "\xef\xbb\xbf"
+
+type importRowError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+type importReport struct {
+	Target        string           `json:"target"`
+	Format        string           `json:"format"`
+	Mode          string           `json:"mode"`
+	DryRun        bool             `json:"dry_run"`
+	Applied       bool             `json:"applied"`
+	Total         int              `json:"total"`
+	Added         int              `json:"added"`
+	Updated       int              `json:"updated"`
+	Duplicates    int              `json:"duplicates"`
+	DuplicateRows []int            `json:"duplicate_rows,omitempty"`
+	Invalid       int              `json:"invalid"`
+	Errors        []importRowError `json:"errors,omitempty"`
+}
+
+func (rep *importReport) addError(row int, format string, args ...interface{}) {
+	rep.Invalid++
+	rep.Errors = append(rep.Errors, importRowError{Row: row, Error: fmt.Sprintf(format, args...)})
+}
+
+func (rep *importReport) addDuplicate(row int) {
+	rep.Duplicates++
+	rep.DuplicateRows = append(rep.DuplicateRows, row)
+}
+
+// POST /api/import (multipart/form-data，Authorization: Bearer 或会话 + X-CSRF-Token 请求头)
+// 字段: target=history|machines, mode=merge|replace, dry_run=1, format(可选，默认按扩展名), file
+func handleImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" { http.Error(w, "Method Not Allowed", 405); return }
+	if r.URL.Query().Has("token") { http.Error(w, "不再支持 URL 中的 token 参数，请使用 Authorization: Bearer", 400); return }
+	// 先按请求头或会话鉴权，再解析最多 32 MB 的表单，未授权的请求不会被缓冲
+	p, ok := authorize(w, r, "", scopeAdmin)
+	if !ok { return }
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	if err := r.ParseMultipartForm(maxImportSize); err != nil { http.Error(w, "表单解析失败: "+err.Error(), 400); return }
+
+	rep := &importReport{
+		Target: r.FormValue("target"),
+		Mode:   r.FormValue("mode"),
+		DryRun: isTruthy(r.FormValue("dry_run")),
+		Format: strings.ToLower(r.FormValue("format")),
+	}
+	if rep.Mode == "" { rep.Mode = "merge" }
+	if rep.Mode != "merge" && rep.Mode != "replace" { http.Error(w, "mode 只能是 merge 或 replace", 400); return }
+	if rep.Target != "history" && rep.Target != "machines" { http.Error(w, "target 只能是 history 或 machines", 400); return }
+	// 覆盖导入相当于批量删除
+	if rep.Mode == "replace" && !rep.DryRun && !requireStepUp(w, r, p, "覆盖导入") { return }
+
+	file, header, err := r.FormFile("file")
+	if err != nil { http.Error(w, "缺少上传文件", 400); return }
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil { http.Error(w, err.Error(), 400); return }
+	if rep.Format == "" { rep.Format = strings.TrimPrefix(strings.ToLower(filepath.Ext(header.Filename)), ".") }
+
+	var status int
+	switch rep.Target {
+	case "history":
+		var list []HistoryRecord
+		if list, err = decodeHistoryImport(rep.Format, data); err == nil { status = importHistory(rep, list) }
+	case "machines":
+		var list []MachineRecord
+		if list, err = decodeMachineImport(rep.Format, data); err == nil { status = importMachines(rep, list) }
+	}
+	if err != nil { http.Error(w, err.Error(), 400); return }
+
+	if rep.Applied {
+		slog.InfoContext(r.Context(), "📥 导入完成", "target", rep.Target, "mode", rep.Mode, "added", rep.Added, "updated", rep.Updated, "duplicates", rep.Duplicates)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(rep)
+}
+
+// 表格类格式统一转成二维数组，第一行是表头，按列名取值
+func decodeTable(format string, data []byte) ([][]string, error) {
+	switch format {
+	case "csv":
+		cr := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte(utf8BOM))))
+		cr.FieldsPerRecord = -1
+		rows, err := cr.ReadAll()
+		for _, row := range rows {
+			for i, v := range row { row[i] = csvUnescapeCell(v) }
+		}
+		return rows, err
+	case "xlsx":
+		return readXLSX(data)
+	}
+	return nil, fmt.Errorf("不支持的格式: %s", format)
+}
+
+func tableToMaps(rows [][]string, columns []string) ([]map[string]string, error) {
+	if len(rows) == 0 { return nil, fmt.Errorf("文件为空") }
+	index := map[string]int{}
+	for i, name := range rows[0] { index[strings.ToLower(strings.TrimSpace(name))] = i }
+	for _, c := range columns {
+		if _, ok := index[c]; !ok && !optionalColumns[c] { return nil, fmt.Errorf("表头缺少列: %s", c) }
+	}
+	out := make([]map[string]string, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		m := map[string]string{}
+		for _, c := range columns {
+			if i, ok := index[c]; ok && i < len(row) { m[c] = strings.TrimSpace(row[i]) }
+		}
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+func decodeHistoryImport(format string, data []byte) ([]HistoryRecord, error) {
+	var list []HistoryRecord
+	if format == "json" {
+		if err := json.Unmarshal(data, &list); err != nil { return nil, fmt.Errorf("JSON 解析失败: %v", err) }
+		return list, nil
+	}
+	rows, err := decodeTable(format, data)
+	if err != nil { return nil, err }
+	maps, err := tableToMaps(rows, historyColumns)
+	if err != nil { return nil, err }
+	for _, m := range maps {
+		list = append(list, HistoryRecord{ID: m["id"], GenerateTime: m["generate_time"], MachineID: m["machine_id"], ExpiryDate: m["expiry_date"], LicenseCode: m["license_code"], IssuedBy: m["issued_by"], Owner: m["owner"], CustomerID: m["customer_id"]})
+	}
+	return list, nil
+}
+
+func decodeMachineImport(format string, data []byte) ([]MachineRecord, error) {
+	var list []MachineRecord
+	if format == "json" {
+		if err := json.Unmarshal(data, &list); err != nil { return nil, fmt.Errorf("JSON 解析失败: %v", err) }
+		return list, nil
+	}
+	rows, err := decodeTable(format, data)
+	if err != nil { return nil, err }
+	maps, err := tableToMaps(rows, machineColumns)
+	if err != nil { return nil, err }
+	for _, m := range maps {
+		list = append(list, MachineRecord{MachineID: m["machine_id"], LastSeen: m["last_seen"], Owner: m["owner"], CustomerID: m["customer_id"]})
+	}
+	return list, nil
+}
+
+// 行号从 1 开始，对应表格里的数据行（不含表头）/ JSON 数组下标 + 1
+// 时间统一转成 RFC 3339 UTC，旧格式的本地时间也能导入；没有 ID 的记录分配新 ID
+func importHistory(rep *importReport, incoming []HistoryRecord) int {
+	rep.Total = len(incoming)
+	key := func(h HistoryRecord) string { return h.GenerateTime + "|" + h.MachineID + "|" + h.LicenseCode }
+
+	mutex.Lock(); defer mutex.Unlock()
+	seen, seenID := map[string]bool{}, map[string]bool{}
+	if rep.Mode == "merge" {
+		for _, h := range historyList { seen[key(h)] = true; seenID[h.ID] = true }
+	}
+	var accepted []HistoryRecord
+	for i, h := range incoming {
+		row := i + 1
+		if h.MachineID == "" { rep.addError(row, "machine_id 为空"); continue }
+		if h.LicenseCode == "" { rep.addError(row, "license_code 为空"); continue }
+		if err := checkStoredValue("machine_id", h.MachineID); err != nil { rep.addError(row, "%v", err); continue }
+		if err := checkStoredValue("license_code", h.LicenseCode); err != nil { rep.addError(row, "%v", err); continue }
+		t, err := parseTimestamp(h.GenerateTime)
+		if err != nil { rep.addError(row, "generate_time 格式错误: %q", h.GenerateTime); continue }
+		h.GenerateTime = t.UTC().Format(time.RFC3339)
+		if _, err := time.Parse("2006-01-02", h.ExpiryDate); err != nil { rep.addError(row, "expiry_date 格式错误: %q", h.ExpiryDate); continue }
+		if seen[key(h)] || (h.ID != "" && seenID[h.ID]) { rep.addDuplicate(row); continue }
+		if h.ID == "" { h.ID = newRecordID() }
+		seen[key(h)], seenID[h.ID] = true, true
+		accepted = append(accepted, h)
+	}
+	rep.Added = len(accepted)
+	if rep.Invalid > 0 { return http.StatusUnprocessableEntity }
+	if rep.DryRun { return http.StatusOK }
+
+	if rep.Mode == "merge" { accepted = append(append([]HistoryRecord{}, historyList...), accepted...) }
+	sort.SliceStable(accepted, func(i, j int) bool { return accepted[i].GenerateTime < accepted[j].GenerateTime })
+	historyList = accepted
+	persistHistory()
+	rep.Applied = true
+	return http.StatusOK
+}
+
+func importMachines(rep *importReport, incoming []MachineRecord) int {
+	rep.Total = len(incoming)
+
+	mutex.Lock(); defer mutex.Unlock()
+	var result []MachineRecord
+	pos := map[string]int{}
+	if rep.Mode == "merge" {
+		result = append(result, machineList...)
+		for i, m := range result { pos[m.MachineID] = i }
+	}
+	inFile := map[string]bool{}
+	for i, m := range incoming {
+		row := i + 1
+		if m.MachineID == "" { rep.addError(row, "machine_id 为空"); continue }
+		if err := checkStoredValue("machine_id", m.MachineID); err != nil { rep.addError(row, "%v", err); continue }
+		t, err := parseTimestamp(m.LastSeen)
+		if err != nil { rep.addError(row, "last_seen 格式错误: %q", m.LastSeen); continue }
+		m.LastSeen = t.UTC().Format(time.RFC3339)
+		if inFile[m.MachineID] { rep.addDuplicate(row); continue }
+		inFile[m.MachineID] = true
+		if idx, ok := pos[m.MachineID]; ok {
+			// 已存在的机器只在导入的时间更新时才覆盖
+			if m.LastSeen > result[idx].LastSeen { result[idx].LastSeen = m.LastSeen; rep.Updated++ } else { rep.addDuplicate(row) }
+			continue
+		}
+		pos[m.MachineID] = len(result)
+		result = append(result, m)
+		rep.Added++
+	}
+	if rep.Invalid > 0 { return http.StatusUnprocessableEntity }
+	if rep.DryRun { return http.StatusOK }
+
+	machineList = result
+	persistMachines()
+	rep.Applied = true
+	return http.StatusOK
+}
+
+func isTruthy(s string) bool {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "1", "true", "yes", "on":
+		return true
+	}
+	return false
+}
+
+// ================= 页面工具栏 =================
+
+const toolbarCSS = `.toolbar{display:flex;flex-wrap:wrap;gap:6px;align-items:center;margin:10px 0;font-size:13px}.toolbar input,.toolbar select{padding:5px;border:1px solid #ccc;border-radius:4px;font-size:13px}.toolbar button,.toolbar a.btn{padding:5px 10px;border:1px solid #0071e3;background:#fff;color:#0071e3;border-radius:4px;cursor:pointer;text-decoration:none;font-size:13px}.toolbar button:hover,.toolbar a.btn:hover{background:#0071e3;color:#fff}#importRes{white-space:pre-wrap;font-family:monospace;font-size:12px;background:#f5f5f7;padding:8px;border-radius:6px;display:none}`
+
+// toolbarHtml 渲染筛选表单和导出链接（带上当前筛选条件）；导入表单只对管理员显示
+func toolbarHtml(page string, p *principal, s *session, f recordFilter, withStatus bool) string {
+	esc := html.EscapeString
+	target := strings.TrimPrefix(page, "/")
+	statusSel := ""
+	if withStatus {
+		opt := func(v, label string) string {
+			sel := ""
+			if f.Status == v { sel = " selected" }
+			return fmt.Sprintf(`<option value="%s"%s>%s</option>`, v, sel, label)
+		}
+		statusSel = `<select name="status">` + opt("", "全部状态") + opt("active", "未过期") + opt("expired", "已过期") + opt("revoked", "已吊销") + `</select>`
+	}
+	exportQuery := f.Encode()
+	exportLinks := ""
+	for _, format := range []string{"csv", "json", "xlsx"} {
+		exportLinks += fmt.Sprintf(`<a class="btn" href="/api/export/%s?%s&format=%s">导出 %s</a>`, target, esc(exportQuery), format, strings.ToUpper(format))
+	}
+	bar := fmt.Sprintf(`<form class="toolbar" method="GET" action="%s"><input name="q" placeholder="机器码" value="%s"><input type="date" name="from" value="%s">至<input type="date" name="to" value="%s">%s<button type="submit">筛选</button>%s</form>`,
+		page, esc(f.Query), esc(f.From), esc(f.To), statusSel, exportLinks)
+	if !p.HasScope(scopeAdmin) { return bar }
+	return bar + fmt.Sprintf(`
+	<form class="toolbar" id="importForm" onsubmit="doImport(event)"><input type="hidden" name="csrf_token" value="%s"><input type="hidden" name="target" value="%s"><input type="file" name="file" accept=".csv,.json,.xlsx" required><select name="mode"><option value="merge">合并</option><option value="replace">覆盖</option></select><label><input type="checkbox" name="dry_run" value="1" checked>仅预览</label><button type="submit">导入</button></form><div id="importRes"></div>
+	<script>async function doImport(e){e.preventDefault();var f=e.target,box=document.getElementById('importRes');if(f.mode.value==='replace'&&!f.dry_run.checked&&!confirm('覆盖模式会替换全部数据，确定吗？'))return;var r=await send('/api/import',{method:'POST',body:new FormData(f)});var t=await r.text();box.style.display='block';try{var d=JSON.parse(t);box.innerText=JSON.stringify(d,null,2);if(d.applied)setTimeout(()=>location.reload(),1500)}catch(_){box.innerText=t}}</script>`,
+		esc(s.CSRFToken), target)
+}
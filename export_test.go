@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportAuth(t *testing.T) {
+	withStore(t, []HistoryRecord{{ID: "h1", MachineID: "M-1", ExpiryDate: "2999-01-01", LicenseCode: "C", GenerateTime: "2026-01-01T00:00:00Z"}}, nil)
+
+	w := httptest.NewRecorder()
+	handleExport(w, httptest.NewRequest("GET", "/api/export/history?token=jhm_secret", nil))
+	if w.Code != 400 { t.Fatalf("URL 里带 token 应返回 400，实际 %d", w.Code) }
+
+	w = httptest.NewRecorder()
+	handleExport(w, httptest.NewRequest("GET", "/api/export/history", nil))
+	if w.Code != 401 { t.Fatalf("没有凭据应返回 401，实际 %d", w.Code) }
+
+	// authMiddleware 认证通过后放进上下文的调用者
+	r := httptest.NewRequest("GET", "/api/export/history?format=csv", nil)
+	r = r.WithContext(context.WithValue(r.Context(), ctxPrincipal, &principal{Name: "reader", Scopes: []string{scopeReadHistory}}))
+	w = httptest.NewRecorder()
+	handleExport(w, r)
+	if w.Code != 200 { t.Fatalf("Bearer 调用者应能导出，实际 %d %s", w.Code, w.Body.String()) }
+}
+
+func TestCSVExportEscapesFormulas(t *testing.T) {
+	rows := [][]string{
+		{"machine_id", "owner"},
+		{"=HYPERLINK(\"http://evil\")", "+1"},
+		{"-5", "@SUM(A1)"},
+		{"'=already", "''-x"},
+		{"'plain", "M-1"},
+		{"", "\tcmd"},
+	}
+	var buf bytes.Buffer
+	if err := encodeExport(&buf, "machines", "csv", nil, rows); err != nil { t.Fatal(err) }
+
+	raw, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(buf.String(), utf8BOM))).ReadAll()
+	if err != nil { t.Fatal(err) }
+	for _, row := range raw[1:] {
+		for _, cell := range row {
+			if cell != "" && strings.ContainsAny(cell[:1], "=+-@\t\r") { t.Errorf("单元格 %q 没有转义", cell) }
+		}
+	}
+	if raw[4][0] != "'plain" || raw[4][1] != "M-1" { t.Errorf("普通单元格不应改动: %q", raw[4]) }
+
+	back, err := decodeTable("csv", buf.Bytes())
+	if err != nil { t.Fatal(err) }
+	if !reflect.DeepEqual(back, rows) { t.Fatalf("导出再导入后不一致\n%q\n%q", rows, back) }
+}
+
+func TestSecretNotAcceptedInURL(t *testing.T) {
+	for _, c := range []struct {
+		path string
+		h    func(http.ResponseWriter, *http.Request)
+	}{{"/api/import?token=jhm_secret", handleImport}, {"/api/retention/run?token=jhm_secret", handleRetentionRun}} {
+		w := httptest.NewRecorder()
+		c.h(w, httptest.NewRequest("POST", c.path, nil))
+		if w.Code != 400 { t.Errorf("%s: URL 里带 token 应返回 400，实际 %d", c.path, w.Code) }
+	}
+}
+
+// importBody 拼出导入用的 multipart 表单
+func importBody(t *testing.T, fields map[string]string, filename, content string) (*bytes.Buffer, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for k, v := range fields { mw.WriteField(k, v) }
+	fw, err := mw.CreateFormFile("file", filename)
+	if err != nil { t.Fatal(err) }
+	io.WriteString(fw, content)
+	mw.Close()
+	return &buf, mw.FormDataContentType()
+}
+
+// runImport 以管理员身份调用 /api/import
+func runImport(t *testing.T, fields map[string]string, filename, content string) (importReport, int) {
+	t.Helper()
+	body, ctype := importBody(t, fields, filename, content)
+	r := httptest.NewRequest("POST", "/api/import", body)
+	r.Header.Set("Content-Type", ctype)
+	r = r.WithContext(context.WithValue(r.Context(), ctxPrincipal, &principal{Name: "root", Scopes: []string{scopeAdmin}}))
+	w := httptest.NewRecorder()
+	handleImport(w, r)
+	var rep importReport
+	if err := json.Unmarshal(w.Body.Bytes(), &rep); err != nil { t.Fatalf("%d %s", w.Code, w.Body.String()) }
+	return rep, w.Code
+}
+
+// countingReader 记录请求体被读了多少
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) { n, err := c.r.Read(p); c.n += n; return n, err }
+
+// 未授权的导入请求在解析表单之前就被拒绝，请求体不会被缓冲
+func TestImportAuthorizesBeforeParsing(t *testing.T) {
+	body, ctype := importBody(t, map[string]string{"target": "history", "token": "jhm_secret", "csrf_token": "x"}, "h.json", "[]")
+	cr := &countingReader{r: body}
+	r := httptest.NewRequest("POST", "/api/import", cr)
+	r.Header.Set("Content-Type", ctype)
+	w := httptest.NewRecorder()
+	handleImport(w, r)
+	if w.Code != 401 || cr.n != 0 { t.Fatalf("未授权应直接返回 401 且不读请求体，实际 %d，读了 %d 字节", w.Code, cr.n) }
+
+	// 表单里的 token 不算凭据
+	r = httptest.NewRequest("POST", "/api/import", strings.NewReader("token=jhm_secret&target=history"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	handleImport(w, r)
+	if w.Code != 401 { t.Fatalf("表单里带 token 应返回 401，实际 %d", w.Code) }
+
+	// 会话登录时 CSRF Token 必须放在请求头，multipart 表单里的不认
+	saved := tokenList
+	tok := APIToken{ID: "import-test", Name: "import", Scopes: []string{scopeAdmin}, LastUsedAt: time.Now().UTC().Format(time.RFC3339)}
+	tokenList = []APIToken{tok}
+	sess := newSession(tokenPrincipal(&tok))
+	t.Cleanup(func() { tokenList = saved; destroySession(sess.ID) })
+	body, ctype = importBody(t, map[string]string{"target": "history", "csrf_token": sess.CSRFToken}, "h.json", "[]")
+	cr = &countingReader{r: body}
+	r = httptest.NewRequest("POST", "/api/import", cr)
+	r.Header.Set("Content-Type", ctype)
+	r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: sess.ID})
+	w = httptest.NewRecorder()
+	handleImport(w, r)
+	if w.Code != 403 || cr.n != 0 { t.Fatalf("表单里的 CSRF Token 不应被接受，实际 %d，读了 %d 字节", w.Code, cr.n) }
+}
+
+func TestImportDryRun(t *testing.T) {
+	withStore(t, []HistoryRecord{{ID: "h1", MachineID: "M-1", ExpiryDate: "2999-01-01", LicenseCode: "C1", GenerateTime: "2026-01-01T00:00:00Z"}}, nil)
+	csvData := "id,generate_time,machine_id,expiry_date,license_code\n,2026-02-01T00:00:00Z,M-2,2999-01-01,C2\n,2026-03-01T00:00:00Z,M-3,2999-01-01,C3\n"
+
+	rep, code := runImport(t, map[string]string{"target": "history", "dry_run": "1"}, "h.csv", csvData)
+	if code != 200 || !rep.DryRun || rep.Applied || rep.Added != 2 || rep.Total != 2 { t.Fatalf("dry_run 报告不对: %d %+v", code, rep) }
+	if len(historyList) != 1 { t.Fatalf("dry_run 不应修改数据，实际 %d 条", len(historyList)) }
+	if _, err := os.Stat(historyFile); !os.IsNotExist(err) { t.Fatal("dry_run 不应写文件") }
+
+	// 覆盖模式的 dry_run 也不需要两步验证，且不修改数据
+	rep, code = runImport(t, map[string]string{"target": "history", "mode": "replace", "dry_run": "true"}, "h.csv", csvData)
+	if code != 200 || rep.Applied || len(historyList) != 1 { t.Fatalf("覆盖模式 dry_run 不对: %d %+v", code, rep) }
+
+	// 有错误行时 dry_run 返回 422 并列出行号
+	rep, code = runImport(t, map[string]string{"target": "history", "dry_run": "1"}, "h.csv", csvData+",bad-time,M-4,2999-01-01,C4\n")
+	if code != 422 || rep.Invalid != 1 || len(rep.Errors) != 1 || rep.Errors[0].Row != 3 { t.Fatalf("错误行报告不对: %d %+v", code, rep) }
+
+	rep, code = runImport(t, map[string]string{"target": "history"}, "h.csv", csvData)
+	if code != 200 || !rep.Applied || len(historyList) != 3 { t.Fatalf("正式导入不对: %d %+v，%d 条", code, rep, len(historyList)) }
+}
+
+func TestImportDuplicates(t *testing.T) {
+	withStore(t, []HistoryRecord{{ID: "h1", MachineID: "M-1", ExpiryDate: "2999-01-01", LicenseCode: "C1", GenerateTime: "2026-01-01T00:00:00Z"}}, nil)
+	history := `[
+		{"generate_time":"2026-01-01T08:00:00+08:00","machine_id":"M-1","expiry_date":"2999-01-01","license_code":"C1"},
+		{"generate_time":"2026-02-01T00:00:00Z","machine_id":"M-2","expiry_date":"2999-01-01","license_code":"C2"},
+		{"generate_time":"2026-02-01T00:00:00Z","machine_id":"M-2","expiry_date":"2999-01-01","license_code":"C2"},
+		{"id":"h1","generate_time":"2026-03-01T00:00:00Z","machine_id":"M-3","expiry_date":"2999-01-01","license_code":"C3"}
+	]`
+	rep, code := runImport(t, map[string]string{"target": "history"}, "h.json", history)
+	if code != 200 || rep.Added != 1 || rep.Duplicates != 3 || !reflect.DeepEqual(rep.DuplicateRows, []int{1, 3, 4}) { t.Fatalf("历史记录去重不对: %d %+v", code, rep) }
+	if len(historyList) != 2 { t.Fatalf("应只新增 1 条，实际共 %d 条", len(historyList)) }
+
+	machineList = []MachineRecord{{MachineID: "M-1", LastSeen: "2026-03-01T00:00:00Z"}}
+	machines := "machine_id,last_seen\nM-1,2026-02-01T00:00:00Z\nM-2,2026-02-01T00:00:00Z\nM-2,2026-04-01T00:00:00Z\n"
+	rep, code = runImport(t, map[string]string{"target": "machines"}, "m.csv", machines)
+	if code != 200 || rep.Added != 1 || rep.Updated != 0 || !reflect.DeepEqual(rep.DuplicateRows, []int{1, 3}) { t.Fatalf("机器去重不对: %d %+v", code, rep) }
+	if len(machineList) != 2 || machineList[0].LastSeen != "2026-03-01T00:00:00Z" { t.Fatalf("较旧的导入不应覆盖: %+v", machineList) }
+
+	rep, code = runImport(t, map[string]string{"target": "machines"}, "m.csv", "machine_id,last_seen\nM-1,2026-05-01T00:00:00Z\n")
+	if code != 200 || rep.Updated != 1 || rep.Duplicates != 0 || machineList[0].LastSeen != "2026-05-01T00:00:00Z" { t.Fatalf("较新的导入应更新 last_seen: %d %+v", code, rep) }
+}
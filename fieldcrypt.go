@@ -0,0 +1,276 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// ================= 字段级加密 =================
+// 内存里始终是明文，落盘（数据文件、备份、归档）时加密激活码，可选加密机器码。
+// 密文格式: enc:v1:<密钥ID>:<base64(nonce|密文)>，附加数据为字段名，防止字段间互换。
+//
+// 密钥来源（二选一）:
+//   DATA_KEYS_FILE=/run/secrets/data-keys.json
+//     {"primary":"2026-10","keys":{"2026-09":"<base64>","2026-10":"<base64>"},"index_key":"<base64>"}
+//   DATA_ENCRYPTION_KEY=<密钥ID>:<base64 32字节>  旧密钥放 DATA_ENCRYPTION_OLD_KEYS（逗号分隔）
+//   DATA_INDEX_KEY=<base64>                       盲索引密钥，不设置则由主密钥派生
+// ENCRYPT_MACHINE_ID=1 时机器码也加密，并写入 machine_id_bidx 盲索引供查找。
+
+const encPrefix = "enc:v1:"
+
+var (
+	EncryptMachineID = isTruthy(getEnv("ENCRYPT_MACHINE_ID", ""))
+	dataKeys         *keyring
+)
+
+type keyring struct {
+	primary  string
+	keys     map[string][]byte
+	indexKey []byte
+}
+
+type keyringFile struct {
+	Primary  string            `json:"primary"`
+	Keys     map[string]string `json:"keys"`
+	IndexKey string            `json:"index_key"`
+}
+
+// initDataEncryption 读取密钥配置，未配置时保持明文存储
+func initDataEncryption() error {
+	kr := &keyring{keys: map[string][]byte{}}
+	if path := getEnv("DATA_KEYS_FILE", ""); path != "" {
+		raw, err := os.ReadFile(path)
+		if err != nil { return fmt.Errorf("读取 DATA_KEYS_FILE 失败: %v", err) }
+		var kf keyringFile
+		if err := json.Unmarshal(raw, &kf); err != nil { return fmt.Errorf("DATA_KEYS_FILE 格式错误: %v", err) }
+		for id, b64 := range kf.Keys {
+			if err := kr.add(id, b64); err != nil { return err }
+		}
+		kr.primary = kf.Primary
+		if kf.IndexKey != "" {
+			if kr.indexKey, err = decodeKey(kf.IndexKey); err != nil { return fmt.Errorf("index_key: %v", err) }
+		}
+	} else if v := getEnv("DATA_ENCRYPTION_KEY", ""); v != "" {
+		id, b64 := splitKeySpec(v)
+		if err := kr.add(id, b64); err != nil { return err }
+		kr.primary = id
+		for _, spec := range strings.Split(getEnv("DATA_ENCRYPTION_OLD_KEYS", ""), ",") {
+			if spec = strings.TrimSpace(spec); spec == "" { continue }
+			if err := kr.add(splitKeySpec(spec)); err != nil { return err }
+		}
+		if v := getEnv("DATA_INDEX_KEY", ""); v != "" {
+			var err error
+			if kr.indexKey, err = decodeKey(v); err != nil { return fmt.Errorf("DATA_INDEX_KEY: %v", err) }
+		}
+	} else {
+		if EncryptMachineID { return fmt.Errorf("ENCRYPT_MACHINE_ID 需要配置数据加密密钥") }
+		return nil
+	}
+
+	if _, ok := kr.keys[kr.primary]; !ok { return fmt.Errorf("主密钥 %q 不存在", kr.primary) }
+	if kr.indexKey == nil {
+		kr.indexKey = hmacSHA256(kr.keys[kr.primary], "machine-id-blind-index")
+		slog.Warn("⚠️ 未配置独立的盲索引密钥，更换主密钥后需执行 encrypt-data 重建索引")
+	}
+	dataKeys = kr
+	slog.Info("🔒 数据加密已启用", "primary_key", kr.primary, "keys", len(kr.keys), "encrypt_machine_id", EncryptMachineID)
+	return nil
+}
+
+func (kr *keyring) add(id, b64 string) error {
+	if id == "" || strings.Contains(id, ":") { return fmt.Errorf("密钥ID %q 无效", id) }
+	key, err := decodeKey(b64)
+	if err != nil { return fmt.Errorf("密钥 %s: %v", id, err) }
+	kr.keys[id] = key
+	return nil
+}
+
+// "<id>:<base64>"，没有 ID 时记为 default
+func splitKeySpec(spec string) (string, string) {
+	if i := strings.Index(spec, ":"); i > 0 { return spec[:i], spec[i+1:] }
+	return "default", spec
+}
+
+func decodeKey(b64 string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(b64))
+	if err != nil { return nil, fmt.Errorf("不是有效的 base64") }
+	if len(key) != 32 { return nil, fmt.Errorf("长度必须是 32 字节 (AES-256)，当前 %d", len(key)) }
+	return key, nil
+}
+
+// encryptField 调用方传入的一定是明文：即使以 enc: 开头也照常加密，不能据此跳过，
+// 否则写进去的「明文」下次启动会被当作密文解密失败。外部输入的机器码和激活码由 checkStoredValue 拦截 enc: 前缀。
+func encryptField(field, plain string) (string, error) {
+	if dataKeys == nil || plain == "" { return plain, nil }
+	gcm, err := newGCM(dataKeys.keys[dataKeys.primary])
+	if err != nil { return "", err }
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil { return "", err }
+	sealed := gcm.Seal(nonce, nonce, []byte(plain), []byte(field))
+	return encPrefix + dataKeys.primary + ":" + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// checkStoredValue 会落盘的外部输入（机器码、导入的激活码）：不能带控制字符，也不能以 enc: 开头，
+// 未加密存储时这样的值读回来会被当成密文，导致服务无法启动
+func checkStoredValue(name, v string) error {
+	if strings.HasPrefix(v, "enc:") { return fmt.Errorf("%s 不能以 enc: 开头", name) }
+	for _, c := range v {
+		if unicode.IsControl(c) { return fmt.Errorf("%s 不能包含控制字符", name) }
+	}
+	return nil
+}
+
+// decryptField 明文原样返回，兼容加密前的旧数据
+func decryptField(field, value string) (string, error) {
+	if !strings.HasPrefix(value, encPrefix) { return value, nil }
+	id, payload := splitKeySpec(strings.TrimPrefix(value, encPrefix))
+	if dataKeys == nil { return "", fmt.Errorf("数据已加密，但未配置解密密钥") }
+	key, ok := dataKeys.keys[id]
+	if !ok { return "", fmt.Errorf("缺少密钥 %q，无法解密 %s", id, field) }
+	sealed, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil { return "", fmt.Errorf("%s 密文格式错误", field) }
+	gcm, err := newGCM(key)
+	if err != nil { return "", err }
+	if len(sealed) < gcm.NonceSize() { return "", fmt.Errorf("%s 密文格式错误", field) }
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(field))
+	if err != nil { return "", fmt.Errorf("%s 解密失败 (密钥 %s)", field, id) }
+	return string(plain), nil
+}
+
+func fieldKeyID(value string) string {
+	if !strings.HasPrefix(value, encPrefix) { return "" }
+	id, _ := splitKeySpec(strings.TrimPrefix(value, encPrefix))
+	return id
+}
+
+// machineBlindIndex 相同机器码得到相同索引，不解密也能按机器码查找
+func machineBlindIndex(machineID string) string {
+	if dataKeys == nil || machineID == "" { return "" }
+	return hex.EncodeToString(hmacSHA256(dataKeys.indexKey, machineID))[:32]
+}
+
+// ================= 记录的加解密 =================
+
+func encodeHistoryForDisk(list []HistoryRecord) ([]HistoryRecord, error) {
+	if dataKeys == nil { return list, nil }
+	out := make([]HistoryRecord, len(list))
+	for i, rec := range list {
+		var err error
+		if rec.LicenseCode, err = encryptField("license_code", rec.LicenseCode); err != nil { return nil, err }
+		if EncryptMachineID {
+			rec.MachineIDIndex = machineBlindIndex(rec.MachineID)
+			if rec.MachineID, err = encryptField("machine_id", rec.MachineID); err != nil { return nil, err }
+		}
+		out[i] = rec
+	}
+	return out, nil
+}
+
+func decodeHistoryFromDisk(list []HistoryRecord) error {
+	for i := range list {
+		var err error
+		if list[i].LicenseCode, err = decryptField("license_code", list[i].LicenseCode); err != nil { return fmt.Errorf("历史记录第 %d 条: %v", i+1, err) }
+		if list[i].MachineID, err = decryptField("machine_id", list[i].MachineID); err != nil { return fmt.Errorf("历史记录第 %d 条: %v", i+1, err) }
+		list[i].MachineIDIndex = ""
+	}
+	return nil
+}
+
+func encodeMachinesForDisk(list []MachineRecord) ([]MachineRecord, error) {
+	if dataKeys == nil || !EncryptMachineID { return list, nil }
+	out := make([]MachineRecord, len(list))
+	for i, m := range list {
+		var err error
+		m.MachineIDIndex = machineBlindIndex(m.MachineID)
+		if m.MachineID, err = encryptField("machine_id", m.MachineID); err != nil { return nil, err }
+		out[i] = m
+	}
+	return out, nil
+}
+
+func decodeMachinesFromDisk(list []MachineRecord) error {
+	for i := range list {
+		var err error
+		if list[i].MachineID, err = decryptField("machine_id", list[i].MachineID); err != nil { return fmt.Errorf("机器码第 %d 条: %v", i+1, err) }
+		list[i].MachineIDIndex = ""
+	}
+	return nil
+}
+
+// ================= 命令行: encrypt-data =================
+
+// ./server encrypt-data [-dry-run] [-find <机器码>]
+// 用主密钥重新加密数据文件和归档：既用于首次加密已有明文数据，也用于密钥轮换
+// （新密钥设为主密钥、旧密钥放进旧密钥列表，执行后即可移除旧密钥）。
+func cmdEncryptData(args []string) error {
+	fs := flag.NewFlagSet("encrypt-data", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "只统计各字段的加密状态，不写文件")
+	find := fs.String("find", "", "按盲索引在数据文件中查找机器码，不解密")
+	fs.Parse(args)
+
+	if dataKeys == nil { return fmt.Errorf("未配置数据加密密钥 (DATA_KEYS_FILE 或 DATA_ENCRYPTION_KEY)") }
+
+	if *find != "" {
+		var hist []HistoryRecord
+		var machines []MachineRecord
+		loadDataFile(historyFile, kindHistory, &hist)
+		loadDataFile(machineFile, kindMachines, &machines)
+		idx := machineBlindIndex(*find)
+		n := 0
+		for _, rec := range hist {
+			if rec.MachineIDIndex == idx || rec.MachineID == *find { n++; fmt.Printf("history: %s 到期 %s\n", rec.GenerateTime, rec.ExpiryDate) }
+		}
+		for _, m := range machines {
+			if m.MachineIDIndex == idx || m.MachineID == *find { fmt.Printf("machine: 最后生成 %s\n", m.LastSeen) }
+		}
+		fmt.Printf("共找到 %d 条历史记录\n", n)
+		return nil
+	}
+
+	var hist []HistoryRecord
+	var machines []MachineRecord
+	if _, err := loadDataFile(historyFile, kindHistory, &hist); err != nil && !os.IsNotExist(err) { return err }
+	if _, err := loadDataFile(machineFile, kindMachines, &machines); err != nil && !os.IsNotExist(err) { return err }
+
+	stats := map[string]int{}
+	for _, rec := range hist {
+		stats["license_code:"+keyLabel(rec.LicenseCode)]++
+		stats["history.machine_id:"+keyLabel(rec.MachineID)]++
+	}
+	for _, m := range machines { stats["machines.machine_id:"+keyLabel(m.MachineID)]++ }
+	for k, v := range stats { slog.Info("📊 统计", "item", k, "count", v) }
+	if *dryRun { return nil }
+
+	if err := decodeHistoryFromDisk(hist); err != nil { return err }
+	if err := decodeMachinesFromDisk(machines); err != nil { return err }
+
+	// 加密时遇到 enc: 前缀会跳过，这里已全部解密，因此统一用主密钥重新加密
+	mutex.Lock()
+	historyList, machineList = hist, machines
+	persistHistory()
+	persistMachines()
+	mutex.Unlock()
+
+	archives, _ := filepath.Glob(filepath.Join(ArchiveDir, "history-*.json.gz"))
+	for _, path := range archives {
+		recs, err := readArchive(path)
+		if err != nil { return err }
+		if err := writeArchive(path, recs); err != nil { return err }
+	}
+	slog.Info("🔒 已用新密钥重新加密", "primary_key", dataKeys.primary, "history", len(hist), "machines", len(machines), "archives", len(archives))
+	return nil
+}
+
+func keyLabel(v string) string {
+	if id := fieldKeyID(v); id != "" { return id }
+	return "明文"
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+
+	"license-server/licensepb"
+)
+
+// ================= gRPC 服务 =================
+// 设置 GRPC_PORT 后在单独的端口提供 license.v1.LicenseService（定义见 licensepb/license.proto），
+// 生成、校验、吊销、删除和查询与 HTTP 接口走同一套逻辑；另外注册标准健康检查 grpc.health.v1 和服务反射，可以直接用 grpcurl 调试。
+// 鉴权：metadata authorization: Bearer <Token>，同样计入失败锁定、按 IP 和按 Token 限流。
+// 配置了 TLS_CERT_FILE 时 gRPC 也走 TLS，客户端证书映射 Token 的规则与 HTTP 相同（见 machineauth.go）。
+// 修改 proto 后在仓库根目录运行 buf generate 重新生成 licensepb。
+
+var GRPCPort = getEnv("GRPC_PORT", "") // 为空时不启动
+
+// grpcScopes 各方法需要的权限；不在表里的方法（健康检查、反射）不需要鉴权
+var grpcScopes = map[string]string{
+	licensepb.LicenseService_GenerateLicense_FullMethodName: scopeGenerate,
+	licensepb.LicenseService_VerifyLicense_FullMethodName:   scopeReadHistory,
+	licensepb.LicenseService_RevokeLicense_FullMethodName:   scopeDelete,
+	licensepb.LicenseService_DeleteLicense_FullMethodName:   scopeDelete,
+	licensepb.LicenseService_ListHistory_FullMethodName:     scopeReadHistory,
+	licensepb.LicenseService_ListMachines_FullMethodName:    scopeReadHistory,
+}
+
+var (
+	grpcHealth = health.NewServer()
+	grpcServer *grpc.Server // 未启用时为 nil
+)
+
+func startGRPCServer() {
+	if GRPCPort == "" { return }
+	var opts []grpc.ServerOption
+	cfg, err := serverTLSConfig()
+	if err != nil { log.Fatalf(">>> ❌ gRPC TLS 配置错误: %v", err) }
+	if cfg != nil {
+		cert, err := tls.LoadX509KeyPair(TLSCertFile, TLSKeyFile)
+		if err != nil { log.Fatalf(">>> ❌ 读取 TLS 证书失败: %v", err) }
+		cfg.Certificates = []tls.Certificate{cert}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(cfg)))
+	}
+	opts = append(opts, grpc.ChainUnaryInterceptor(grpcLogInterceptor, grpcMetricsInterceptor, grpcAuthInterceptor))
+
+	srv := grpc.NewServer(opts...)
+	grpcServer = srv
+	licensepb.RegisterLicenseServiceServer(srv, licenseGRPCServer{})
+	healthpb.RegisterHealthServer(srv, grpcHealth)
+	grpcHealth.SetServingStatus(licensepb.LicenseService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	reflection.Register(srv)
+
+	lis, err := net.Listen("tcp", "0.0.0.0:"+GRPCPort)
+	if err != nil { log.Fatalf(">>> ❌ gRPC 端口监听失败: %v", err) }
+	slog.Info(">>> 🚀 gRPC 服务准备监听", "addr", "0.0.0.0:"+GRPCPort, "tls", cfg != nil)
+	go func() {
+		if err := srv.Serve(lis); err != nil { log.Fatalf(">>> ❌ gRPC 服务异常退出: %v", err) }
+	}()
+}
+
+// stopGRPCServer 健康检查置为 NOT_SERVING，等进行中的调用结束；ctx 到期后强制断开
+func stopGRPCServer(ctx context.Context) {
+	if grpcServer == nil { return }
+	grpcHealth.Shutdown()
+	done := make(chan struct{})
+	go func() { grpcServer.GracefulStop(); close(done) }()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		slog.Warn("⚠️ 等待 gRPC 调用结束超时，强制断开")
+		grpcServer.Stop()
+	}
+}
+
+// grpcRequest 把 gRPC 连接信息包装成 *http.Request，复用 clientIP、锁定和证书鉴权的逻辑。
+// 客户端 IP 只取连接的对端地址：metadata 由调用方随意填写，x-forwarded-for 不能信任
+func grpcRequest(ctx context.Context, method string) *http.Request {
+	r := &http.Request{Method: "POST", URL: &url.URL{Path: method}, Header: http.Header{}}
+	if pr, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = pr.Addr.String()
+		if info, ok := pr.AuthInfo.(credentials.TLSInfo); ok { r.TLS = &info.State }
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("authorization"); len(v) > 0 { r.Header.Set("Authorization", v[0]) }
+	}
+	return r
+}
+
+func grpcAuthError(err error) error {
+	ae, ok := err.(*authError)
+	if !ok { return status.Error(codes.Internal, err.Error()) }
+	switch ae.Status {
+	case 401:
+		return status.Error(codes.Unauthenticated, ae.Message)
+	case 403:
+		return status.Error(codes.PermissionDenied, ae.Message)
+	}
+	return status.Error(codes.Internal, ae.Message)
+}
+
+// grpcTooManyRequests 与 HTTP 的 429 一样带上 retry-after（秒）
+func grpcTooManyRequests(ctx context.Context, wait time.Duration, msg string) error {
+	secs := int(math.Ceil(wait.Seconds()))
+	if secs < 1 { secs = 1 }
+	grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(secs)))
+	return status.Errorf(codes.ResourceExhausted, "%s，请 %d 秒后再试", msg, secs)
+}
+
+// grpcMetricsInterceptor 记录耗时，鉴权和限流失败也计入
+func grpcMetricsInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	metricGRPCDuration.WithLabelValues(info.FullMethod, status.Code(err).String()).Observe(time.Since(start).Seconds())
+	return resp, err
+}
+
+// grpcAuthInterceptor 与 authMiddleware + authorize 相同：先客户端证书，再 Bearer Token，最后检查权限
+func grpcAuthInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	scope, ok := grpcScopes[info.FullMethod]
+	if !ok { return handler(ctx, req) }
+	r := grpcRequest(ctx, info.FullMethod)
+	if ok, wait := ipLimiter.allow(clientIP(r).String(), time.Now()); !ok { return nil, grpcTooManyRequests(ctx, wait, "请求过于频繁") }
+
+	t, err := certToken(r)
+	method := "gRPC mTLS"
+	if err == nil && t == nil {
+		if wait := lockoutWait(r, ""); wait > 0 { return nil, grpcTooManyRequests(ctx, wait, "鉴权失败次数过多，已临时锁定") }
+		method = "gRPC Bearer"
+		t, err = authenticateToken(r, bearerToken(r))
+	}
+	if err != nil {
+		if err != errNoCredentials { recordAuthFailure(r, "", method+": "+err.Error()) }
+		return nil, grpcAuthError(err)
+	}
+	recordAuthSuccess(r, "")
+
+	p := tokenPrincipal(t)
+	noteRequestPrincipal(ctx, p)
+	if ok, wait := principalLimit(p); !ok { return nil, grpcTooManyRequests(ctx, wait, "「"+p.Name+"」请求过于频繁") }
+	if !p.HasScope(scope) { return nil, status.Errorf(codes.PermissionDenied, "「%s」没有 %s 权限", p.Name, scope) }
+	return handler(context.WithValue(ctx, ctxPrincipal, p), req)
+}
+
+func grpcCaller(ctx context.Context) *principal {
+	p, _ := ctx.Value(ctxPrincipal).(*principal)
+	return p
+}
+
+// grpcPageSize 0 表示默认，上限与 /api/v1 的 limit 相同
+func grpcPageSize(n int32) (int, error) {
+	if n == 0 { return v1DefaultLimit, nil }
+	if n < 0 || n > v1MaxLimit { return 0, status.Errorf(codes.InvalidArgument, "page_size 必须在 1-%d 之间", v1MaxLimit) }
+	return int(n), nil
+}
+
+// grpcFilter 与 v1Filter 相同：经销商只能看自己的数据，其他调用者可以按 owner 筛选
+func grpcFilter(p *principal, f recordFilter, owner string) (recordFilter, error) {
+	f.Owner = p.Owner
+	if err := f.validate(); err != nil { return f, status.Error(codes.InvalidArgument, err.Error()) }
+	if owner != "" && p.Owner == "" { f.Owner = owner }
+	return f, nil
+}
+
+func toLicensePB(rec HistoryRecord, day string) *licensepb.License {
+	l := toLicenseV1(rec, day)
+	return &licensepb.License{Id: l.ID, MachineId: l.MachineID, ExpiryDate: l.ExpiryDate, Status: l.Status, LicenseCode: l.LicenseCode,
+		IssuedBy: l.IssuedBy, Owner: l.Owner, CustomerId: l.CustomerID, CreatedAt: l.CreatedAt, RevokedAt: l.RevokedAt}
+}
+
+func toMachinePB(m MachineRecord, expiries map[string]string) *licensepb.Machine {
+	v := toMachineV1(m, expiries)
+	return &licensepb.Machine{MachineId: v.MachineID, LastSeen: v.LastSeen, Owner: v.Owner, CustomerId: v.CustomerID, LatestExpiry: v.LatestExpiry}
+}
+
+type licenseGRPCServer struct {
+	licensepb.UnimplementedLicenseServiceServer
+}
+
+// GenerateLicense 参数校验与 POST /api/v1/licenses 相同；gRPC 只接受 Token，不涉及用户的二次验证
+func (licenseGRPCServer) GenerateLicense(ctx context.Context, req *licensepb.GenerateLicenseRequest) (*licensepb.GenerateLicenseResponse, error) {
+	p := grpcCaller(ctx)
+	machineID := strings.TrimSpace(req.GetMachineId())
+	if machineID == "" { return nil, status.Error(codes.InvalidArgument, "machine_id 不能为空") }
+	if err := checkStoredValue("machine_id", machineID); err != nil { return nil, status.Error(codes.InvalidArgument, err.Error()) }
+	if _, err := parseExpiry(req.GetExpiry()); err != nil { return nil, status.Error(codes.InvalidArgument, "expiry: "+strings.TrimPrefix(err.Error(), "❌ ")) }
+	if req.GetCustomerId() != "" && !customerVisible(req.GetCustomerId(), p.Owner) { return nil, status.Error(codes.InvalidArgument, "customer_id 不存在") }
+	if err := p.checkLicenseDuration(req.GetExpiry()); err != nil { return nil, status.Error(codes.PermissionDenied, err.Error()) }
+
+	rec, err := issueLicense(ctx, p, machineID, req.GetExpiry(), req.GetCustomerId())
+	if errors.Is(err, errMachineOwned) { return nil, status.Error(codes.PermissionDenied, err.Error()) }
+	if err != nil { return nil, status.Error(codes.Internal, err.Error()) }
+	return &licensepb.GenerateLicenseResponse{License: toLicensePB(rec, today())}, nil
+}
+
+// VerifyLicense 激活码本身有问题时不返回错误，而是 valid=false 并说明原因
+func (licenseGRPCServer) VerifyLicense(ctx context.Context, req *licensepb.VerifyLicenseRequest) (*licensepb.VerifyLicenseResponse, error) {
+	if strings.TrimSpace(req.GetLicenseCode()) == "" { return nil, status.Error(codes.InvalidArgument, "license_code 不能为空") }
+	data, keyID, err := verifyLicenseCode(req.GetLicenseCode())
+	if err != nil { return &licensepb.VerifyLicenseResponse{Reason: err.Error()}, nil }
+
+	expiry := time.Unix(data.ExpiryUTC, 0)
+	resp := &licensepb.VerifyLicenseResponse{MachineId: data.MachineID, ExpiryUtc: data.ExpiryUTC, ExpiryDate: expiry.In(bizLocation()).Format("2006-01-02"),
+		Expired: time.Now().After(expiry), KeyId: keyID, Revoked: licenseRevoked(req.GetLicenseCode())}
+	switch {
+	case req.GetMachineId() != "" && req.GetMachineId() != data.MachineID:
+		resp.Reason = "激活码不属于该机器码"
+	case resp.Revoked:
+		resp.Reason = "激活码已吊销"
+	case resp.Expired:
+		resp.Reason = "激活码已过期"
+	default:
+		resp.Valid = true
+	}
+	return resp, nil
+}
+
+func (licenseGRPCServer) RevokeLicense(ctx context.Context, req *licensepb.RevokeLicenseRequest) (*licensepb.RevokeLicenseResponse, error) {
+	if req.GetId() == "" { return nil, status.Error(codes.InvalidArgument, "id 不能为空") }
+	rec, ok := revokeLicense(ctx, req.GetId(), grpcCaller(ctx))
+	if !ok { return nil, status.Error(codes.NotFound, "激活码记录不存在") }
+	return &licensepb.RevokeLicenseResponse{License: toLicensePB(rec, today())}, nil
+}
+
+func (licenseGRPCServer) DeleteLicense(ctx context.Context, req *licensepb.DeleteLicenseRequest) (*licensepb.DeleteLicenseResponse, error) {
+	if req.GetId() == "" { return nil, status.Error(codes.InvalidArgument, "id 不能为空") }
+	removed, ok := deleteLicense(ctx, req.GetId(), grpcCaller(ctx))
+	if !ok { return nil, status.Error(codes.NotFound, "激活码记录不存在") }
+	return &licensepb.DeleteLicenseResponse{License: toLicensePB(removed, today())}, nil
+}
+
+func (licenseGRPCServer) ListHistory(ctx context.Context, req *licensepb.ListHistoryRequest) (*licensepb.ListHistoryResponse, error) {
+	p := grpcCaller(ctx)
+	f, err := grpcFilter(p, recordFilter{Query: strings.TrimSpace(req.GetQuery()), From: req.GetFrom(), To: req.GetTo(), Status: req.GetStatus()}, req.GetOwner())
+	if err != nil { return nil, err }
+	limit, err := grpcPageSize(req.GetPageSize())
+	if err != nil { return nil, err }
+
+	list, keys := queryLicenses(f, req.GetMachineId(), req.GetCustomerId(), req.GetIssuedBy())
+	start, end, next, err := pageRange(keys, limit, req.GetPageToken())
+	if err != nil { return nil, status.Error(codes.InvalidArgument, "page_token 无效") }
+	day := today()
+	resp := &licensepb.ListHistoryResponse{NextPageToken: next}
+	for _, rec := range list[start:end] { resp.Licenses = append(resp.Licenses, toLicensePB(rec, day)) }
+	return resp, nil
+}
+
+func (licenseGRPCServer) ListMachines(ctx context.Context, req *licensepb.ListMachinesRequest) (*licensepb.ListMachinesResponse, error) {
+	p := grpcCaller(ctx)
+	f, err := grpcFilter(p, recordFilter{Query: strings.TrimSpace(req.GetQuery()), From: req.GetFrom(), To: req.GetTo()}, req.GetOwner())
+	if err != nil { return nil, err }
+	limit, err := grpcPageSize(req.GetPageSize())
+	if err != nil { return nil, err }
+
+	list, keys, expiries := queryMachines(f, req.GetCustomerId())
+	start, end, next, err := pageRange(keys, limit, req.GetPageToken())
+	if err != nil { return nil, status.Error(codes.InvalidArgument, "page_token 无效") }
+	resp := &licensepb.ListMachinesResponse{NextPageToken: next}
+	for _, m := range list[start:end] { resp.Machines = append(resp.Machines, toMachinePB(m, expiries)) }
+	return resp, nil
+}
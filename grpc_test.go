@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"license-server/licensepb"
+)
+
+func TestGRPCRevokeLicense(t *testing.T) {
+	withStore(t, []HistoryRecord{{ID: "h1", MachineID: "M-1", ExpiryDate: "2999-01-01", LicenseCode: "CODE-1", GenerateTime: "2026-01-01T00:00:00Z"}}, nil)
+	ctx := context.WithValue(context.Background(), ctxPrincipal, &principal{Name: "ops", Scopes: []string{scopeDelete}})
+	srv := licenseGRPCServer{}
+
+	if licenseRevoked("CODE-1") { t.Fatal("吊销前不应视为已吊销") }
+	resp, err := srv.RevokeLicense(ctx, &licensepb.RevokeLicenseRequest{Id: "h1"})
+	if err != nil { t.Fatal(err) }
+	if l := resp.GetLicense(); l.GetStatus() != "revoked" || l.GetRevokedAt() == "" { t.Fatalf("返回的记录: %v", l) }
+	if len(historyList) != 1 { t.Fatal("吊销不应删除记录") }
+	if !licenseRevoked("CODE-1") { t.Fatal("吊销后 VerifyLicense 应能查到") }
+
+	_, err = srv.RevokeLicense(ctx, &licensepb.RevokeLicenseRequest{Id: "missing"})
+	if status.Code(err) != codes.NotFound { t.Fatalf("期望 NotFound，实际 %v", err) }
+	if grpcScopes[licensepb.LicenseService_RevokeLicense_FullMethodName] != scopeDelete { t.Fatal("RevokeLicense 需要 delete 权限") }
+}
+
+func TestGRPCRequestIgnoresForwardedFor(t *testing.T) {
+	saved := TrustProxyHeaders
+	TrustProxyHeaders = true
+	t.Cleanup(func() { TrustProxyHeaders = saved })
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 5000}})
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("x-forwarded-for", "10.0.0.1", "authorization", "Bearer jhm_x"))
+	r := grpcRequest(ctx, "/license.v1.LicenseService/GenerateLicense")
+	if ip := clientIP(r).String(); ip != "203.0.113.7" { t.Fatalf("clientIP = %s，应取连接对端地址", ip) }
+	if r.Header.Get("Authorization") != "Bearer jhm_x" { t.Fatal("authorization 应照常传递") }
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ================= 幂等键 =================
+// 计费系统超时重试 /api/generate 或 POST /api/v1/licenses 时带上同一个 Idempotency-Key 请求头，
+// 服务端直接返回第一次的响应（同一个激活码），不会重复签名、写历史记录或推送通知。
+//  - 键按调用方（用户名或 Token 名称）和接口区分，成功的响应保留 IDEMPOTENCY_TTL（默认 24 小时）
+//  - 同一个键换了请求内容：422；第一次请求还没处理完：409，稍后重试即可
+//  - 失败的请求不记录，修正后可以用同一个键重试
+// 缓存只在内存中，重启后失效。
+
+var IdempotencyTTL = newReloadable(getEnvDuration("IDEMPOTENCY_TTL", 24*time.Hour))
+
+const maxIdempotencyKeyLen = 255
+
+type idempotencyEntry struct {
+	Fingerprint string
+	Done        bool // false 表示第一次请求还在处理
+	Status      int
+	Header      http.Header
+	Body        []byte
+	ExpiresAt   time.Time
+}
+
+var (
+	idempotencyStore = map[string]*idempotencyEntry{}
+	idempotencyMutex sync.Mutex
+)
+
+// idempotencyRecorder 把响应照常写给客户端，同时留一份用于重放
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	if rec.status == 0 { rec.status = status }
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 { rec.status = 200 }
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+func requestFingerprint(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// withIdempotency 没有 Idempotency-Key 时直接执行 fn；有的话按键去重。fingerprint 由调用方根据请求内容计算。
+func withIdempotency(w http.ResponseWriter, r *http.Request, p *principal, fingerprint string, fn func(w http.ResponseWriter)) {
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" { fn(w); return }
+	if len(key) > maxIdempotencyKeyLen || strings.ContainsAny(key, "\r\n") { writeError(w, r, 400, "invalid_request", "Idempotency-Key 格式错误"); return }
+	storeKey := p.Name + "\n" + r.URL.Path + "\n" + key
+	now := time.Now()
+
+	idempotencyMutex.Lock()
+	e := idempotencyStore[storeKey]
+	if e != nil && e.Done && now.After(e.ExpiresAt) { e = nil }
+	switch {
+	case e == nil:
+		idempotencyStore[storeKey] = &idempotencyEntry{Fingerprint: fingerprint}
+	case e.Fingerprint != fingerprint:
+		idempotencyMutex.Unlock()
+		writeError(w, r, 422, "idempotency_key_reused", "Idempotency-Key 已用于内容不同的请求")
+		return
+	case !e.Done:
+		idempotencyMutex.Unlock()
+		w.Header().Set("Retry-After", "1")
+		writeError(w, r, 409, "idempotency_in_progress", "相同 Idempotency-Key 的请求正在处理，请稍后重试")
+		return
+	default:
+		idempotencyMutex.Unlock()
+		for k, v := range e.Header { w.Header()[k] = v }
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(e.Status)
+		w.Write(e.Body)
+		slog.InfoContext(r.Context(), "🔁 重放了 Idempotency-Key 的响应", "principal", p.Name, "idempotency_key", key, "path", r.URL.Path)
+		return
+	}
+	idempotencyMutex.Unlock()
+
+	rec := &idempotencyRecorder{ResponseWriter: w}
+	completed := false
+	// fn panic 时也要清掉“处理中”的记录，否则这个键之后一直返回 409（清理任务只处理已完成的记录）
+	defer func() {
+		idempotencyMutex.Lock(); defer idempotencyMutex.Unlock()
+		if !completed || rec.status < 200 || rec.status > 299 { delete(idempotencyStore, storeKey); return }
+		header := http.Header{}
+		for _, k := range []string{"Content-Type", "Location"} {
+			if v := w.Header().Get(k); v != "" { header.Set(k, v) }
+		}
+		idempotencyStore[storeKey] = &idempotencyEntry{Fingerprint: fingerprint, Done: true, Status: rec.status, Header: header, Body: rec.body.Bytes(), ExpiresAt: now.Add(IdempotencyTTL.Get())}
+	}()
+	fn(rec)
+	completed = true
+}
+
+func startIdempotencySweeper() {
+	go func() {
+		for range time.Tick(time.Minute) {
+			now := time.Now()
+			idempotencyMutex.Lock()
+			for k, e := range idempotencyStore {
+				if e.Done && now.After(e.ExpiresAt) { delete(idempotencyStore, k) }
+			}
+			idempotencyMutex.Unlock()
+		}
+	}()
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func idempotentRequest(key string) *http.Request {
+	r := httptest.NewRequest("POST", "/api/v1/licenses", nil)
+	r.Header.Set("Idempotency-Key", key)
+	return r
+}
+
+func TestIdempotencyPanicReleasesKey(t *testing.T) {
+	p := &principal{Name: "billing"}
+	func() {
+		defer func() { recover() }()
+		withIdempotency(httptest.NewRecorder(), idempotentRequest("k-panic"), p, "fp", func(w http.ResponseWriter) { panic("boom") })
+		t.Fatal("应该 panic")
+	}()
+	calls := 0
+	rec := httptest.NewRecorder()
+	withIdempotency(rec, idempotentRequest("k-panic"), p, "fp", func(w http.ResponseWriter) { calls++; w.WriteHeader(201) })
+	if calls != 1 || rec.Code != 201 { t.Fatalf("panic 后同一个键应能重试，实际状态 %d，调用 %d 次", rec.Code, calls) }
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	p := &principal{Name: "billing"}
+	calls := 0
+	fn := func(w http.ResponseWriter) { calls++; w.Header().Set("Content-Type", "application/json"); w.WriteHeader(201); w.Write([]byte(`{"id":1}`)) }
+	withIdempotency(httptest.NewRecorder(), idempotentRequest("k-replay"), p, "fp", fn)
+	rec := httptest.NewRecorder()
+	withIdempotency(rec, idempotentRequest("k-replay"), p, "fp", fn)
+	if calls != 1 || rec.Code != 201 || rec.Body.String() != `{"id":1}` || rec.Header().Get("Idempotent-Replayed") != "true" { t.Fatalf("期望重放第一次的响应，实际 %d %q，调用 %d 次", rec.Code, rec.Body.String(), calls) }
+	rec = httptest.NewRecorder()
+	withIdempotency(rec, idempotentRequest("k-replay"), p, "other", fn)
+	if rec.Code != 422 { t.Fatalf("内容不同应返回 422，实际 %d", rec.Code) }
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKnownKeysCachedUntilKeygen(t *testing.T) {
+	withDataDir(t, t.TempDir())
+	t.Cleanup(invalidateKnownKeys)
+	first, _, _, err := generateSigningKey()
+	if err != nil { t.Fatal(err) }
+	code1, err := generateLicenseCore("M-1", cliExpiry())
+	if err != nil { t.Fatal(err) }
+	if _, id, err := verifyLicenseCode(code1); err != nil || id != first { t.Fatalf("验签失败: %v %s", err, id) }
+
+	// 已缓存：不再读取 KEYS_DIR
+	saved, _ := os.ReadFile(keyPath(first))
+	if err := os.Remove(keyPath(first)); err != nil { t.Fatal(err) }
+	if _, id, err := verifyLicenseCode(code1); err != nil || id != first { t.Fatalf("验签应使用缓存的公钥: %v %s", err, id) }
+	if err := os.WriteFile(keyPath(first), saved, 0600); err != nil { t.Fatal(err) }
+
+	// keygen 之后缓存失效，新旧密钥签发的激活码都能验证
+	second, _, _, err := generateSigningKey()
+	if err != nil { t.Fatal(err) }
+	code2, err := generateLicenseCore("M-2", cliExpiry())
+	if err != nil { t.Fatal(err) }
+	if _, id, err := verifyLicenseCode(code2); err != nil || id != second { t.Fatalf("新密钥验签失败: %v %s", err, id) }
+	if _, id, err := verifyLicenseCode(code1); err != nil || id != first { t.Fatalf("旧密钥验签失败: %v %s", err, id) }
+
+	// 切换 KEYS_DIR 时重新加载
+	KeysDir = filepath.Join(t.TempDir(), "keys")
+	if _, _, err := verifyLicenseCode(code2); err == nil { t.Fatal("换了 KEYS_DIR 后不应再认旧目录的密钥") }
+}
@@ -0,0 +1,333 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: licensepb/license.proto
+
+package licensepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	LicenseService_GenerateLicense_FullMethodName = "/license.v1.LicenseService/GenerateLicense"
+	LicenseService_VerifyLicense_FullMethodName   = "/license.v1.LicenseService/VerifyLicense"
+	LicenseService_RevokeLicense_FullMethodName   = "/license.v1.LicenseService/RevokeLicense"
+	LicenseService_DeleteLicense_FullMethodName   = "/license.v1.LicenseService/DeleteLicense"
+	LicenseService_ListHistory_FullMethodName     = "/license.v1.LicenseService/ListHistory"
+	LicenseService_ListMachines_FullMethodName    = "/license.v1.LicenseService/ListMachines"
+)
+
+// LicenseServiceClient is the client API for LicenseService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// LicenseService 与 HTTP 接口（/api/generate、/api/v1）共用同一套生成、校验、吊销、删除和查询逻辑。
+// 鉴权：metadata 里带 authorization: Bearer <Token>，各方法所需权限与 HTTP 接口相同。
+// 激活码是离线校验的：RevokeLicense 把签发记录标记为已吊销，之后 VerifyLicense 返回 revoked，
+// 但只在客户端离线验签的激活码在到期前仍然有效。DeleteLicense 直接删除记录，不留吊销标记。
+type LicenseServiceClient interface {
+	// 生成激活码，需要 generate 权限
+	GenerateLicense(ctx context.Context, in *GenerateLicenseRequest, opts ...grpc.CallOption) (*GenerateLicenseResponse, error)
+	// 校验激活码签名（任一已知签名密钥）并解出机器码和到期时间，需要 read-history 权限
+	VerifyLicense(ctx context.Context, in *VerifyLicenseRequest, opts ...grpc.CallOption) (*VerifyLicenseResponse, error)
+	// 吊销激活码，签发记录保留并推送 license.revoked；已吊销的再次调用直接返回。需要 delete 权限
+	RevokeLicense(ctx context.Context, in *RevokeLicenseRequest, opts ...grpc.CallOption) (*RevokeLicenseResponse, error)
+	// 删除签发记录，需要 delete 权限
+	DeleteLicense(ctx context.Context, in *DeleteLicenseRequest, opts ...grpc.CallOption) (*DeleteLicenseResponse, error)
+	// 签发记录，按生成时间倒序分页，需要 read-history 权限
+	ListHistory(ctx context.Context, in *ListHistoryRequest, opts ...grpc.CallOption) (*ListHistoryResponse, error)
+	// 机器码，按最近生成时间倒序分页，需要 read-history 权限
+	ListMachines(ctx context.Context, in *ListMachinesRequest, opts ...grpc.CallOption) (*ListMachinesResponse, error)
+}
+
+type licenseServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewLicenseServiceClient(cc grpc.ClientConnInterface) LicenseServiceClient {
+	return &licenseServiceClient{cc}
+}
+
+func (c *licenseServiceClient) GenerateLicense(ctx context.Context, in *GenerateLicenseRequest, opts ...grpc.CallOption) (*GenerateLicenseResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GenerateLicenseResponse)
+	err := c.cc.Invoke(ctx, LicenseService_GenerateLicense_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *licenseServiceClient) VerifyLicense(ctx context.Context, in *VerifyLicenseRequest, opts ...grpc.CallOption) (*VerifyLicenseResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(VerifyLicenseResponse)
+	err := c.cc.Invoke(ctx, LicenseService_VerifyLicense_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *licenseServiceClient) RevokeLicense(ctx context.Context, in *RevokeLicenseRequest, opts ...grpc.CallOption) (*RevokeLicenseResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RevokeLicenseResponse)
+	err := c.cc.Invoke(ctx, LicenseService_RevokeLicense_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *licenseServiceClient) DeleteLicense(ctx context.Context, in *DeleteLicenseRequest, opts ...grpc.CallOption) (*DeleteLicenseResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteLicenseResponse)
+	err := c.cc.Invoke(ctx, LicenseService_DeleteLicense_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *licenseServiceClient) ListHistory(ctx context.Context, in *ListHistoryRequest, opts ...grpc.CallOption) (*ListHistoryResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListHistoryResponse)
+	err := c.cc.Invoke(ctx, LicenseService_ListHistory_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *licenseServiceClient) ListMachines(ctx context.Context, in *ListMachinesRequest, opts ...grpc.CallOption) (*ListMachinesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListMachinesResponse)
+	err := c.cc.Invoke(ctx, LicenseService_ListMachines_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// LicenseServiceServer is the server API for LicenseService service.
+// All implementations must embed UnimplementedLicenseServiceServer
+// for forward compatibility.
+//
+// LicenseService 与 HTTP 接口（/api/generate、/api/v1）共用同一套生成、校验、吊销、删除和查询逻辑。
+// 鉴权：metadata 里带 authorization: Bearer <Token>，各方法所需权限与 HTTP 接口相同。
+// 激活码是离线校验的：RevokeLicense 把签发记录标记为已吊销，之后 VerifyLicense 返回 revoked，
+// 但只在客户端离线验签的激活码在到期前仍然有效。DeleteLicense 直接删除记录，不留吊销标记。
+type LicenseServiceServer interface {
+	// 生成激活码，需要 generate 权限
+	GenerateLicense(context.Context, *GenerateLicenseRequest) (*GenerateLicenseResponse, error)
+	// 校验激活码签名（任一已知签名密钥）并解出机器码和到期时间，需要 read-history 权限
+	VerifyLicense(context.Context, *VerifyLicenseRequest) (*VerifyLicenseResponse, error)
+	// 吊销激活码，签发记录保留并推送 license.revoked；已吊销的再次调用直接返回。需要 delete 权限
+	RevokeLicense(context.Context, *RevokeLicenseRequest) (*RevokeLicenseResponse, error)
+	// 删除签发记录，需要 delete 权限
+	DeleteLicense(context.Context, *DeleteLicenseRequest) (*DeleteLicenseResponse, error)
+	// 签发记录，按生成时间倒序分页，需要 read-history 权限
+	ListHistory(context.Context, *ListHistoryRequest) (*ListHistoryResponse, error)
+	// 机器码，按最近生成时间倒序分页，需要 read-history 权限
+	ListMachines(context.Context, *ListMachinesRequest) (*ListMachinesResponse, error)
+	mustEmbedUnimplementedLicenseServiceServer()
+}
+
+// UnimplementedLicenseServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedLicenseServiceServer struct{}
+
+func (UnimplementedLicenseServiceServer) GenerateLicense(context.Context, *GenerateLicenseRequest) (*GenerateLicenseResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GenerateLicense not implemented")
+}
+func (UnimplementedLicenseServiceServer) VerifyLicense(context.Context, *VerifyLicenseRequest) (*VerifyLicenseResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method VerifyLicense not implemented")
+}
+func (UnimplementedLicenseServiceServer) RevokeLicense(context.Context, *RevokeLicenseRequest) (*RevokeLicenseResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RevokeLicense not implemented")
+}
+func (UnimplementedLicenseServiceServer) DeleteLicense(context.Context, *DeleteLicenseRequest) (*DeleteLicenseResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteLicense not implemented")
+}
+func (UnimplementedLicenseServiceServer) ListHistory(context.Context, *ListHistoryRequest) (*ListHistoryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListHistory not implemented")
+}
+func (UnimplementedLicenseServiceServer) ListMachines(context.Context, *ListMachinesRequest) (*ListMachinesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListMachines not implemented")
+}
+func (UnimplementedLicenseServiceServer) mustEmbedUnimplementedLicenseServiceServer() {}
+func (UnimplementedLicenseServiceServer) testEmbeddedByValue()                        {}
+
+// UnsafeLicenseServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to LicenseServiceServer will
+// result in compilation errors.
+type UnsafeLicenseServiceServer interface {
+	mustEmbedUnimplementedLicenseServiceServer()
+}
+
+func RegisterLicenseServiceServer(s grpc.ServiceRegistrar, srv LicenseServiceServer) {
+	// If the following call pancis, it indicates UnimplementedLicenseServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&LicenseService_ServiceDesc, srv)
+}
+
+func _LicenseService_GenerateLicense_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GenerateLicenseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LicenseServiceServer).GenerateLicense(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LicenseService_GenerateLicense_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LicenseServiceServer).GenerateLicense(ctx, req.(*GenerateLicenseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LicenseService_VerifyLicense_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VerifyLicenseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LicenseServiceServer).VerifyLicense(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LicenseService_VerifyLicense_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LicenseServiceServer).VerifyLicense(ctx, req.(*VerifyLicenseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LicenseService_RevokeLicense_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RevokeLicenseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LicenseServiceServer).RevokeLicense(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LicenseService_RevokeLicense_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LicenseServiceServer).RevokeLicense(ctx, req.(*RevokeLicenseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LicenseService_DeleteLicense_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteLicenseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LicenseServiceServer).DeleteLicense(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LicenseService_DeleteLicense_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LicenseServiceServer).DeleteLicense(ctx, req.(*DeleteLicenseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LicenseService_ListHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListHistoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LicenseServiceServer).ListHistory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LicenseService_ListHistory_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LicenseServiceServer).ListHistory(ctx, req.(*ListHistoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LicenseService_ListMachines_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListMachinesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LicenseServiceServer).ListMachines(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LicenseService_ListMachines_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LicenseServiceServer).ListMachines(ctx, req.(*ListMachinesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// LicenseService_ServiceDesc is the grpc.ServiceDesc for LicenseService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var LicenseService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "license.v1.LicenseService",
+	HandlerType: (*LicenseServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GenerateLicense",
+			Handler:    _LicenseService_GenerateLicense_Handler,
+		},
+		{
+			MethodName: "VerifyLicense",
+			Handler:    _LicenseService_VerifyLicense_Handler,
+		},
+		{
+			MethodName: "RevokeLicense",
+			Handler:    _LicenseService_RevokeLicense_Handler,
+		},
+		{
+			MethodName: "DeleteLicense",
+			Handler:    _LicenseService_DeleteLicense_Handler,
+		},
+		{
+			MethodName: "ListHistory",
+			Handler:    _LicenseService_ListHistory_Handler,
+		},
+		{
+			MethodName: "ListMachines",
+			Handler:    _LicenseService_ListMachines_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "licensepb/license.proto",
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// ================= 结构化日志 =================
+// 日志统一走 log/slog，输出到 stderr：
+//   LOG_FORMAT=text（默认，便于本地阅读）或 json（一行一个 JSON 对象，供日志平台解析）
+//   LOG_LEVEL=debug / info（默认）/ warn / error，支持 SIGHUP 热加载
+// 每个 HTTP 请求和 gRPC 调用分配一个请求 ID（客户端传了合法的 X-Request-ID 时沿用），写入响应头 X-Request-ID，
+// 请求内用 slog.*Context(r.Context(), ...) 打的日志自动带上 request_id；请求结束时记一条访问日志。
+// 脱敏：token / secret / password / license_code 等字段只输出 [REDACTED]，任何字符串里出现的 Token 密钥和激活码也会被替换。
+// 仍然用标准库 log 的地方（log.Fatalf 等）经 logBridge 转成 slog 记录，级别按 ❌ / ⚠️ 推断。
+
+var (
+	LogLevel  = newReloadable(getEnv("LOG_LEVEL", "info"))
+	LogFormat = getEnv("LOG_FORMAT", "text")
+	logLevel  = new(slog.LevelVar)
+)
+
+const requestIDHeader = "X-Request-ID"
+
+func parseLogLevel(s string) (slog.Level, bool) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return slog.LevelDebug, true
+	case "info", "":
+		return slog.LevelInfo, true
+	case "warn", "warning":
+		return slog.LevelWarn, true
+	case "error":
+		return slog.LevelError, true
+	}
+	return slog.LevelInfo, false
+}
+
+func setupLogging() {
+	level, _ := parseLogLevel(LogLevel.Get())
+	logLevel.Set(level)
+	opts := &slog.HandlerOptions{Level: logLevel, AddSource: true, ReplaceAttr: replaceAttr}
+	var h slog.Handler
+	if strings.EqualFold(LogFormat, "json") {
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	} else {
+		h = slog.NewTextHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(contextHandler{h}))
+	// SetDefault 会把标准库 log 接到 slog（固定 INFO 级别），这里换成能推断级别和保留调用位置的 logBridge
+	log.SetFlags(log.Lshortfile)
+	log.SetOutput(logBridge{})
+}
+
+// contextHandler 从 ctx 取出请求 ID 加到每条记录上
+type contextHandler struct{ slog.Handler }
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := requestID(ctx); id != "" { r.AddAttrs(slog.String("request_id", id)) }
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler { return contextHandler{h.Handler.WithAttrs(attrs)} }
+
+func (h contextHandler) WithGroup(name string) slog.Handler { return contextHandler{h.Handler.WithGroup(name)} }
+
+// ================= 脱敏 =================
+
+var redactedKeys = map[string]bool{
+	"token": true, "secret": true, "password": true, "passphrase": true, "authorization": true,
+	"license_code": true, "signature": true, "private_key": true, "recovery_code": true, "otp": true,
+}
+
+var (
+	tokenSecretPattern = regexp.MustCompile(`jhm_[A-Za-z0-9_-]{16,}`)
+	licenseCodePattern = regexp.MustCompile(`H4sI[A-Za-z0-9+/]{16,}={0,2}`)
+)
+
+func redactString(s string) string {
+	if !strings.Contains(s, "jhm_") && !strings.Contains(s, "H4sI") { return s }
+	s = tokenSecretPattern.ReplaceAllString(s, "jhm_[REDACTED]")
+	return licenseCodePattern.ReplaceAllString(s, "[REDACTED]")
+}
+
+// replaceAttr 缩短源码位置、时长输出成 1m30s 这样的字符串，并做脱敏
+func replaceAttr(groups []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.SourceKey {
+		// 标准库 log 转过来的记录没有 PC，位置由 logBridge 以字符串形式给出
+		src, ok := a.Value.Any().(*slog.Source)
+		if !ok { return a }
+		if src.File == "" { return slog.Attr{} }
+		return slog.String(slog.SourceKey, filepath.Base(src.File)+":"+strconv.Itoa(src.Line))
+	}
+	if redactedKeys[strings.ToLower(a.Key)] { return slog.String(a.Key, "[REDACTED]") }
+	if a.Value.Kind() == slog.KindDuration { return slog.String(a.Key, a.Value.Duration().String()) }
+	if a.Value.Kind() == slog.KindString { a.Value = slog.StringValue(redactString(a.Value.String())) }
+	return a
+}
+
+// ================= 标准库 log 转接 =================
+
+var logLinePattern = regexp.MustCompile(`^([\w.-]+\.go:\d+): `)
+
+type logBridge struct{}
+
+func (logBridge) Write(p []byte) (int, error) {
+	msg := strings.TrimRight(string(p), "\n")
+	var source string
+	if m := logLinePattern.FindStringSubmatch(msg); m != nil { source, msg = m[1], msg[len(m[0]):] }
+	level := slog.LevelInfo
+	switch {
+	case strings.Contains(msg, "❌"):
+		level = slog.LevelError
+	case strings.Contains(msg, "⚠️"), strings.Contains(msg, "🚨"):
+		level = slog.LevelWarn
+	}
+	h := slog.Default().Handler()
+	if !h.Enabled(context.Background(), level) { return len(p), nil }
+	rec := slog.NewRecord(time.Now(), level, msg, 0)
+	if source != "" { rec.AddAttrs(slog.String(slog.SourceKey, source)) }
+	return len(p), h.Handle(context.Background(), rec)
+}
+
+// ================= 请求 ID 与访问日志 =================
+
+// requestInfo 由 withRequestLog 放进 ctx；鉴权通过后记下调用者，访问日志里输出
+type requestInfo struct {
+	ID        string
+	Principal string
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID 只接受较短的字母数字，防止日志注入
+func validRequestID(s string) bool {
+	if s == "" || len(s) > 64 { return false }
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') { return false }
+	}
+	return true
+}
+
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	ri, _ := ctx.Value(ctxRequestInfo).(*requestInfo)
+	return ri
+}
+
+func requestID(ctx context.Context) string {
+	if ri := requestInfoFrom(ctx); ri != nil { return ri.ID }
+	return ""
+}
+
+// noteRequestPrincipal 鉴权通过后调用，访问日志里带上调用者
+func noteRequestPrincipal(ctx context.Context, p *principal) {
+	if ri := requestInfoFrom(ctx); ri != nil && p != nil { ri.Principal = p.Name }
+}
+
+// withRequestLog 最外层中间件：分配请求 ID、写响应头，请求结束后记访问日志（/health 和 /metrics 记为 DEBUG）
+func withRequestLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) { id = newRequestID() }
+		ri := &requestInfo{ID: id}
+		w.Header().Set(requestIDHeader, id)
+		r = r.WithContext(context.WithValue(r.Context(), ctxRequestInfo, ri))
+
+		sw := &statusWriter{ResponseWriter: w, status: 200}
+		next.ServeHTTP(sw, r)
+
+		level := slog.LevelInfo
+		switch {
+		case r.URL.Path == "/health" || r.URL.Path == "/metrics":
+			level = slog.LevelDebug
+		case sw.status >= 500:
+			level = slog.LevelError
+		case sw.status >= 400:
+			level = slog.LevelWarn
+		}
+		slog.Log(r.Context(), level, "access",
+			"method", r.Method, "path", r.URL.Path, "status", sw.status, "bytes", sw.bytes,
+			"duration_ms", time.Since(start).Milliseconds(), "ip", clientIP(r).String(),
+			"principal", ri.Principal, "user_agent", r.UserAgent())
+	})
+}
+
+// grpcLogInterceptor 与 withRequestLog 相同：请求 ID 取自 metadata x-request-id，写回响应头
+func grpcLogInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("x-request-id"); len(v) > 0 && validRequestID(v[0]) { id = v[0] }
+	}
+	if id == "" { id = newRequestID() }
+	ri := &requestInfo{ID: id}
+	ctx = context.WithValue(ctx, ctxRequestInfo, ri)
+	grpc.SetHeader(ctx, metadata.Pairs("x-request-id", id))
+
+	resp, err := handler(ctx, req)
+
+	code := status.Code(err)
+	level := slog.LevelInfo
+	if err != nil { level = slog.LevelWarn }
+	ip := ""
+	if r := grpcRequest(ctx, info.FullMethod); r.RemoteAddr != "" { ip = clientIP(r).String() }
+	slog.Log(ctx, level, "access", "grpc_method", info.FullMethod, "code", code.String(),
+		"duration_ms", time.Since(start).Milliseconds(), "ip", ip, "principal", ri.Principal)
+	return resp, err
+}
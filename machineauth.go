@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ================= 机器间调用：mTLS 与 HMAC 签名 =================
+// 计费系统等自动调用方可以不在请求体里放 Token，改用下面两种方式之一。认证结果对应到某个 API Token，
+// 沿用它的权限、有效期、IP 白名单和最长授权天数。
+//  1. 客户端证书：设置 TLS_CERT_FILE / TLS_KEY_FILE 后服务直接提供 HTTPS，TLS_CLIENT_CA_FILE 指定签发客户端证书的 CA。
+//     证书的 CN 或任一 SAN（DNS / URI / 邮箱）与 Token 的 client_cert_subjects 匹配即视为该 Token。
+//     TLS_CLIENT_CERT_REQUIRED=1 时没有证书的连接直接拒绝（浏览器也需要证书）。
+//  2. HMAC 签名：适合 TLS 在代理上终止的部署。创建 Token 时勾选 HMAC 得到签名密钥，请求带上
+//       X-Auth-Key:       Token ID
+//       X-Auth-Timestamp: Unix 秒
+//       X-Auth-Nonce:     随机串，至少 16 位
+//       X-Auth-Signature: hex(HMAC-SHA256(签名密钥, 方法 \n 路径?查询 \n 时间戳 \n nonce \n hex(SHA256(请求体))))
+//     时间戳与服务器相差超过 HMAC_MAX_SKEW 的请求拒绝，同一个 nonce 在窗口内只能用一次。
+//     请求头、时间戳和 Key 都有效后才读取请求体：/api/import 最多 32 MB，其余接口最多 1 MB。
+
+var (
+	TLSCertFile           = getEnv("TLS_CERT_FILE", "")
+	TLSKeyFile            = getEnv("TLS_KEY_FILE", "")
+	TLSClientCAFile       = getEnv("TLS_CLIENT_CA_FILE", "")
+	TLSClientCertRequired = isTruthy(getEnv("TLS_CLIENT_CERT_REQUIRED", ""))
+	HMACMaxSkew           = newReloadable(getEnvDuration("HMAC_MAX_SKEW", 5*time.Minute))
+)
+
+const hmacMinNonceLen = 16
+
+// hmacBodyLimit 签名请求的请求体需要整体读入内存计算摘要，上限与各接口自己的限制一致
+func hmacBodyLimit(r *http.Request) int64 {
+	if r.URL.Path == "/api/import" { return maxImportSize }
+	return v1MaxBody
+}
+
+// serverTLSConfig 未配置证书时返回 nil，表示继续使用 HTTP
+func serverTLSConfig() (*tls.Config, error) {
+	if TLSCertFile == "" && TLSKeyFile == "" {
+		if TLSClientCAFile != "" { return nil, fmt.Errorf("设置了 TLS_CLIENT_CA_FILE 但没有设置 TLS_CERT_FILE / TLS_KEY_FILE") }
+		return nil, nil
+	}
+	if TLSCertFile == "" || TLSKeyFile == "" { return nil, fmt.Errorf("TLS_CERT_FILE 和 TLS_KEY_FILE 需要同时设置") }
+	if _, err := tls.LoadX509KeyPair(TLSCertFile, TLSKeyFile); err != nil { return nil, fmt.Errorf("读取 TLS 证书失败: %v", err) }
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if TLSClientCAFile != "" {
+		pem, err := os.ReadFile(TLSClientCAFile)
+		if err != nil { return nil, fmt.Errorf("读取 TLS_CLIENT_CA_FILE 失败: %v", err) }
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) { return nil, fmt.Errorf("TLS_CLIENT_CA_FILE 中没有可用的证书") }
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+		if TLSClientCertRequired { cfg.ClientAuth = tls.RequireAndVerifyClientCert }
+	} else if TLSClientCertRequired {
+		return nil, fmt.Errorf("TLS_CLIENT_CERT_REQUIRED 需要同时设置 TLS_CLIENT_CA_FILE")
+	}
+	return cfg, nil
+}
+
+// certIdentities 证书里可用于匹配 Token 的名称
+func certIdentities(c *x509.Certificate) []string {
+	var ids []string
+	if c.Subject.CommonName != "" { ids = append(ids, c.Subject.CommonName) }
+	ids = append(ids, c.DNSNames...)
+	ids = append(ids, c.EmailAddresses...)
+	for _, u := range c.URIs { ids = append(ids, u.String()) }
+	return ids
+}
+
+// certToken 已通过 CA 校验的客户端证书对应的 Token；证书没有对应任何 Token 时返回 nil（例如管理员浏览器的证书）
+func certToken(r *http.Request) (*APIToken, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 { return nil, nil }
+	ids := certIdentities(r.TLS.VerifiedChains[0][0])
+	now := time.Now()
+	tokenMutex.Lock(); defer tokenMutex.Unlock()
+	for i := range tokenList {
+		t := &tokenList[i]
+		if t.RevokedAt != "" { continue }
+		for _, subject := range t.ClientCertSubjects {
+			if !containsString(ids, subject) { continue }
+			if err := t.usableFrom(r, now); err != nil { return nil, err }
+			t.touch(now)
+			found := *t
+			return &found, nil
+		}
+	}
+	return nil, nil
+}
+
+// ================= HMAC 签名 =================
+
+var (
+	hmacNonces     = map[string]time.Time{} // Token ID + nonce -> 可以忘掉的时间
+	hmacNonceMutex sync.Mutex
+)
+
+func hmacStringToSign(method, uri, timestamp, nonce string, body []byte) string {
+	sum := sha256.Sum256(body)
+	return method + "\n" + uri + "\n" + timestamp + "\n" + nonce + "\n" + hex.EncodeToString(sum[:])
+}
+
+func hmacSign(key, stringToSign string) string {
+	m := hmac.New(sha256.New, []byte(key))
+	m.Write([]byte(stringToSign))
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// useNonce 记录 nonce，窗口内重复出现时返回 false
+func useNonce(keyID, nonce string, now time.Time) bool {
+	k := keyID + "\n" + nonce
+	hmacNonceMutex.Lock(); defer hmacNonceMutex.Unlock()
+	if exp, ok := hmacNonces[k]; ok && now.Before(exp) { return false }
+	// 时间戳允许前后各偏差 HMACMaxSkew，nonce 至少要记住这么久
+	hmacNonces[k] = now.Add(2 * HMACMaxSkew.Get())
+	return true
+}
+
+// hmacKey 取出 Token 的签名密钥；Key 不存在或没有开启 HMAC 时与签名错误返回相同的信息
+func hmacKey(keyID string) (string, error) {
+	tokenMutex.Lock(); defer tokenMutex.Unlock()
+	for _, t := range tokenList {
+		if t.ID != keyID || t.HMACSecret == "" { continue }
+		key, err := decryptField("hmac_secret", t.HMACSecret)
+		if err != nil { return "", &authError{500, "读取签名密钥失败"} }
+		return key, nil
+	}
+	return "", &authError{401, "签名错误"}
+}
+
+// hmacToken 校验签名请求头；没有签名头时返回 nil。读取过的请求体会放回 r.Body 供后续 handler 使用。
+func hmacToken(w http.ResponseWriter, r *http.Request) (*APIToken, error) {
+	sig := r.Header.Get("X-Auth-Signature")
+	if sig == "" { return nil, nil }
+	keyID, ts, nonce := r.Header.Get("X-Auth-Key"), r.Header.Get("X-Auth-Timestamp"), r.Header.Get("X-Auth-Nonce")
+	if keyID == "" || ts == "" || len(nonce) < hmacMinNonceLen { return nil, &authError{401, "签名请求头不完整"} }
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil { return nil, &authError{401, "X-Auth-Timestamp 格式错误"} }
+	now := time.Now()
+	if d := now.Sub(time.Unix(sec, 0)); d > HMACMaxSkew.Get() || d < -HMACMaxSkew.Get() { return nil, &authError{401, "签名已过期或服务器时间不一致"} }
+	key, err := hmacKey(keyID)
+	if err != nil { return nil, err }
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, hmacBodyLimit(r)))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) { return nil, &authError{413, "请求体过大"} }
+	if err != nil { return nil, &authError{400, "读取请求体失败"} }
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	want := hmacSign(key, hmacStringToSign(r.Method, r.URL.RequestURI(), ts, nonce, body))
+	if !hmac.Equal([]byte(want), []byte(sig)) { return nil, &authError{401, "签名错误"} }
+	if !useNonce(keyID, nonce, now) { return nil, &authError{401, "nonce 已使用过（重放请求）"} }
+
+	tokenMutex.Lock(); defer tokenMutex.Unlock()
+	for i := range tokenList {
+		t := &tokenList[i]
+		if t.ID != keyID { continue }
+		if err := t.usableFrom(r, now); err != nil { return nil, err }
+		t.touch(now)
+		found := *t
+		return &found, nil
+	}
+	return nil, &authError{401, "签名错误"}
+}
+
+func startNonceSweeper() {
+	go func() {
+		for range time.Tick(time.Minute) {
+			now := time.Now()
+			hmacNonceMutex.Lock()
+			for k, exp := range hmacNonces {
+				if now.After(exp) { delete(hmacNonces, k) }
+			}
+			hmacNonceMutex.Unlock()
+		}
+	}()
+}
+
+// listen 配置了证书时提供 HTTPS（可选客户端证书），否则 HTTP
+func listen(srv *http.Server) error {
+	cfg, err := serverTLSConfig()
+	if err != nil { return err }
+	srv.TLSConfig = cfg
+	if cfg == nil { return srv.ListenAndServe() }
+	mode := "不校验客户端证书"
+	if cfg.ClientCAs != nil { mode = "客户端证书可选" }
+	if TLSClientCertRequired { mode = "必须提供客户端证书" }
+	slog.Info("🔒 已启用 HTTPS", "client_cert", mode)
+	return srv.ListenAndServeTLS(TLSCertFile, TLSKeyFile)
+}
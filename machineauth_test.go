@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingBody 被读取时让测试失败：签名头无效的请求不应读取请求体
+type failingBody struct{ t *testing.T }
+
+func (b failingBody) Read([]byte) (int, error) { b.t.Error("请求体被读取了"); return 0, errors.New("read") }
+func (b failingBody) Close() error             { return nil }
+
+func withHMACToken(t *testing.T) {
+	t.Helper()
+	saved := tokenList
+	tokenList = []APIToken{{ID: "tok1", Name: "billing", Scopes: []string{scopeGenerate}, HMACSecret: "k3y", LastUsedAt: time.Now().UTC().Format(time.RFC3339)}}
+	t.Cleanup(func() { tokenList = saved })
+}
+
+func signedRequest(path, keyID, nonce, body string, at time.Time) *http.Request {
+	r := httptest.NewRequest("POST", path, strings.NewReader(body))
+	ts := strconv.FormatInt(at.Unix(), 10)
+	r.Header.Set("X-Auth-Key", keyID)
+	r.Header.Set("X-Auth-Timestamp", ts)
+	r.Header.Set("X-Auth-Nonce", nonce)
+	r.Header.Set("X-Auth-Signature", hmacSign("k3y", hmacStringToSign("POST", r.URL.RequestURI(), ts, nonce, []byte(body))))
+	return r
+}
+
+func authStatus(err error) int {
+	var ae *authError
+	if errors.As(err, &ae) { return ae.Status }
+	return 0
+}
+
+func TestHMACTokenValid(t *testing.T) {
+	withHMACToken(t)
+	r := signedRequest("/api/v1/licenses", "tok1", "nonce-valid-000001", `{"machine_id":"M"}`, time.Now())
+	tok, err := hmacToken(httptest.NewRecorder(), r)
+	if err != nil || tok == nil || tok.ID != "tok1" { t.Fatalf("期望通过，实际 %v %v", tok, err) }
+	r = signedRequest("/api/v1/licenses", "tok1", "nonce-valid-000001", `{"machine_id":"M"}`, time.Now())
+	if _, err := hmacToken(httptest.NewRecorder(), r); authStatus(err) != 401 { t.Fatalf("重放请求应被拒绝，实际 %v", err) }
+}
+
+func TestHMACTokenChecksHeadersBeforeBody(t *testing.T) {
+	withHMACToken(t)
+	cases := map[string]*http.Request{
+		"unknown key": signedRequest("/api/v1/licenses", "nope", "nonce-unknown-0001", "", time.Now()),
+		"stale":       signedRequest("/api/v1/licenses", "tok1", "nonce-stale-000001", "", time.Now().Add(-time.Hour)),
+		"short nonce": signedRequest("/api/v1/licenses", "tok1", "short", "", time.Now()),
+	}
+	for name, r := range cases {
+		r.Body = failingBody{t}
+		if _, err := hmacToken(httptest.NewRecorder(), r); authStatus(err) != 401 { t.Errorf("%s: 期望 401，实际 %v", name, err) }
+	}
+}
+
+func TestHMACTokenBodyLimit(t *testing.T) {
+	withHMACToken(t)
+	body := strings.Repeat("x", v1MaxBody+1)
+	r := signedRequest("/api/v1/licenses", "tok1", "nonce-large-000001", body, time.Now())
+	if _, err := hmacToken(httptest.NewRecorder(), r); authStatus(err) != 413 { t.Fatalf("期望 413，实际 %v", err) }
+	r = signedRequest("/api/import", "tok1", "nonce-import-00001", body, time.Now())
+	if _, err := hmacToken(httptest.NewRecorder(), r); err != nil { t.Fatalf("导入接口允许更大的请求体: %v", err) }
+}
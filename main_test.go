@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pageRequest 用管理员 Token 登录的会话请求页面
+func pageRequest(t *testing.T, path string) *http.Request {
+	t.Helper()
+	saved := tokenList
+	tok := APIToken{ID: "page-test", Name: "page", Scopes: []string{scopeAdmin}, LastUsedAt: time.Now().UTC().Format(time.RFC3339)}
+	tokenList = []APIToken{tok}
+	s := newSession(tokenPrincipal(&tok))
+	t.Cleanup(func() { tokenList = saved; destroySession(s.ID) })
+	r := httptest.NewRequest("GET", path, nil)
+	r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: s.ID})
+	return r
+}
+
+const hostileID = `M'); alert(1);//</td><script>alert(2)</script>`
+
+func assertEscaped(t *testing.T, body string) {
+	t.Helper()
+	for _, bad := range []string{"<script>alert(2)", "'); alert(1)"} {
+		if strings.Contains(body, bad) { t.Errorf("页面中出现未转义的 %q", bad) }
+	}
+}
+
+func TestMachinesPageEscapes(t *testing.T) {
+	saved := machineList
+	machineList = []MachineRecord{{MachineID: hostileID, LastSeen: "2026-01-01T00:00:00Z"}}
+	t.Cleanup(func() { machineList = saved })
+	w := httptest.NewRecorder()
+	handleMachines(w, pageRequest(t, "/machines"))
+	if w.Code != 200 { t.Fatalf("状态 %d", w.Code) }
+	assertEscaped(t, w.Body.String())
+	if !strings.Contains(w.Body.String(), `copyText('M\&#39;); alert(1);//\u003C/td\u003E`) { t.Error("onclick 里的机器码应先按 JS 转义再按 HTML 转义") }
+}
+
+func TestHistoryPageEscapes(t *testing.T) {
+	withStore(t, []HistoryRecord{{ID: "h1", MachineID: hostileID, ExpiryDate: "2999-01-01", LicenseCode: hostileID, GenerateTime: "2026-01-01T00:00:00Z"}}, nil)
+	w := httptest.NewRecorder()
+	handleHistory(w, pageRequest(t, "/history"))
+	if w.Code != 200 { t.Fatalf("状态 %d", w.Code) }
+	assertEscaped(t, w.Body.String())
+}
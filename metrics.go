@@ -0,0 +1,204 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// ================= Prometheus 指标 =================
+// GET /metrics 输出 Prometheus 文本格式。设置 METRICS_TOKEN 后需要带 Authorization: Bearer <METRICS_TOKEN>，
+// 失败计入鉴权锁定；不设置时任何人可读（只含计数，不含机器码和激活码）。
+// /metrics 不走 API Token 鉴权和按 IP 限流，抓取请求本身也不计入请求耗时。
+// 激活码不区分产品，因此生成计数没有 product 标签；token 标签是 Token 名称或用户名。
+
+var MetricsToken = getEnv("METRICS_TOKEN", "")
+
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	metricLicensesGenerated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "license_generated_total",
+		Help: "生成的激活码数量，按 Token 和有效期区间",
+	}, []string{"token", "duration"})
+	metricGenerateErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "license_generate_errors_total",
+		Help: "生成激活码失败次数，按原因",
+	}, []string{"reason"})
+	metricAuthFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "license_auth_failures_total",
+		Help: "鉴权失败次数，按方式",
+	}, []string{"method"})
+	metricNotifications = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "license_notifications_total",
+		Help: "通知投递次数，按渠道和结果",
+	}, []string{"channel", "result"})
+	metricHTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "license_http_request_duration_seconds",
+		Help:    "HTTP 请求耗时，按路由、方法和状态码",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+	metricGRPCDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "license_grpc_request_duration_seconds",
+		Help:    "gRPC 调用耗时，按方法和状态码",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "code"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		metricLicensesGenerated, metricGenerateErrors, metricAuthFailures, metricNotifications,
+		metricHTTPDuration, metricGRPCDuration,
+		storeCollector{},
+	)
+}
+
+// durationBucket 激活码有效天数所在区间，区间上限固定，避免标签随日期变化
+func durationBucket(expiry string) string {
+	t, err := time.ParseInLocation("2006-01-02", expiry, bizLocation())
+	if err != nil { return "unknown" }
+	days := int(t.Sub(time.Now().In(bizLocation())).Hours()/24) + 1
+	for _, limit := range []int{1, 7, 31, 92, 366} {
+		if days <= limit { return "<=" + strconv.Itoa(limit) + "d" }
+	}
+	return ">366d"
+}
+
+// authFailureMethods recordAuthFailure 的原因前缀 → 指标标签
+var authFailureMethods = map[string]string{
+	"Bearer": "bearer", "mTLS": "mtls", "HMAC": "hmac", "Token": "token",
+	"gRPC Bearer": "grpc_bearer", "gRPC mTLS": "grpc_mtls", "SSO": "sso", "Metrics": "metrics",
+	"登录": "password", "二次验证": "totp", "两步验证": "totp",
+}
+
+func recordAuthFailureMetric(reason string) {
+	prefix, _, _ := strings.Cut(reason, ": ")
+	method, ok := authFailureMethods[prefix]
+	if !ok { method = "other" }
+	metricAuthFailures.WithLabelValues(method).Inc()
+}
+
+func recordNotification(channel string, ok bool) {
+	result := "success"
+	if !ok { result = "failure" }
+	metricNotifications.WithLabelValues(channel, result).Inc()
+}
+
+// storeCollector 抓取时现算各数据文件的记录数和文件大小
+type storeCollector struct{}
+
+var (
+	storeRecordsDesc = prometheus.NewDesc("license_store_records", "数据文件中的记录数", []string{"store"}, nil)
+	storeBytesDesc   = prometheus.NewDesc("license_store_file_bytes", "数据文件大小（字节）", []string{"store"}, nil)
+	webhookQueueDesc = prometheus.NewDesc("license_webhook_queue_length", "等待投递或重试的 Webhook 数量", nil, nil)
+)
+
+func (storeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- storeRecordsDesc
+	ch <- storeBytesDesc
+	ch <- webhookQueueDesc
+}
+
+func (storeCollector) Collect(ch chan<- prometheus.Metric) {
+	count := func(store string, n int) { ch <- prometheus.MustNewConstMetric(storeRecordsDesc, prometheus.GaugeValue, float64(n), store) }
+
+	mutex.Lock()
+	history, machines := len(historyList), len(machineList)
+	mutex.Unlock()
+	count("history", history)
+	count("machines", machines)
+	tokenMutex.Lock(); count("tokens", len(tokenList)); tokenMutex.Unlock()
+	userMutex.Lock(); count("users", len(userList)); userMutex.Unlock()
+	customerMutex.Lock(); count("customers", len(customerList)); customerMutex.Unlock()
+
+	webhookMutex.Lock()
+	hooks, deliveries, queued := len(webhookList), len(deliveryList), 0
+	for _, d := range deliveryList {
+		if d.NextAttemptAt != "" { queued++ }
+	}
+	webhookMutex.Unlock()
+	count("webhooks", hooks)
+	count("webhook_deliveries", deliveries)
+	ch <- prometheus.MustNewConstMetric(webhookQueueDesc, prometheus.GaugeValue, float64(queued))
+
+	files := map[string]string{
+		"history": historyFile, "machines": machineFile, "tokens": tokensFile, "users": usersFile,
+		"customers": customersFile, "webhooks": webhooksFile, "webhook_deliveries": webhookDeliveryFile,
+	}
+	for store, path := range files {
+		if fi, err := os.Stat(path); err == nil { ch <- prometheus.MustNewConstMetric(storeBytesDesc, prometheus.GaugeValue, float64(fi.Size()), store) }
+	}
+}
+
+// ================= HTTP =================
+
+var metricsHandler = promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+
+// instrument 提供 /metrics，并记录其余请求的耗时；放在限流和鉴权之外，被拒绝的请求也计入
+func instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/metrics" { handleMetrics(w, r); return }
+		start := time.Now()
+		sw, ok := w.(*statusWriter)
+		if !ok { sw = &statusWriter{ResponseWriter: w, status: 200} }
+		next.ServeHTTP(sw, r)
+		metricHTTPDuration.WithLabelValues(metricsRoute(r), metricsMethod(r.Method), strconv.Itoa(sw.status)).Observe(time.Since(start).Seconds())
+	})
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if MetricsToken != "" {
+		if !checkLockout(w, r, "") { return }
+		got := bearerToken(r)
+		if subtle.ConstantTimeCompare([]byte(got), []byte(MetricsToken)) != 1 {
+			recordAuthFailure(r, "", "Metrics: Token 无效")
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+authRealm+`"`)
+			http.Error(w, "Unauthorized", 401)
+			return
+		}
+		recordAuthSuccess(r, "")
+	}
+	metricsHandler.ServeHTTP(w, r)
+}
+
+// metricsRoute 用注册时的路由模式作标签（如 /api/v1/），避免路径里的 ID 撑爆标签数量
+func metricsRoute(r *http.Request) string {
+	_, pattern := http.DefaultServeMux.Handler(r)
+	if pattern == "" { return "other" }
+	return pattern
+}
+
+func metricsMethod(m string) string {
+	switch m {
+	case "GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS":
+		return m
+	}
+	return "OTHER"
+}
+
+// statusWriter 记下状态码和响应字节数，供指标和访问日志使用
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ================= OIDC 单点登录 =================
+// 设置 OIDC_ISSUER 和 OIDC_CLIENT_ID 后登录页出现「使用 SSO 登录」，走授权码 + PKCE (S256) 流程。
+// 首次登录自动创建用户，之后按 iss + sub 识别；每次登录都按 IdP 返回的组重新计算角色（OIDC_ROLE_MAP），
+// 没有匹配的组且没有设置 OIDC_DEFAULT_ROLE 时拒绝登录。在 /users 里禁用的用户同样无法通过 SSO 登录。
+// 本地调试可以用 ./server oidc-mock 启动一个模拟 IdP，见 oidc_mock.go。
+
+var (
+	OIDCIssuer        = strings.TrimRight(getEnv("OIDC_ISSUER", ""), "/")
+	OIDCClientID      = getEnv("OIDC_CLIENT_ID", "")
+	OIDCClientSecret  = getEnv("OIDC_CLIENT_SECRET", "") // 留空表示公共客户端，只靠 PKCE
+	OIDCRedirectURL   = getEnv("OIDC_REDIRECT_URL", "")  // 留空时按请求的 Host 拼出 /login/oidc/callback
+	OIDCScopes        = getEnv("OIDC_SCOPES", "openid profile email groups")
+	OIDCUsernameClaim = getEnv("OIDC_USERNAME_CLAIM", "preferred_username")
+	OIDCGroupsClaim   = getEnv("OIDC_GROUPS_CLAIM", "groups")
+	OIDCRoleMapRaw    = getEnv("OIDC_ROLE_MAP", "")     // 例如 "license-admins=admin,license-ops=operator,partners=reseller"
+	OIDCDefaultRole   = getEnv("OIDC_DEFAULT_ROLE", "") // 没有匹配的组时使用的角色，留空表示拒绝
+	OIDCJWKSCacheTTL  = getEnvDuration("OIDC_JWKS_CACHE_TTL", time.Hour)
+)
+
+const (
+	oidcStateCookie   = "jhm_oidc_state"
+	oidcLoginTTL      = 10 * time.Minute
+	oidcClockSkew     = time.Minute
+	oidcMinKeyRefresh = time.Minute // 遇到未知 kid 时最多每分钟重新拉取一次 JWKS
+)
+
+// 多个组映射到不同角色时取权限最大的
+var oidcRolePriority = []string{roleAdmin, roleOperator, roleReseller, roleViewer}
+
+var oidcRoleMap map[string]string
+
+func oidcEnabled() bool { return OIDCIssuer != "" }
+
+// checkOIDCConfig 启动时校验配置，返回错误时服务不应启动
+func checkOIDCConfig() error {
+	if !oidcEnabled() { return nil }
+	if OIDCClientID == "" { return fmt.Errorf("已设置 OIDC_ISSUER 但缺少 OIDC_CLIENT_ID") }
+	if OIDCDefaultRole != "" && !containsString(allRoles, OIDCDefaultRole) { return fmt.Errorf("OIDC_DEFAULT_ROLE 未知角色: %s", OIDCDefaultRole) }
+	oidcRoleMap = map[string]string{}
+	for _, item := range strings.Split(OIDCRoleMapRaw, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" { continue }
+		i := strings.LastIndex(item, "=")
+		if i <= 0 { return fmt.Errorf("OIDC_ROLE_MAP 格式错误: %q (应为 组=角色)", item) }
+		group, role := strings.TrimSpace(item[:i]), strings.TrimSpace(item[i+1:])
+		if !containsString(allRoles, role) { return fmt.Errorf("OIDC_ROLE_MAP 未知角色: %s", role) }
+		oidcRoleMap[group] = role
+	}
+	if len(oidcRoleMap) == 0 && OIDCDefaultRole == "" { slog.Warn("⚠️ OIDC_ROLE_MAP 和 OIDC_DEFAULT_ROLE 都未设置，所有 SSO 登录都会被拒绝") }
+	slog.Info("✅ OIDC 单点登录已启用", "issuer", OIDCIssuer)
+	return nil
+}
+
+// oidcRole 按组映射出角色，没有匹配时返回 OIDC_DEFAULT_ROLE
+func oidcRole(groups []string) string {
+	matched := map[string]bool{}
+	for _, g := range groups {
+		if role, ok := oidcRoleMap[g]; ok { matched[role] = true }
+	}
+	for _, role := range oidcRolePriority {
+		if matched[role] { return role }
+	}
+	return OIDCDefaultRole
+}
+
+// ================= IdP 元数据与 JWKS 缓存 =================
+
+type oidcProviderMeta struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+var (
+	oidcMutex     sync.Mutex
+	oidcMeta      *oidcProviderMeta
+	oidcMetaAt    time.Time
+	oidcKeys      map[string]crypto.PublicKey
+	oidcKeysAt    time.Time
+	oidcKeysTried time.Time
+	oidcClient    = &http.Client{Timeout: 10 * time.Second}
+)
+
+func oidcGetJSON(u string, out interface{}) error {
+	resp, err := oidcClient.Get(u)
+	if err != nil { return err }
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 { return fmt.Errorf("%s 返回 %d", u, resp.StatusCode) }
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
+
+// providerMeta 读取 /.well-known/openid-configuration，和 JWKS 使用相同的缓存时间
+func providerMeta() (*oidcProviderMeta, error) {
+	oidcMutex.Lock(); defer oidcMutex.Unlock()
+	if oidcMeta != nil && time.Since(oidcMetaAt) < OIDCJWKSCacheTTL { return oidcMeta, nil }
+	var m oidcProviderMeta
+	if err := oidcGetJSON(OIDCIssuer+"/.well-known/openid-configuration", &m); err != nil {
+		if oidcMeta != nil { slog.Warn("⚠️ 刷新 OIDC 元数据失败，继续使用缓存", "err", err); return oidcMeta, nil }
+		return nil, fmt.Errorf("读取 OIDC 元数据失败: %v", err)
+	}
+	if strings.TrimRight(m.Issuer, "/") != OIDCIssuer { return nil, fmt.Errorf("OIDC 元数据中的 issuer (%s) 与配置不一致", m.Issuer) }
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" { return nil, fmt.Errorf("OIDC 元数据不完整") }
+	oidcMeta, oidcMetaAt = &m, time.Now()
+	return oidcMeta, nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func b64Int(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 { return nil, fmt.Errorf("JWK 字段格式错误") }
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := b64Int(k.N)
+		if err != nil { return nil, err }
+		e, err := b64Int(k.E)
+		if err != nil || !e.IsInt64() { return nil, fmt.Errorf("JWK 字段格式错误") }
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("不支持的曲线: %s", k.Crv)
+		}
+		x, err := b64Int(k.X)
+		if err != nil { return nil, err }
+		y, err := b64Int(k.Y)
+		if err != nil { return nil, err }
+		if !curve.IsOnCurve(x, y) { return nil, fmt.Errorf("JWK 坐标不在曲线上") }
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("不支持的密钥类型: %s", k.Kty)
+}
+
+// signingKey 按 kid 查找 IdP 公钥；缓存过期或遇到未知 kid（IdP 轮换了密钥）时重新拉取
+func signingKey(kid string) (crypto.PublicKey, error) {
+	meta, err := providerMeta()
+	if err != nil { return nil, err }
+	oidcMutex.Lock(); defer oidcMutex.Unlock()
+	key, ok := oidcKeys[kid]
+	fresh := time.Since(oidcKeysAt) < OIDCJWKSCacheTTL
+	if ok && fresh { return key, nil }
+	if fresh && time.Since(oidcKeysTried) < oidcMinKeyRefresh { return nil, fmt.Errorf("未知的签名密钥: %s", kid) }
+
+	oidcKeysTried = time.Now()
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := oidcGetJSON(meta.JWKSURI, &set); err != nil {
+		if ok { slog.Warn("⚠️ 刷新 JWKS 失败，继续使用缓存", "err", err); return key, nil }
+		return nil, fmt.Errorf("读取 JWKS 失败: %v", err)
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" { continue }
+		pub, err := k.publicKey()
+		if err != nil { slog.Warn("⚠️ 跳过 JWKS 中的密钥", "kid", k.Kid, "err", err); continue }
+		keys[k.Kid] = pub
+	}
+	oidcKeys, oidcKeysAt = keys, time.Now()
+	if key, ok = oidcKeys[kid]; !ok { return nil, fmt.Errorf("未知的签名密钥: %s", kid) }
+	return key, nil
+}
+
+// ================= ID Token 校验 =================
+
+// audience 兼容字符串和字符串数组两种写法
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var one string
+	if json.Unmarshal(b, &one) == nil { *a = audience{one}; return nil }
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil { return err }
+	*a = many
+	return nil
+}
+
+type idTokenClaims struct {
+	Issuer   string   `json:"iss"`
+	Subject  string   `json:"sub"`
+	Audience audience `json:"aud"`
+	AZP      string   `json:"azp"`
+	Expiry   int64    `json:"exp"`
+	IssuedAt int64    `json:"iat"`
+	Nonce    string   `json:"nonce"`
+
+	Extra map[string]interface{} `json:"-"` // 用户名、组等其余声明
+}
+
+func verifyIDToken(raw, nonce string) (*idTokenClaims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 { return nil, fmt.Errorf("ID Token 格式错误") }
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	hb, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(hb, &header) != nil { return nil, fmt.Errorf("ID Token 头部格式错误") }
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil { return nil, fmt.Errorf("ID Token 签名格式错误") }
+
+	key, err := signingKey(header.Kid)
+	if err != nil { return nil, err }
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" { return nil, fmt.Errorf("不支持的签名算法: %s", header.Alg) }
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) != nil { return nil, fmt.Errorf("ID Token 签名无效") }
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" || pub.Curve != elliptic.P256() || len(sig) != 64 { return nil, fmt.Errorf("不支持的签名算法: %s", header.Alg) }
+		if !ecdsa.Verify(pub, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) { return nil, fmt.Errorf("ID Token 签名无效") }
+	default:
+		return nil, fmt.Errorf("不支持的签名算法: %s", header.Alg)
+	}
+
+	pb, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil { return nil, fmt.Errorf("ID Token 内容格式错误") }
+	var c idTokenClaims
+	if err := json.Unmarshal(pb, &c); err != nil { return nil, fmt.Errorf("ID Token 内容格式错误: %v", err) }
+	if err := json.Unmarshal(pb, &c.Extra); err != nil { return nil, fmt.Errorf("ID Token 内容格式错误: %v", err) }
+
+	now := time.Now()
+	if strings.TrimRight(c.Issuer, "/") != OIDCIssuer { return nil, fmt.Errorf("ID Token issuer 不匹配: %s", c.Issuer) }
+	if !containsString(c.Audience, OIDCClientID) { return nil, fmt.Errorf("ID Token 不是签发给本应用的") }
+	if len(c.Audience) > 1 && c.AZP != OIDCClientID { return nil, fmt.Errorf("ID Token azp 不匹配") }
+	if c.Expiry == 0 || now.After(time.Unix(c.Expiry, 0).Add(oidcClockSkew)) { return nil, fmt.Errorf("ID Token 已过期") }
+	if c.IssuedAt != 0 && time.Unix(c.IssuedAt, 0).After(now.Add(oidcClockSkew)) { return nil, fmt.Errorf("ID Token 签发时间在未来") }
+	if subtle.ConstantTimeCompare([]byte(c.Nonce), []byte(nonce)) != 1 { return nil, fmt.Errorf("ID Token nonce 不匹配") }
+	if c.Subject == "" { return nil, fmt.Errorf("ID Token 缺少 sub") }
+	return &c, nil
+}
+
+func (c *idTokenClaims) stringClaim(name string) string {
+	s, _ := c.Extra[name].(string)
+	return s
+}
+
+// groups 兼容数组和单个字符串
+func (c *idTokenClaims) groups() []string {
+	switch v := c.Extra[OIDCGroupsClaim].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var out []string
+		for _, g := range v {
+			if s, ok := g.(string); ok { out = append(out, s) }
+		}
+		return out
+	}
+	return nil
+}
+
+func (c *idTokenClaims) username() string {
+	for _, name := range []string{OIDCUsernameClaim, "email"} {
+		if s := strings.TrimSpace(c.stringClaim(name)); s != "" { return s }
+	}
+	return c.Subject
+}
+
+// ================= 授权码 + PKCE =================
+
+type oidcLogin struct {
+	Verifier  string
+	Nonce     string
+	Next      string
+	CreatedAt time.Time
+}
+
+var (
+	oidcLogins     = map[string]*oidcLogin{} // state -> 登录中的请求
+	oidcLoginMutex sync.Mutex
+)
+
+func takeOIDCLogin(state string) *oidcLogin {
+	oidcLoginMutex.Lock(); defer oidcLoginMutex.Unlock()
+	l, ok := oidcLogins[state]
+	if !ok { return nil }
+	delete(oidcLogins, state)
+	if time.Since(l.CreatedAt) > oidcLoginTTL { return nil }
+	return l
+}
+
+func oidcRedirectURL(r *http.Request) string {
+	if OIDCRedirectURL != "" { return OIDCRedirectURL }
+	scheme := "http"
+	if r.TLS != nil || TrustProxyHeaders && r.Header.Get("X-Forwarded-Proto") == "https" { scheme = "https" }
+	return scheme + "://" + r.Host + "/login/oidc/callback"
+}
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// GET /login/oidc 跳转到 IdP
+func handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if !oidcEnabled() { http.NotFound(w, r); return }
+	next := safeNext(r.FormValue("next"))
+	meta, err := providerMeta()
+	if err != nil { slog.ErrorContext(r.Context(), "❌ SSO 不可用", "err", err); renderLoginPage(w, next, "SSO 暂时不可用", 502); return }
+
+	state := randomString(32)
+	l := &oidcLogin{Verifier: randomString(48), Nonce: randomString(32), Next: next, CreatedAt: time.Now()}
+	oidcLoginMutex.Lock()
+	for k, old := range oidcLogins {
+		if time.Since(old.CreatedAt) > oidcLoginTTL { delete(oidcLogins, k) }
+	}
+	oidcLogins[state] = l
+	oidcLoginMutex.Unlock()
+
+	// state 同时写进 Cookie，回调时核对，防止别人把自己的授权码塞给当前浏览器（登录 CSRF）
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Value: state, Path: "/login/oidc", HttpOnly: true, Secure: cookieSecure(r), SameSite: http.SameSiteLaxMode, MaxAge: int(oidcLoginTTL.Seconds())})
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {OIDCClientID},
+		"redirect_uri":          {oidcRedirectURL(r)},
+		"scope":                 {OIDCScopes},
+		"state":                 {state},
+		"nonce":                 {l.Nonce},
+		"code_challenge":        {pkceChallenge(l.Verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") { sep = "&" }
+	http.Redirect(w, r, meta.AuthorizationEndpoint+sep+q.Encode(), http.StatusFound)
+}
+
+// exchangeOIDCCode 用授权码和 code_verifier 换取并校验 ID Token
+func exchangeOIDCCode(r *http.Request, code string, l *oidcLogin) (*idTokenClaims, error) {
+	if code == "" { return nil, fmt.Errorf("缺少授权码") }
+	meta, err := providerMeta()
+	if err != nil { return nil, err }
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {oidcRedirectURL(r)},
+		"client_id":     {OIDCClientID},
+		"code_verifier": {l.Verifier},
+	}
+	req, err := http.NewRequest("POST", meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil { return nil, err }
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if OIDCClientSecret != "" { req.SetBasicAuth(url.QueryEscape(OIDCClientID), url.QueryEscape(OIDCClientSecret)) }
+	resp, err := oidcClient.Do(req)
+	if err != nil { return nil, fmt.Errorf("换取 Token 失败: %v", err) }
+	defer resp.Body.Close()
+	var tr struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tr); err != nil { return nil, fmt.Errorf("换取 Token 失败: HTTP %d", resp.StatusCode) }
+	if resp.StatusCode != 200 || tr.Error != "" { return nil, fmt.Errorf("换取 Token 失败: %s %s", tr.Error, tr.ErrorDescription) }
+	if tr.IDToken == "" { return nil, fmt.Errorf("IdP 没有返回 id_token") }
+	return verifyIDToken(tr.IDToken, l.Nonce)
+}
+
+// oidcUser 按 iss + sub 找到或创建用户，并按组同步角色
+func oidcUser(c *idTokenClaims) (*User, error) {
+	groups := c.groups()
+	role := oidcRole(groups)
+	username := c.username()
+	if role == "" { return nil, &authError{403, fmt.Sprintf("「%s」没有被分配角色 (组: %s)", username, strings.Join(groups, ", "))} }
+
+	userMutex.Lock(); defer userMutex.Unlock()
+	for i := range userList {
+		u := &userList[i]
+		if u.OIDCIssuer != OIDCIssuer || u.OIDCSubject != c.Subject { continue }
+		if u.DisabledAt != "" { return nil, &authError{403, "账号已禁用"} }
+		if u.Role != role { slog.Info("👥 SSO 用户的角色按 IdP 组同步", "username", u.Username, "from", u.Role, "to", role); u.Role = role }
+		u.LastLoginAt = nowTimestamp()
+		persistUsers()
+		found := *u
+		return &found, nil
+	}
+	for _, u := range userList {
+		if u.Username == username { return nil, &authError{403, fmt.Sprintf("用户名「%s」已被其他账号使用", username)} }
+	}
+	now := nowTimestamp()
+	u := User{ID: newRecordID(), Username: username, Role: role, CreatedAt: now, LastLoginAt: now, OIDCIssuer: OIDCIssuer, OIDCSubject: c.Subject}
+	userList = append(userList, u)
+	persistUsers()
+	slog.Info("👥 通过 SSO 自动创建用户", "username", u.Username, "role", u.Role)
+	return &u, nil
+}
+
+// GET /login/oidc/callback IdP 回跳
+func handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if !oidcEnabled() { http.NotFound(w, r); return }
+	state := r.FormValue("state")
+	c, cerr := r.Cookie(oidcStateCookie)
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Value: "", Path: "/login/oidc", HttpOnly: true, Secure: cookieSecure(r), SameSite: http.SameSiteLaxMode, MaxAge: -1})
+	if state == "" || cerr != nil || subtle.ConstantTimeCompare([]byte(c.Value), []byte(state)) != 1 { renderLoginPage(w, "/", "SSO 登录状态无效，请重新登录", 400); return }
+	l := takeOIDCLogin(state)
+	if l == nil { renderLoginPage(w, "/", "SSO 登录已超时，请重新登录", 400); return }
+	if e := r.FormValue("error"); e != "" {
+		slog.WarnContext(r.Context(), "⚠️ IdP 拒绝了 SSO 登录", "error", e, "description", r.FormValue("error_description"), "ip", clientIP(r).String())
+		renderLoginPage(w, l.Next, "SSO 登录失败: "+e, 401)
+		return
+	}
+
+	if !checkLockout(w, r, "") { return }
+	claims, err := exchangeOIDCCode(r, r.FormValue("code"), l)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "❌ SSO 登录失败", "err", err, "ip", clientIP(r).String())
+		recordAuthFailure(r, "", "SSO: "+err.Error())
+		renderLoginPage(w, l.Next, "SSO 登录失败", 401)
+		return
+	}
+	u, err := oidcUser(claims)
+	if err != nil {
+		slog.WarnContext(r.Context(), "⚠️ SSO 登录被拒绝", "err", err, "ip", clientIP(r).String())
+		renderLoginPage(w, l.Next, err.Error(), err.(*authError).Status)
+		return
+	}
+	recordAuthSuccess(r, u.Username)
+
+	s := newSession(userPrincipal(u))
+	if u.totpEnabled() {
+		s.MFAPending = true
+		setSessionCookie(w, r, s)
+		http.Redirect(w, r, "/login/otp?next="+url.QueryEscape(l.Next), http.StatusSeeOther)
+		return
+	}
+	setSessionCookie(w, r, s)
+	slog.InfoContext(r.Context(), "👤 通过 SSO 登录", "username", u.Username, "ip", clientIP(r).String())
+	http.Redirect(w, r, l.Next, http.StatusSeeOther)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ================= 命令行: oidc-mock =================
+// 本地调试 SSO 用的模拟 IdP，不要用于生产。授权请求直接以 -user / -groups 指定的身份通过，
+// 但会像真实 IdP 一样校验 client_id、redirect_uri 和 PKCE，签发 RS256 的 ID Token。
+//
+//   ./server oidc-mock -addr 127.0.0.1:9000 -user alice -groups license-admins
+//   OIDC_ISSUER=http://127.0.0.1:9000 OIDC_CLIENT_ID=license-server OIDC_ROLE_MAP=license-admins=admin ./server
+
+type mockAuthCode struct {
+	RedirectURI string
+	Challenge   string
+	Nonce       string
+	CreatedAt   time.Time
+}
+
+// oidcMock 模拟 IdP 的状态；测试里用 httptest.NewServer(m.handler()) 启动
+type oidcMock struct {
+	issuer, clientID, clientSecret string
+	user, sub                      string
+	groups                         []string
+	editClaims                     func(claims map[string]interface{}) // 测试用：签发前改写声明
+
+	mu    sync.Mutex
+	key   *rsa.PrivateKey
+	kid   string
+	codes map[string]*mockAuthCode
+}
+
+func cmdOIDCMock(args []string) error {
+	fs := flag.NewFlagSet("oidc-mock", flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1:9000", "监听地址")
+	issuer := fs.String("issuer", "", "issuer，默认 http://<addr>")
+	clientID := fs.String("client-id", "license-server", "允许的 client_id")
+	clientSecret := fs.String("client-secret", "", "设置后要求 client_secret_basic")
+	user := fs.String("user", "alice", "登录用户名 (preferred_username)")
+	sub := fs.String("sub", "", "sub，默认 mock-<user>")
+	groups := fs.String("groups", "", "组，逗号分隔")
+	fs.Parse(args)
+	if *issuer == "" { *issuer = "http://" + *addr }
+	if *sub == "" { *sub = "mock-" + *user }
+
+	m := &oidcMock{issuer: strings.TrimRight(*issuer, "/"), clientID: *clientID, clientSecret: *clientSecret, user: *user, sub: *sub, groups: splitList(*groups), codes: map[string]*mockAuthCode{}}
+	if err := m.rotateKey(); err != nil { return err }
+	slog.Info("🧪 模拟 IdP 已启动", "issuer", m.issuer, "client_id", m.clientID, "username", m.user, "groups", *groups)
+	return http.ListenAndServe(*addr, m.handler())
+}
+
+// rotateKey 换一把新的签名密钥（新的 kid），旧密钥不再出现在 JWKS 里
+func (m *oidcMock) rotateKey() error {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil { return err }
+	m.mu.Lock(); defer m.mu.Unlock()
+	m.key, m.kid = key, randomString(8)
+	return nil
+}
+
+func (m *oidcMock) handler() http.Handler {
+	writeJSON := func(w http.ResponseWriter, status int, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(v)
+	}
+	oauthError := func(w http.ResponseWriter, status int, code, desc string) {
+		writeJSON(w, status, map[string]string{"error": code, "error_description": desc})
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, 200, map[string]interface{}{
+			"issuer": m.issuer, "authorization_endpoint": m.issuer + "/authorize", "token_endpoint": m.issuer + "/token", "jwks_uri": m.issuer + "/jwks",
+			"response_types_supported": []string{"code"}, "subject_types_supported": []string{"public"}, "id_token_signing_alg_values_supported": []string{"RS256"},
+			"code_challenge_methods_supported": []string{"S256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		key, kid := m.key, m.kid
+		m.mu.Unlock()
+		e := big.NewInt(int64(key.E)).Bytes()
+		writeJSON(w, 200, map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "use": "sig", "alg": "RS256", "kid": kid,
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()), "e": base64.RawURLEncoding.EncodeToString(e),
+		}}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		redirect := q.Get("redirect_uri")
+		if q.Get("client_id") != m.clientID || redirect == "" { http.Error(w, "client_id 或 redirect_uri 无效", 400); return }
+		if q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+			http.Error(w, "只支持 response_type=code + PKCE S256", 400)
+			return
+		}
+		code := randomString(24)
+		m.mu.Lock()
+		m.codes[code] = &mockAuthCode{RedirectURI: redirect, Challenge: q.Get("code_challenge"), Nonce: q.Get("nonce"), CreatedAt: time.Now()}
+		m.mu.Unlock()
+		back := url.Values{"code": {code}, "state": {q.Get("state")}}
+		slog.Info("🧪 模拟 IdP 授权", "username", m.user, "groups", strings.Join(m.groups, ","))
+		http.Redirect(w, r, redirect+"?"+back.Encode(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" { oauthError(w, 405, "invalid_request", "POST only"); return }
+		if m.clientSecret != "" {
+			id, secret, ok := r.BasicAuth()
+			if !ok || id != m.clientID || subtle.ConstantTimeCompare([]byte(secret), []byte(m.clientSecret)) != 1 { oauthError(w, 401, "invalid_client", "client authentication failed"); return }
+		}
+		if r.FormValue("grant_type") != "authorization_code" || r.FormValue("client_id") != m.clientID { oauthError(w, 400, "invalid_request", "bad grant_type or client_id"); return }
+		m.mu.Lock()
+		c := m.codes[r.FormValue("code")]
+		delete(m.codes, r.FormValue("code"))
+		key, kid := m.key, m.kid
+		m.mu.Unlock()
+		if c == nil || time.Since(c.CreatedAt) > time.Minute || c.RedirectURI != r.FormValue("redirect_uri") { oauthError(w, 400, "invalid_grant", "unknown code or redirect_uri mismatch"); return }
+		if pkceChallenge(r.FormValue("code_verifier")) != c.Challenge { oauthError(w, 400, "invalid_grant", "PKCE verification failed"); return }
+
+		now := time.Now()
+		claims := map[string]interface{}{
+			"iss": m.issuer, "sub": m.sub, "aud": m.clientID, "iat": now.Unix(), "exp": now.Add(5 * time.Minute).Unix(),
+			"nonce": c.Nonce, "preferred_username": m.user, "email": m.user + "@example.test", "groups": m.groups,
+		}
+		if m.editClaims != nil { m.editClaims(claims) }
+		header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid})
+		payload, _ := json.Marshal(claims)
+		signing := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+		digest := sha256.Sum256([]byte(signing))
+		sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil { oauthError(w, 500, "server_error", err.Error()); return }
+		writeJSON(w, 200, map[string]interface{}{
+			"access_token": randomString(24), "token_type": "Bearer", "expires_in": 300,
+			"id_token": signing + "." + base64.RawURLEncoding.EncodeToString(sig),
+		})
+	})
+	return mux
+}
+
+func splitList(s string) []string {
+	out := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" { out = append(out, item) }
+	}
+	return out
+}
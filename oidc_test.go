@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// withOIDCMock 启动模拟 IdP 并把 OIDC 配置指向它，组 license-admins 映射为 admin
+func withOIDCMock(t *testing.T) *oidcMock {
+	t.Helper()
+	m := &oidcMock{clientID: "license-server", user: "alice", sub: "mock-alice", groups: []string{"license-admins"}, codes: map[string]*mockAuthCode{}}
+	if err := m.rotateKey(); err != nil { t.Fatal(err) }
+	srv := httptest.NewServer(m.handler())
+	t.Cleanup(srv.Close)
+	m.issuer = srv.URL
+
+	withDataDir(t, t.TempDir())
+	withLockouts(t, 100)
+	savedIssuer, savedClient, savedMap, savedUsers := OIDCIssuer, OIDCClientID, OIDCRoleMapRaw, userList
+	OIDCIssuer, OIDCClientID, OIDCRoleMapRaw, userList = srv.URL, "license-server", "license-admins=admin", nil
+	resetOIDCCache()
+	if err := checkOIDCConfig(); err != nil { t.Fatal(err) }
+	t.Cleanup(func() {
+		OIDCIssuer, OIDCClientID, OIDCRoleMapRaw, userList = savedIssuer, savedClient, savedMap, savedUsers
+		resetOIDCCache()
+	})
+	return m
+}
+
+func resetOIDCCache() {
+	oidcMutex.Lock(); defer oidcMutex.Unlock()
+	oidcMeta, oidcMetaAt, oidcKeys, oidcKeysAt, oidcKeysTried = nil, time.Time{}, nil, time.Time{}, time.Time{}
+}
+
+// oidcLoginFlow /login/oidc → IdP /authorize → /login/oidc/callback；tamper 可以在回调前改写回调 URL
+func oidcLoginFlow(t *testing.T, tamper func(cb *url.URL, state *http.Cookie)) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handleOIDCLogin(w, httptest.NewRequest("GET", "/login/oidc?next=/history", nil))
+	if w.Code != http.StatusFound { t.Fatalf("/login/oidc 应跳转到 IdP，实际 %d %s", w.Code, w.Body.String()) }
+	var state *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == oidcStateCookie { state = c }
+	}
+	if state == nil { t.Fatal("缺少 state Cookie") }
+
+	noFollow := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noFollow.Get(w.Header().Get("Location"))
+	if err != nil { t.Fatal(err) }
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound { t.Fatalf("IdP /authorize 返回 %d", resp.StatusCode) }
+	cb, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil { t.Fatal(err) }
+	if tamper != nil { tamper(cb, state) }
+
+	r := httptest.NewRequest("GET", cb.RequestURI(), nil)
+	r.AddCookie(state)
+	w = httptest.NewRecorder()
+	handleOIDCCallback(w, r)
+	return w
+}
+
+func assertOIDCLoggedIn(t *testing.T, w *httptest.ResponseRecorder) {
+	t.Helper()
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/history" { t.Fatalf("期望登录成功跳回 /history，实际 %d %q %s", w.Code, w.Header().Get("Location"), w.Body.String()) }
+	for _, c := range w.Result().Cookies() {
+		if c.Name == sessionCookieName && c.Value != "" { destroySession(c.Value); return }
+	}
+	t.Fatal("登录成功后应设置会话 Cookie")
+}
+
+func TestOIDCLoginHappyPath(t *testing.T) {
+	withOIDCMock(t)
+	assertOIDCLoggedIn(t, oidcLoginFlow(t, nil))
+	if len(userList) != 1 || userList[0].Username != "alice" || userList[0].Role != roleAdmin { t.Fatalf("应自动创建 admin 用户: %+v", userList) }
+}
+
+func TestOIDCRejectsBadState(t *testing.T) {
+	withOIDCMock(t)
+	w := oidcLoginFlow(t, func(cb *url.URL, state *http.Cookie) {
+		q := cb.Query()
+		q.Set("state", "forged")
+		cb.RawQuery = q.Encode()
+	})
+	if w.Code != 400 { t.Fatalf("state 不匹配应返回 400，实际 %d", w.Code) }
+	w = oidcLoginFlow(t, func(cb *url.URL, state *http.Cookie) { state.Value = "other" })
+	if w.Code != 400 { t.Fatalf("Cookie 中的 state 不匹配应返回 400，实际 %d", w.Code) }
+}
+
+func TestOIDCRejectsBadClaims(t *testing.T) {
+	m := withOIDCMock(t)
+	cases := map[string]func(map[string]interface{}){
+		"nonce":   func(c map[string]interface{}) { c["nonce"] = "replayed" },
+		"aud":     func(c map[string]interface{}) { c["aud"] = "other-app" },
+		"iss":     func(c map[string]interface{}) { c["iss"] = "https://evil.example" },
+		"expired": func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+	}
+	for name, edit := range cases {
+		m.editClaims = edit
+		if w := oidcLoginFlow(t, nil); w.Code != 401 { t.Errorf("%s: 期望 401，实际 %d", name, w.Code) }
+	}
+	if len(userList) != 0 { t.Fatalf("校验失败时不应创建用户: %+v", userList) }
+}
+
+func TestOIDCRefreshesJWKSOnUnknownKid(t *testing.T) {
+	m := withOIDCMock(t)
+	assertOIDCLoggedIn(t, oidcLoginFlow(t, nil))
+	if err := m.rotateKey(); err != nil { t.Fatal(err) }
+
+	// 刚拉取过 JWKS，一分钟内不会因为未知 kid 再次拉取
+	if w := oidcLoginFlow(t, nil); w.Code != 401 { t.Fatalf("刷新间隔内应拒绝未知 kid，实际 %d", w.Code) }
+	oidcMutex.Lock(); oidcKeysTried = time.Now().Add(-oidcMinKeyRefresh); oidcMutex.Unlock()
+	assertOIDCLoggedIn(t, oidcLoginFlow(t, nil))
+}
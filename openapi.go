@@ -0,0 +1,81 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// ================= OpenAPI 文档 =================
+// openapi.json 随程序一起编译，GET /api/openapi.json 返回原文，/api/docs 是 Swagger UI。
+// 修改接口时同步修改 openapi.json，再运行 go generate ./client 重新生成 Go 客户端。
+// 启动时 checkOpenAPI 会把文档里的路径和方法逐个对照实际路由，不一致时打印警告。
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+// Swagger UI 的静态文件从 CDN 加载，页面本身不需要鉴权
+const swaggerUIVersion = "5.17.14"
+
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" { http.Error(w, "Method Not Allowed", 405); return }
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write(openAPISpec)
+}
+
+func handleAPIDocs(w http.ResponseWriter, r *http.Request) {
+	cdn := "https://unpkg.com/swagger-ui-dist@" + swaggerUIVersion
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, `<!DOCTYPE html><html><head><meta charset="utf-8"><title>License Server API</title>
+<link rel="stylesheet" href="%[1]s/swagger-ui.css"></head><body><div id="swagger-ui"></div>
+<script src="%[1]s/swagger-ui-bundle.js" crossorigin></script>
+<script>window.ui = SwaggerUIBundle({url: "/api/openapi.json", dom_id: "#swagger-ui", persistAuthorization: true});</script>
+</body></html>`, cdn)
+}
+
+type openAPIDoc struct {
+	Paths map[string]map[string]json.RawMessage `json:"paths"`
+}
+
+var openAPIMethods = []string{"get", "post", "put", "patch", "delete"}
+
+// checkOpenAPI 对照文档和实际路由，返回不一致的地方
+func checkOpenAPI(mux *http.ServeMux) []string {
+	var doc openAPIDoc
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil { return []string{"openapi.json 解析失败: " + err.Error()} }
+	var problems []string
+	documented := map[string]bool{}
+	for path, item := range doc.Paths {
+		sample := strings.NewReplacer("{id}", "x", "{target}", "history").Replace(path)
+		if _, pattern := mux.Handler(&http.Request{Method: "GET", URL: &url.URL{Path: sample}}); pattern == "/" && path != "/" { problems = append(problems, path+" 没有对应的路由") }
+		for _, m := range openAPIMethods {
+			if _, ok := item[m]; ok { documented[strings.ToUpper(m)+" "+path] = true }
+		}
+	}
+	// /api/v1 由 v1Resources 分发，方法可以逐一核对
+	for name, res := range v1Resources {
+		coll, item := "/api/v1/"+name, "/api/v1/"+name+"/{id}"
+		for route, h := range map[string]v1Handler{"GET " + coll: res.List, "POST " + coll: res.Create, "GET " + item: res.Get, "PATCH " + item: res.Update, "DELETE " + item: res.Delete} {
+			if h != nil && !documented[route] { problems = append(problems, route+" 没有写进文档") }
+			if h == nil && documented[route] { problems = append(problems, route+" 写进了文档但没有实现") }
+		}
+	}
+	for route := range documented {
+		parts := strings.SplitN(route, " ", 2)
+		if name := strings.Split(strings.TrimPrefix(parts[1], "/api/v1/"), "/")[0]; strings.HasPrefix(parts[1], "/api/v1/") {
+			if _, ok := v1Resources[name]; !ok { problems = append(problems, route+" 写进了文档但资源不存在") }
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+func warnOpenAPIDrift() {
+	for _, p := range checkOpenAPI(http.DefaultServeMux) { slog.Warn("⚠️ OpenAPI 文档与路由不一致", "detail", p) }
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"license-server/client"
+)
+
+// v1Routes 由 v1Resources 展开的全部 "方法 路径"
+func v1Routes() map[string]bool {
+	routes := map[string]bool{}
+	for name, res := range v1Resources {
+		coll, item := "/api/v1/"+name, "/api/v1/"+name+"/{id}"
+		for route, h := range map[string]v1Handler{"GET " + coll: res.List, "POST " + coll: res.Create, "GET " + item: res.Get, "PATCH " + item: res.Update, "DELETE " + item: res.Delete} {
+			if h != nil { routes[route] = true }
+		}
+	}
+	return routes
+}
+
+// documentedV1Routes openapi.json 里 /api/v1 下的全部 "方法 路径"
+func documentedV1Routes(t *testing.T) map[string]bool {
+	t.Helper()
+	var doc openAPIDoc
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil { t.Fatal(err) }
+	routes := map[string]bool{}
+	for path, item := range doc.Paths {
+		if !strings.HasPrefix(path, "/api/v1/") { continue }
+		for method := range item {
+			if method == "parameters" { continue }
+			routes[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+	return routes
+}
+
+func missing(want, have map[string]bool) []string {
+	var out []string
+	for r := range want {
+		if !have[r] { out = append(out, r) }
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestOpenAPICoversV1Routes(t *testing.T) {
+	routes, documented := v1Routes(), documentedV1Routes(t)
+	if len(routes) == 0 { t.Fatal("没有找到 /api/v1 路由") }
+	for _, r := range missing(routes, documented) { t.Errorf("%s 没有写进 openapi.json", r) }
+	for _, r := range missing(documented, routes) { t.Errorf("openapi.json 里的 %s 没有实现", r) }
+}
+
+// 文档里的每个 /api/v1 接口实际发一次请求：未登录应得到 401，而不是 404 / 405
+func TestOpenAPIV1RoutesDispatch(t *testing.T) {
+	mux := http.NewServeMux()
+	registerRoutes(mux)
+	for route := range documentedV1Routes(t) {
+		method, path, _ := strings.Cut(route, " ")
+		r := httptest.NewRequest(method, strings.ReplaceAll(path, "{id}", "x"), strings.NewReader("{}"))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		if w.Code == 404 || w.Code == 405 { t.Errorf("%s: %d %s", route, w.Code, w.Body.String()) }
+	}
+}
+
+func TestOpenAPIMatchesMux(t *testing.T) {
+	mux := http.NewServeMux()
+	registerRoutes(mux)
+	for _, p := range checkOpenAPI(mux) { t.Error(p) }
+}
+
+// ================= 响应对照 schema =================
+
+// schemaRequired openapi.json 里 components.schemas 各自的必填字段
+func schemaRequired(t *testing.T) map[string][]string {
+	t.Helper()
+	var doc struct {
+		Components struct {
+			Schemas map[string]struct{ Required []string `json:"required"` } `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil { t.Fatal(err) }
+	out := map[string][]string{}
+	for name, s := range doc.Components.Schemas { out[name] = s.Required }
+	return out
+}
+
+// callV1 以管理员身份调用 /api/v1 接口
+func callV1(method, path, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r = r.WithContext(context.WithValue(r.Context(), ctxPrincipal, &principal{Name: "root", Role: roleAdmin, Scopes: []string{scopeAdmin}}))
+	w := httptest.NewRecorder()
+	handleAPIv1(w, r)
+	return w
+}
+
+// decodeV1 把响应严格解码到生成的 client 类型（多出文档里没有的字段就失败），并检查 data 里的必填字段
+func decodeV1(t *testing.T, w *httptest.ResponseRecorder, status int, out interface{}, schema string) {
+	t.Helper()
+	if w.Code != status { t.Fatalf("status = %d，期望 %d: %s", w.Code, status, w.Body.String()) }
+	dec := json.NewDecoder(bytes.NewReader(w.Body.Bytes()))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(out); err != nil { t.Fatalf("响应与 %T 不符: %v\n%s", out, err, w.Body.String()) }
+
+	var env struct{ Data json.RawMessage `json:"data"` }
+	if err := json.Unmarshal(w.Body.Bytes(), &env); err != nil { t.Fatal(err) }
+	var items []map[string]json.RawMessage
+	if bytes.HasPrefix(env.Data, []byte("[")) {
+		if err := json.Unmarshal(env.Data, &items); err != nil { t.Fatal(err) }
+	} else {
+		var item map[string]json.RawMessage
+		if err := json.Unmarshal(env.Data, &item); err != nil { t.Fatal(err) }
+		items = append(items, item)
+	}
+	required := schemaRequired(t)[schema]
+	if len(required) == 0 { t.Fatalf("openapi.json 里没有 %s 的必填字段", schema) }
+	for _, item := range items {
+		for _, f := range required {
+			if _, ok := item[f]; !ok { t.Errorf("%s 缺少必填字段 %s: %s", schema, f, w.Body.String()) }
+		}
+	}
+}
+
+func TestV1LicenseResponsesMatchSchema(t *testing.T) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	withStore(t, []HistoryRecord{
+		{ID: "h1", MachineID: "M-1", ExpiryDate: "2999-01-01", LicenseCode: "C1", GenerateTime: now, IssuedBy: "ops", Owner: "acme", CustomerID: "c1"},
+		{ID: "h2", MachineID: "M-2", ExpiryDate: "2999-01-01", LicenseCode: "C2", GenerateTime: now, RevokedAt: now, RevokedBy: "ops"},
+	}, nil)
+
+	var list client.LicenseList
+	decodeV1(t, callV1("GET", "/api/v1/licenses", ""), 200, &list, "License")
+	if len(list.Data) != 2 { t.Fatalf("期望 2 条，实际 %+v", list) }
+	var one client.LicenseResponse
+	decodeV1(t, callV1("GET", "/api/v1/licenses/h2", ""), 200, &one, "License")
+	if one.Data.Status != "revoked" || one.Data.RevokedAt == "" { t.Fatalf("吊销状态不对: %+v", one.Data) }
+
+	var e client.Error
+	w := callV1("GET", "/api/v1/licenses/missing", "")
+	if w.Code != 404 { t.Fatalf("status = %d", w.Code) }
+	dec := json.NewDecoder(w.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&e); err != nil || e.Error.Code != "not_found" || e.Error.Status != 404 { t.Fatalf("错误响应与 Error 不符: %v %+v", err, e) }
+}
+
+func TestV1MachineResponsesMatchSchema(t *testing.T) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	withStore(t, []HistoryRecord{{ID: "h1", MachineID: "M-1", ExpiryDate: "2999-01-01", LicenseCode: "C1", GenerateTime: now}}, nil)
+	machineList = []MachineRecord{{MachineID: "M-1", LastSeen: now, Owner: "acme", CustomerID: "c1"}}
+
+	var list client.MachineList
+	decodeV1(t, callV1("GET", "/api/v1/machines", ""), 200, &list, "Machine")
+	if len(list.Data) != 1 || list.Data[0].LatestExpiry != "2999-01-01" { t.Fatalf("机器列表不对: %+v", list) }
+	var one client.MachineResponse
+	decodeV1(t, callV1("GET", "/api/v1/machines/M-1", ""), 200, &one, "Machine")
+}
+
+func TestV1CustomerResponsesMatchSchema(t *testing.T) {
+	withDataDir(t, t.TempDir())
+	saved := customerList
+	customerList = []Customer{{ID: "c1", Name: "ACME", Email: "ops@acme.test", ExternalID: "cus_1", Notes: "VIP", Owner: "acme", CreatedAt: time.Now().UTC().Format(time.RFC3339)}}
+	t.Cleanup(func() { customerList = saved })
+
+	var list client.CustomerList
+	decodeV1(t, callV1("GET", "/api/v1/customers", ""), 200, &list, "Customer")
+	var created client.CustomerResponse
+	decodeV1(t, callV1("POST", "/api/v1/customers", `{"name":"Globex","email":"it@globex.test"}`), 201, &created, "Customer")
+	var one client.CustomerResponse
+	decodeV1(t, callV1("GET", "/api/v1/customers/"+created.Data.ID, ""), 200, &one, "Customer")
+	if one.Data.Name != "Globex" { t.Fatalf("客户不对: %+v", one.Data) }
+}
+
+func TestV1TokenResponsesMatchSchema(t *testing.T) {
+	withDataDir(t, t.TempDir())
+	saved := tokenList
+	now := time.Now().UTC().Format(time.RFC3339)
+	tokenList = []APIToken{{ID: "t1", Name: "billing", SecretHash: "x", Prefix: "lsk_abcd", Scopes: []string{scopeGenerate}, CreatedAt: now,
+		ExpiresAt: "2999-01-01T00:00:00Z", MaxDays: 30, AllowedIPs: []string{"10.0.0.0/8"}, LastUsedAt: now}}
+	t.Cleanup(func() { tokenList = saved })
+
+	var list client.TokenList
+	decodeV1(t, callV1("GET", "/api/v1/tokens", ""), 200, &list, "Token")
+	var one client.TokenResponse
+	decodeV1(t, callV1("GET", "/api/v1/tokens/t1", ""), 200, &one, "Token")
+	if one.Data.Secret != "" || one.Data.HMACSecret != "" { t.Fatal("查询时不应返回密钥") }
+	var created client.TokenResponse
+	decodeV1(t, callV1("POST", "/api/v1/tokens", `{"name":"ci","scopes":["generate"],"hmac":true}`), 201, &created, "Token")
+	if created.Data.Secret == "" || created.Data.HMACSecret == "" || !created.Data.HMAC { t.Fatalf("创建时应返回密钥: %+v", created.Data) }
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ================= 限流与防暴力破解 =================
+// 令牌桶限流：所有请求按来源 IP 限流（中间件），鉴权通过后再按 Token / 用户限流（authorize）。
+// 鉴权失败按 IP（登录时还按用户名）计数，连续失败达到阈值后锁定，锁定时间指数增长，并推送 Telegram 告警。
+// 超限一律返回 429 + Retry-After。各项设为 0 表示关闭。
+
+var (
+	RateLimitIPPerMin    = newReloadable(getEnvInt("RATE_LIMIT_IP_PER_MIN", 120))
+	RateLimitIPBurst     = newReloadable(getEnvInt("RATE_LIMIT_IP_BURST", 30))
+	RateLimitTokenPerMin = newReloadable(getEnvInt("RATE_LIMIT_TOKEN_PER_MIN", 60))
+	RateLimitTokenBurst  = newReloadable(getEnvInt("RATE_LIMIT_TOKEN_BURST", 20))
+	AuthLockoutThreshold = newReloadable(getEnvInt("AUTH_LOCKOUT_THRESHOLD", 5))
+	AuthLockoutBase      = newReloadable(getEnvDuration("AUTH_LOCKOUT_BASE", time.Minute))
+	AuthLockoutMax       = newReloadable(getEnvDuration("AUTH_LOCKOUT_MAX", time.Hour))
+	AuthFailureWindow    = newReloadable(getEnvDuration("AUTH_FAILURE_WINDOW", 15*time.Minute))
+)
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type rateLimiter struct {
+	perSec  float64
+	burst   float64
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimiter(perMin, burst int) *rateLimiter {
+	if burst < 1 { burst = 1 }
+	return &rateLimiter{perSec: float64(perMin) / 60, burst: float64(burst), buckets: map[string]*tokenBucket{}}
+}
+
+// setRate 热加载配置时调整速率，已有的桶保留
+func (l *rateLimiter) setRate(perMin, burst int) {
+	if burst < 1 { burst = 1 }
+	l.mu.Lock(); defer l.mu.Unlock()
+	l.perSec, l.burst = float64(perMin)/60, float64(burst)
+}
+
+// allow 取走一个令牌；不够时返回需要等待的时间
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock(); defer l.mu.Unlock()
+	if l.perSec <= 0 { return true, 0 }
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.perSec)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.perSec * float64(time.Second))
+	return false, wait
+}
+
+// sweep 删除已经回满的桶，避免 map 无限增长
+func (l *rateLimiter) sweep(now time.Time) {
+	l.mu.Lock(); defer l.mu.Unlock()
+	for k, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.perSec >= l.burst { delete(l.buckets, k) }
+	}
+}
+
+var (
+	ipLimiter    = newRateLimiter(RateLimitIPPerMin.Get(), RateLimitIPBurst.Get())
+	tokenLimiter = newRateLimiter(RateLimitTokenPerMin.Get(), RateLimitTokenBurst.Get())
+)
+
+func writeTooManyRequests(w http.ResponseWriter, r *http.Request, wait time.Duration, msg string) {
+	secs := int(math.Ceil(wait.Seconds()))
+	if secs < 1 { secs = 1 }
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	writeError(w, r, http.StatusTooManyRequests, "rate_limited", fmt.Sprintf("%s，请 %d 秒后再试", msg, secs))
+}
+
+// rateLimitMiddleware 按来源 IP 限流，健康检查不计入
+func rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			if ok, wait := ipLimiter.allow(clientIP(r).String(), time.Now()); !ok {
+				writeTooManyRequests(w, r, wait, "请求过于频繁")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowPrincipal 鉴权通过后按 Token / 用户限流
+func allowPrincipal(w http.ResponseWriter, r *http.Request, p *principal) bool {
+	if ok, wait := principalLimit(p); !ok {
+		writeTooManyRequests(w, r, wait, "「"+p.Name+"」请求过于频繁")
+		return false
+	}
+	return true
+}
+
+// principalLimit 按 Token / 用户取一个令牌，HTTP 和 gRPC 共用同一个桶
+func principalLimit(p *principal) (bool, time.Duration) {
+	key := "token:" + p.Name
+	if p.Token != nil { key = "token:" + p.Token.ID }
+	if p.User != nil { key = "user:" + p.User.ID }
+	return tokenLimiter.allow(key, time.Now())
+}
+
+// ================= 失败锁定 =================
+
+type lockoutState struct {
+	failures    int
+	level       int // 已经被锁定的次数，决定下一次锁多久
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+var (
+	lockouts     = map[string]*lockoutState{}
+	lockoutMutex sync.Mutex
+)
+
+func lockoutKeys(r *http.Request, username string) []string {
+	keys := []string{"ip:" + clientIP(r).String()}
+	if username != "" { keys = append(keys, "user:"+username) }
+	return keys
+}
+
+// checkLockout 鉴权前调用；被锁定时写入 429 并返回 false
+func checkLockout(w http.ResponseWriter, r *http.Request, username string) bool {
+	if wait := lockoutWait(r, username); wait > 0 { writeTooManyRequests(w, r, wait, "鉴权失败次数过多，已临时锁定"); return false }
+	return true
+}
+
+// lockoutWait 还要锁定多久，0 表示没有被锁定
+func lockoutWait(r *http.Request, username string) time.Duration {
+	now := time.Now()
+	lockoutMutex.Lock(); defer lockoutMutex.Unlock()
+	var wait time.Duration
+	for _, k := range lockoutKeys(r, username) {
+		if st, ok := lockouts[k]; ok && now.Before(st.lockedUntil) && st.lockedUntil.Sub(now) > wait { wait = st.lockedUntil.Sub(now) }
+	}
+	return wait
+}
+
+// recordAuthFailure 记录一次失败，达到阈值时锁定并告警
+func recordAuthFailure(r *http.Request, username, reason string) {
+	recordAuthFailureMetric(reason)
+	if AuthLockoutThreshold.Get() <= 0 { return }
+	now := time.Now()
+	ip := clientIP(r).String()
+	lockoutMutex.Lock(); defer lockoutMutex.Unlock()
+	for _, k := range lockoutKeys(r, username) {
+		st, ok := lockouts[k]
+		if !ok { st = &lockoutState{}; lockouts[k] = st }
+		if now.Sub(st.lastFailure) > AuthFailureWindow.Get() && now.After(st.lockedUntil) {
+			st.failures = 0
+			if now.Sub(st.lockedUntil) > AuthFailureWindow.Get() { st.level = 0 }
+		}
+		st.failures++
+		st.lastFailure = now
+		if st.failures < AuthLockoutThreshold.Get() { continue }
+
+		st.failures = 0
+		st.level++
+		d := time.Duration(float64(AuthLockoutBase.Get()) * math.Pow(2, float64(st.level-1)))
+		if d > AuthLockoutMax.Get() || d <= 0 { d = AuthLockoutMax.Get() }
+		st.lockedUntil = now.Add(d)
+		slog.WarnContext(r.Context(), "🚨 连续鉴权失败，已锁定", "key", k, "duration", d, "ip", ip, "reason", reason)
+		sendTelegramMessage(fmt.Sprintf("🚨 <b>鉴权失败次数过多，已锁定</b>\n\n"+
+			"🎯 <b>对象:</b> <code>%s</code>\n"+
+			"🌐 <b>IP:</b> <code>%s</code>\n"+
+			"❓ <b>原因:</b> %s\n"+
+			"⏳ <b>锁定:</b> %s\n"+
+			"🕒 <b>时间:</b> %s",
+			html.EscapeString(k), ip, html.EscapeString(reason), d, time.Now().Format("2006-01-02 15:04:05")))
+	}
+}
+
+// recordAuthSuccess 成功后清零该用户名的失败计数；锁定级别保留到 AUTH_FAILURE_WINDOW 过后，避免穿插一次成功就重置指数退避。
+// IP 的计数不清零：否则攻击者穿插用自己的账号或 Token 登录一次，就能在同一个 IP 上无限猜别人的密码
+func recordAuthSuccess(r *http.Request, username string) {
+	if username == "" { return }
+	lockoutMutex.Lock(); defer lockoutMutex.Unlock()
+	if st, ok := lockouts["user:"+username]; ok { st.failures = 0 }
+}
+
+func startRateLimitSweeper() {
+	go func() {
+		for range time.Tick(5 * time.Minute) {
+			now := time.Now()
+			ipLimiter.sweep(now)
+			tokenLimiter.sweep(now)
+			lockoutMutex.Lock()
+			for k, st := range lockouts {
+				if now.Sub(st.lastFailure) > AuthFailureWindow.Get() && now.Sub(st.lockedUntil) > AuthFailureWindow.Get() { delete(lockouts, k) }
+			}
+			lockoutMutex.Unlock()
+		}
+	}()
+}
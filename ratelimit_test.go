@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func withLockouts(t *testing.T, threshold int) {
+	t.Helper()
+	savedThreshold, savedLockouts := AuthLockoutThreshold.Get(), lockouts
+	AuthLockoutThreshold.Set(threshold)
+	lockoutMutex.Lock(); lockouts = map[string]*lockoutState{}; lockoutMutex.Unlock()
+	t.Cleanup(func() {
+		AuthLockoutThreshold.Set(savedThreshold)
+		lockoutMutex.Lock(); lockouts = savedLockouts; lockoutMutex.Unlock()
+	})
+}
+
+func TestAuthSuccessKeepsIPFailures(t *testing.T) {
+	withLockouts(t, 3)
+	r := httptest.NewRequest("POST", "/login", nil)
+	r.RemoteAddr = "198.51.100.9:4000"
+
+	recordAuthFailure(r, "alice", "test")
+	recordAuthFailure(r, "bob", "test")
+	// 攻击者用自己的账号登录成功一次，不应清掉这个 IP 上的失败次数
+	recordAuthSuccess(r, "mallory")
+	recordAuthSuccess(r, "")
+	recordAuthFailure(r, "carol", "test")
+	if lockoutWait(r, "") <= 0 { t.Fatal("同一 IP 连续失败 3 次应被锁定") }
+
+	// 用户名自己的计数在成功后清零
+	r2 := httptest.NewRequest("POST", "/login", nil)
+	r2.RemoteAddr = "198.51.100.10:4000"
+	recordAuthFailure(r2, "dave", "test")
+	recordAuthFailure(r2, "dave", "test")
+	recordAuthSuccess(r2, "dave")
+	lockoutMutex.Lock()
+	userFailures, ipFailures := lockouts["user:dave"].failures, lockouts["ip:198.51.100.10"].failures
+	lockoutMutex.Unlock()
+	if userFailures != 0 || ipFailures != 2 { t.Fatalf("成功后 user 计数 %d（期望 0），IP 计数 %d（期望 2）", userFailures, ipFailures) }
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ================= 数据保留策略 =================
+// RETENTION_ARCHIVE_DAYS: 到期超过 N 天的历史记录移入按月压缩的归档文件，0 表示不归档
+// RETENTION_REDACT:       归档时把激活码替换成不可用的指纹，归档文件里不再保留有效激活码
+// RETENTION_MACHINE_DAYS: 没有有效授权且最后生成时间早于 N 天的机器码会被清除，0 表示不清除
+
+var (
+	RetentionArchiveDays = getEnvInt("RETENTION_ARCHIVE_DAYS", 0)
+	RetentionMachineDays = getEnvInt("RETENTION_MACHINE_DAYS", 0)
+	RetentionRedact      = isTruthy(getEnv("RETENTION_REDACT", ""))
+	RetentionInterval    = getEnvDuration("RETENTION_INTERVAL", 24*time.Hour)
+	ArchiveDir           = getEnv("ARCHIVE_DIR", "archive")
+)
+
+type retentionReport struct {
+	StartedAt         string         `json:"started_at"`
+	FinishedAt        string         `json:"finished_at"`
+	DryRun            bool           `json:"dry_run"`
+	ArchiveDays       int            `json:"archive_days"`
+	MachineDays       int            `json:"machine_days"`
+	Redacted          bool           `json:"redacted"`
+	Archived          int            `json:"archived"`
+	ArchiveFiles      map[string]int `json:"archive_files,omitempty"`
+	MachinesPurged    int            `json:"machines_purged"`
+	PurgedMachineIDs  []string       `json:"purged_machine_ids,omitempty"`
+	HistoryRemaining  int            `json:"history_remaining"`
+	MachinesRemaining int            `json:"machines_remaining"`
+	Error             string         `json:"error,omitempty"`
+}
+
+// runRetention 执行一次归档与清理，结果写入 ARCHIVE_DIR/reports
+func runRetention(dryRun bool) *retentionReport {
+	rep := &retentionReport{
+		StartedAt:    time.Now().UTC().Format(time.RFC3339),
+		DryRun:       dryRun,
+		ArchiveDays:  RetentionArchiveDays,
+		MachineDays:  RetentionMachineDays,
+		Redacted:     RetentionRedact,
+		ArchiveFiles: map[string]int{},
+	}
+	if err := applyRetention(rep); err != nil { rep.Error = err.Error() }
+	rep.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+
+	if !dryRun {
+		name := filepath.Join(ArchiveDir, "reports", "retention-"+time.Now().UTC().Format("20060102-150405")+".json")
+		data, _ := json.MarshalIndent(rep, "", "  ")
+		err := os.MkdirAll(filepath.Dir(name), 0700)
+		if err == nil { err = writeFileAtomic(name, data, 0600) }
+		if err != nil { slog.Warn("⚠️ 保存数据保留报告失败", "err", err) }
+	}
+	return rep
+}
+
+func applyRetention(rep *retentionReport) error {
+	now := time.Now().In(bizLocation())
+	today := now.Format("2006-01-02")
+
+	mutex.Lock(); defer mutex.Unlock()
+
+	// 1. 归档已过期很久的历史记录
+	if RetentionArchiveDays > 0 {
+		cutoff := now.AddDate(0, 0, -RetentionArchiveDays).Format("2006-01-02")
+		byMonth := map[string][]HistoryRecord{}
+		var kept []HistoryRecord
+		for _, rec := range historyList {
+			if rec.ExpiryDate != "" && rec.ExpiryDate < cutoff {
+				byMonth[archiveMonth(rec)] = append(byMonth[archiveMonth(rec)], rec)
+				continue
+			}
+			kept = append(kept, rec)
+		}
+		for month, recs := range byMonth {
+			rep.ArchiveFiles[archiveFileName(month)] = len(recs)
+			rep.Archived += len(recs)
+		}
+		if !rep.DryRun && rep.Archived > 0 {
+			// 先写归档，成功后再从主文件移除，中途失败不会丢数据
+			if err := os.MkdirAll(ArchiveDir, 0700); err != nil { return fmt.Errorf("创建归档目录失败: %v", err) }
+			for month, recs := range byMonth {
+				if err := appendArchive(month, recs); err != nil { return err }
+			}
+			historyList = kept
+			persistHistory()
+		}
+	}
+
+	// 2. 清理长期没有有效授权的机器码
+	if RetentionMachineDays > 0 {
+		active := map[string]bool{}
+		for _, rec := range historyList {
+			if rec.ExpiryDate >= today { active[rec.MachineID] = true }
+		}
+		cutoff := now.AddDate(0, 0, -RetentionMachineDays)
+		var kept []MachineRecord
+		for _, m := range machineList {
+			lastSeen, err := parseTimestamp(m.LastSeen)
+			if !active[m.MachineID] && err == nil && lastSeen.Before(cutoff) {
+				rep.PurgedMachineIDs = append(rep.PurgedMachineIDs, m.MachineID)
+				continue
+			}
+			kept = append(kept, m)
+		}
+		rep.MachinesPurged = len(rep.PurgedMachineIDs)
+		if !rep.DryRun && rep.MachinesPurged > 0 {
+			machineList = kept
+			persistMachines()
+		}
+	}
+
+	rep.HistoryRemaining, rep.MachinesRemaining = len(historyList), len(machineList)
+	if rep.DryRun { rep.HistoryRemaining -= rep.Archived; rep.MachinesRemaining -= rep.MachinesPurged }
+	return nil
+}
+
+// 按生成月份（北京时间）归档，生成时间缺失时退回到期月份
+func archiveMonth(rec HistoryRecord) string {
+	if t, err := parseTimestamp(rec.GenerateTime); err == nil { return t.In(bizLocation()).Format("2006-01") }
+	if len(rec.ExpiryDate) >= 7 { return rec.ExpiryDate[:7] }
+	return "unknown"
+}
+
+func archiveFileName(month string) string { return "history-" + month + ".json.gz" }
+
+// appendArchive 合并到已有的月度归档文件（gzip 压缩的 JSON 数组）
+func appendArchive(month string, recs []HistoryRecord) error {
+	path := filepath.Join(ArchiveDir, archiveFileName(month))
+	existing, err := readArchive(path)
+	if err != nil && !os.IsNotExist(err) { return err }
+
+	for _, rec := range recs {
+		if RetentionRedact { rec.LicenseCode = redactLicense(rec.LicenseCode) }
+		existing = append(existing, rec)
+	}
+	sort.SliceStable(existing, func(i, j int) bool { return existing[i].GenerateTime < existing[j].GenerateTime })
+	return writeArchive(path, existing)
+}
+
+func writeArchive(path string, recs []HistoryRecord) error {
+	recs, err := encodeHistoryForDisk(recs)
+	if err != nil { return err }
+	var buf bytes.Buffer
+	data, err := encodeDataFile(kindHistory, recs)
+	if err != nil { return err }
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil { return err }
+	if err := gz.Close(); err != nil { return err }
+	if err := writeFileAtomic(path, buf.Bytes(), 0600); err != nil { return fmt.Errorf("写入归档 %s 失败: %v", path, err) }
+	return nil
+}
+
+func readArchive(path string) ([]HistoryRecord, error) {
+	f, err := os.Open(path)
+	if err != nil { return nil, err }
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil { return nil, fmt.Errorf("归档 %s 损坏: %v", path, err) }
+	data, err := io.ReadAll(gz)
+	if err != nil { return nil, fmt.Errorf("归档 %s 损坏: %v", path, err) }
+	var recs []HistoryRecord
+	if _, err := decodeDataFile(kindHistory, data, &recs); err != nil { return nil, fmt.Errorf("归档 %s 损坏: %v", path, err) }
+	if err := decodeHistoryFromDisk(recs); err != nil { return nil, fmt.Errorf("归档 %s: %v", path, err) }
+	return recs, nil
+}
+
+// redactLicense 保留前缀和指纹方便对账，但无法再拿去激活
+func redactLicense(code string) string {
+	if code == "" { return "" }
+	prefix := code
+	if len(prefix) > 8 { prefix = prefix[:8] }
+	return prefix + "…redacted:sha256:" + sha256Hex([]byte(code))[:16]
+}
+
+func logRetentionReport(rep *retentionReport) {
+	if rep.Error != "" { slog.Error("❌ 数据保留任务失败", "err", rep.Error); return }
+	slog.Info("🗄️ 数据保留任务完成", "dry_run", rep.DryRun, "archived", rep.Archived, "machines_purged", rep.MachinesPurged,
+		"history_remaining", rep.HistoryRemaining, "machines_remaining", rep.MachinesRemaining)
+}
+
+func startRetentionScheduler() {
+	if RetentionArchiveDays <= 0 && RetentionMachineDays <= 0 { return }
+	slog.Info("✅ 数据保留策略已启用", "archive_days", RetentionArchiveDays, "machine_days", RetentionMachineDays, "redact", RetentionRedact)
+	go func() {
+		ticker := time.NewTicker(RetentionInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-shutdownCh:
+				return
+			}
+			if !beginJob() { return }
+			logRetentionReport(runRetention(false))
+			endJob()
+		}
+	}()
+}
+
+// POST /api/retention/run?dry_run=1  (Authorization: Bearer)
+func handleRetentionRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" { http.Error(w, "Method Not Allowed", 405); return }
+	if r.URL.Query().Has("token") { http.Error(w, "不再支持 URL 中的 token 参数，请使用 Authorization: Bearer", 400); return }
+	p, ok := authorize(w, r, "", scopeAdmin)
+	if !ok { return }
+	dryRun := isTruthy(r.URL.Query().Get("dry_run"))
+	if !dryRun && !requireStepUp(w, r, p, "归档清理") { return }
+	rep := runRetention(dryRun)
+	logRetentionReport(rep)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if rep.Error != "" { w.WriteHeader(500) }
+	json.NewEncoder(w).Encode(rep)
+}
+
+// ./server retention [-dry-run]
+func cmdRetention(args []string) error {
+	fs := flag.NewFlagSet("retention", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "只生成报告，不修改数据")
+	fs.Parse(args)
+
+	if RetentionArchiveDays <= 0 && RetentionMachineDays <= 0 { return fmt.Errorf("未配置 RETENTION_ARCHIVE_DAYS / RETENTION_MACHINE_DAYS") }
+	safeLoadData()
+	rep := runRetention(*dryRun)
+	logRetentionReport(rep)
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(rep)
+	if rep.Error != "" { return fmt.Errorf("%s", rep.Error) }
+	return nil
+}
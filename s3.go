@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ================= S3 兼容存储 =================
+// 只实现备份需要的 PUT / GET / LIST / DELETE，签名为 AWS SigV4。
+// 环境变量: S3_ENDPOINT (MinIO 如 http://127.0.0.1:9000，留空则用 AWS)
+//           S3_REGION, S3_ACCESS_KEY_ID, S3_SECRET_ACCESS_KEY, S3_FORCE_PATH_STYLE
+
+type s3Storage struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	prefix    string
+	accessKey string
+	secretKey string
+	pathStyle bool
+	client    *http.Client
+}
+
+func newS3Storage(target string) (*s3Storage, error) {
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" { return nil, fmt.Errorf("S3 目标格式错误，应为 s3://bucket/prefix") }
+	s := &s3Storage{
+		region:    getEnv("S3_REGION", "us-east-1"),
+		bucket:    u.Host,
+		prefix:    strings.Trim(u.Path, "/"),
+		accessKey: getEnv("S3_ACCESS_KEY_ID", ""),
+		secretKey: getEnv("S3_SECRET_ACCESS_KEY", ""),
+		client:    &http.Client{Timeout: 5 * time.Minute},
+	}
+	if s.accessKey == "" || s.secretKey == "" { return nil, fmt.Errorf("缺少 S3_ACCESS_KEY_ID / S3_SECRET_ACCESS_KEY") }
+	if s.prefix != "" { s.prefix += "/" }
+
+	endpoint := getEnv("S3_ENDPOINT", "")
+	// 自建 MinIO 默认用路径风格，AWS 默认用虚拟主机风格
+	s.pathStyle = endpoint != ""
+	if v := getEnv("S3_FORCE_PATH_STYLE", ""); v != "" { s.pathStyle = isTruthy(v) }
+	if endpoint == "" { endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", s.region) }
+	if s.endpoint, err = url.Parse(endpoint); err != nil || s.endpoint.Host == "" { return nil, fmt.Errorf("S3_ENDPOINT 格式错误: %s", endpoint) }
+	return s, nil
+}
+
+func (s *s3Storage) String() string { return "s3://" + s.bucket + "/" + s.prefix }
+
+func (s *s3Storage) Put(name string, data []byte) error {
+	_, err := s.do("PUT", s.prefix+name, nil, data)
+	return err
+}
+
+func (s *s3Storage) Get(name string) ([]byte, error) { return s.do("GET", s.prefix+name, nil, nil) }
+
+func (s *s3Storage) Delete(name string) error {
+	_, err := s.do("DELETE", s.prefix+name, nil, nil)
+	return err
+}
+
+func (s *s3Storage) List() ([]string, error) {
+	var names []string
+	token := ""
+	for {
+		q := url.Values{"list-type": {"2"}, "prefix": {s.prefix}}
+		if token != "" { q.Set("continuation-token", token) }
+		body, err := s.do("GET", "", q, nil)
+		if err != nil { return nil, err }
+		var res struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		if err := xml.Unmarshal(body, &res); err != nil { return nil, fmt.Errorf("解析 S3 列表失败: %v", err) }
+		for _, c := range res.Contents {
+			name := strings.TrimPrefix(c.Key, s.prefix)
+			if name != "" && !strings.Contains(name, "/") { names = append(names, name) }
+		}
+		if !res.IsTruncated || res.NextContinuationToken == "" { return names, nil }
+		token = res.NextContinuationToken
+	}
+}
+
+func (s *s3Storage) do(method, key string, query url.Values, body []byte) ([]byte, error) {
+	u := *s.endpoint
+	path := strings.TrimSuffix(u.Path, "/")
+	if s.pathStyle {
+		path += "/" + s.bucket
+	} else {
+		u.Host = s.bucket + "." + u.Host
+	}
+	if key != "" { path += "/" + key } else if !s.pathStyle { path += "/" }
+	u.Path = path
+	u.RawPath = s3EscapePath(path)
+	u.RawQuery = s3CanonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil { return nil, err }
+	s.sign(req, body, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil { return nil, err }
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil { return nil, err }
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("S3 %s %s 返回 %d: %s", method, key, resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	return respBody, nil
+}
+
+func (s *s3Storage) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	canonicalRequest := strings.Join([]string{
+		req.Method, req.URL.EscapedPath(), req.URL.RawQuery, canonicalHeaders, signedHeaders, payloadHash,
+	}, "\n")
+
+	scope := day + "/" + s.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	k := hmacSHA256([]byte("AWS4"+s.secretKey), day)
+	k = hmacSHA256(k, s.region)
+	k = hmacSHA256(k, "s3")
+	k = hmacSHA256(k, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(k, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.accessKey, scope, signedHeaders, signature))
+}
+
+// SigV4 要求除 A-Z a-z 0-9 - _ . ~ 之外全部百分号编码
+func s3Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func s3EscapePath(p string) string {
+	parts := strings.Split(p, "/")
+	for i := range parts { parts[i] = s3Escape(parts[i]) }
+	return strings.Join(parts, "/")
+}
+
+func s3CanonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q { keys = append(keys, k) }
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range q[k] { parts = append(parts, s3Escape(k)+"="+s3Escape(v)) }
+	}
+	return strings.Join(parts, "&")
+}
+
+func sha256Hex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// ================= 数据文件版本与迁移 =================
+// 落盘格式: {"schema_version": N, "kind": "history", "records": [...]}
+// 早期版本直接是记录数组，视为第 1 版。
+// 迁移基于通用的 JSON 对象编写，不依赖当前结构体，结构体以后再改也不影响旧迁移。
+
+const currentSchemaVersion = 3
+
+const (
+	kindHistory  = "history"
+	kindMachines = "machines"
+)
+
+// 早期版本按服务器本地时间（北京时间）记录的时间格式
+const legacyTimeLayout = "2006-01-02 15:04:05"
+
+type dataFileEnvelope struct {
+	SchemaVersion int             `json:"schema_version"`
+	Kind          string          `json:"kind"`
+	Records       json.RawMessage `json:"records"`
+}
+
+type jsonRecord = map[string]interface{}
+
+type migration struct {
+	To          int
+	Description string
+	Kinds       []string // 为空表示适用于所有数据文件
+	Apply       func(kind string, records []jsonRecord) error
+}
+
+func (m migration) appliesTo(kind string) bool {
+	if len(m.Kinds) == 0 { return true }
+	for _, k := range m.Kinds {
+		if k == kind { return true }
+	}
+	return false
+}
+
+// 按版本顺序排列，To 必须连续
+var migrations = []migration{
+	{To: 2, Description: "时间字段转为 RFC 3339 UTC", Apply: migrateTimestampsToUTC},
+	{To: 3, Description: "历史记录增加唯一 ID", Kinds: []string{kindHistory}, Apply: migrateAddRecordIDs},
+}
+
+func migrateTimestampsToUTC(kind string, records []jsonRecord) error {
+	field := "generate_time"
+	if kind == kindMachines { field = "last_seen" }
+	for i, rec := range records {
+		s, _ := rec[field].(string)
+		if s == "" { continue }
+		t, err := parseTimestamp(s)
+		if err != nil { return fmt.Errorf("第 %d 条 %s 无法识别: %q", i+1, field, s) }
+		rec[field] = t.UTC().Format(time.RFC3339)
+	}
+	return nil
+}
+
+func migrateAddRecordIDs(kind string, records []jsonRecord) error {
+	for _, rec := range records {
+		if id, _ := rec["id"].(string); id == "" { rec["id"] = newRecordID() }
+	}
+	return nil
+}
+
+// migrationResult 描述一次读取时发生的升级，供日志和 dry-run 输出
+type migrationResult struct {
+	Kind    string
+	From    int
+	To      int
+	Steps   []string
+	Records int
+}
+
+func (m migrationResult) Migrated() bool { return m.From != m.To }
+
+// decodeDataFile 识别版本、执行迁移，并严格解码到 out（未知字段视为错误）
+func decodeDataFile(kind string, raw []byte, out interface{}) (migrationResult, error) {
+	res := migrationResult{Kind: kind, From: currentSchemaVersion, To: currentSchemaVersion}
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 { return res, nil }
+
+	var records []jsonRecord
+	if raw[0] == '[' {
+		res.From = 1
+		if err := json.Unmarshal(raw, &records); err != nil { return res, fmt.Errorf("%s 数据格式错误: %v", kind, err) }
+	} else {
+		var env dataFileEnvelope
+		if err := json.Unmarshal(raw, &env); err != nil { return res, fmt.Errorf("%s 数据格式错误: %v", kind, err) }
+		if env.Kind != "" && env.Kind != kind { return res, fmt.Errorf("数据类型不匹配: 期望 %s，实际 %s", kind, env.Kind) }
+		if env.SchemaVersion < 1 { return res, fmt.Errorf("%s 缺少 schema_version", kind) }
+		if env.SchemaVersion > currentSchemaVersion {
+			return res, fmt.Errorf("%s 数据版本 %d 高于程序支持的 %d，请升级程序", kind, env.SchemaVersion, currentSchemaVersion)
+		}
+		res.From = env.SchemaVersion
+		if len(env.Records) > 0 && string(env.Records) != "null" {
+			if err := json.Unmarshal(env.Records, &records); err != nil { return res, fmt.Errorf("%s 数据格式错误: %v", kind, err) }
+		}
+	}
+	res.Records = len(records)
+
+	for _, m := range migrations {
+		if m.To <= res.From || !m.appliesTo(kind) { continue }
+		if err := m.Apply(kind, records); err != nil { return res, fmt.Errorf("%s 迁移到 v%d 失败: %v", kind, m.To, err) }
+		res.Steps = append(res.Steps, fmt.Sprintf("v%d: %s", m.To, m.Description))
+	}
+
+	upgraded, err := json.Marshal(records)
+	if err != nil { return res, err }
+	dec := json.NewDecoder(bytes.NewReader(upgraded))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(out); err != nil { return res, fmt.Errorf("%s 数据与当前结构不一致: %v", kind, err) }
+	return res, nil
+}
+
+func encodeDataFile(kind string, records interface{}) ([]byte, error) {
+	body, err := json.Marshal(records)
+	if err != nil { return nil, err }
+	if string(body) == "null" { body = []byte("[]") }
+	return json.Marshal(dataFileEnvelope{SchemaVersion: currentSchemaVersion, Kind: kind, Records: body})
+}
+
+// loadDataFile 读取数据文件；文件不存在时返回 os.IsNotExist 错误
+func loadDataFile(path, kind string, out interface{}) (migrationResult, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil { return migrationResult{Kind: kind}, err }
+	return decodeDataFile(kind, raw, out)
+}
+
+// backupBeforeMigrate 升级前保留一份原文件，如 history.json.v1.bak
+func backupBeforeMigrate(path string, res migrationResult) {
+	raw, err := os.ReadFile(path)
+	if err != nil { return }
+	bak := fmt.Sprintf("%s.v%d.bak", path, res.From)
+	if err := writeFileAtomic(bak, raw, 0600); err != nil {
+		slog.Warn("⚠️ 备份迁移前的数据文件失败", "file", path, "err", err)
+		return
+	}
+	slog.Info("🔄 数据文件已升级", "file", path, "from", res.From, "to", res.To, "steps", strings.Join(res.Steps, "; "), "backup", bak)
+}
+
+// ================= 命令行: migrate =================
+
+// ./server migrate [-dry-run]
+func cmdMigrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "只显示将执行的迁移，不写文件")
+	fs.Parse(args)
+
+	var hist []HistoryRecord
+	var machines []MachineRecord
+	for _, f := range []struct {
+		path, kind string
+		out        interface{}
+	}{{historyFile, kindHistory, &hist}, {machineFile, kindMachines, &machines}} {
+		res, err := loadDataFile(f.path, f.kind, f.out)
+		if os.IsNotExist(err) { fmt.Printf("%s: 文件不存在，跳过\n", f.path); continue }
+		if err != nil { return err }
+		if !res.Migrated() { fmt.Printf("%s: 已是 v%d，无需迁移 (%d 条)\n", f.path, res.To, res.Records); continue }
+		fmt.Printf("%s: v%d -> v%d (%d 条)\n", f.path, res.From, res.To, res.Records)
+		for _, s := range res.Steps { fmt.Printf("  - %s\n", s) }
+	}
+	if *dryRun { return nil }
+
+	// safeLoadData 会在发现旧版本时自动备份并写回新格式
+	safeLoadData()
+	return nil
+}
+
+// ================= 时间工具 =================
+
+func nowTimestamp() string { return time.Now().UTC().Format(time.RFC3339) }
+
+// parseTimestamp 兼容 RFC 3339 与早期的本地时间格式
+func parseTimestamp(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil { return t, nil }
+	return time.ParseInLocation(legacyTimeLayout, s, bizLocation())
+}
+
+// displayTime 页面上按北京时间显示
+func displayTime(s string) string {
+	t, err := parseTimestamp(s)
+	if err != nil { return s }
+	return t.In(bizLocation()).Format(legacyTimeLayout)
+}
+
+// localDay 返回北京时间的日期，用于按天筛选和归档
+func localDay(s string) string {
+	t, err := parseTimestamp(s)
+	if err != nil {
+		if len(s) >= 10 { return s[:10] }
+		return s
+	}
+	return t.In(bizLocation()).Format("2006-01-02")
+}
+
+func newRecordID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	raw, err := os.ReadFile(filepath.Join("testdata", "schema", name))
+	if err != nil { t.Fatal(err) }
+	return raw
+}
+
+// 三个版本的 fixture 是同一批数据，迁移到当前版本后时间、内容一致
+func TestMigrateHistoryFixtures(t *testing.T) {
+	for _, tc := range []struct {
+		file  string
+		from  int
+		steps int
+	}{{"history_v1.json", 1, 2}, {"history_v2.json", 2, 1}, {"history_v3.json", 3, 0}} {
+		var list []HistoryRecord
+		res, err := decodeDataFile(kindHistory, readFixture(t, tc.file), &list)
+		if err != nil { t.Fatalf("%s: %v", tc.file, err) }
+		if res.From != tc.from || res.To != currentSchemaVersion || len(res.Steps) != tc.steps || res.Records != 2 { t.Errorf("%s: 迁移结果 %+v", tc.file, res) }
+		if len(list) != 2 { t.Fatalf("%s: %d 条", tc.file, len(list)) }
+		if list[0].GenerateTime != "2024-03-01T02:00:00Z" || list[1].GenerateTime != "2024-03-02T00:30:15Z" { t.Errorf("%s: 时间 %q %q", tc.file, list[0].GenerateTime, list[1].GenerateTime) }
+		if list[0].MachineID != "MID-AAA" || list[1].ExpiryDate != "2024-04-01" { t.Errorf("%s: 内容 %+v", tc.file, list) }
+		if list[0].ID == "" || list[1].ID == "" || list[0].ID == list[1].ID { t.Errorf("%s: ID %q %q", tc.file, list[0].ID, list[1].ID) }
+	}
+}
+
+func TestMigrateMachineFixtures(t *testing.T) {
+	for _, file := range []string{"machines_v1.json", "machines_v2.json", "machines_v3.json"} {
+		var list []MachineRecord
+		if _, err := decodeDataFile(kindMachines, readFixture(t, file), &list); err != nil { t.Fatalf("%s: %v", file, err) }
+		if len(list) != 2 || list[0].LastSeen != "2024-03-01T02:00:00Z" || list[1].LastSeen != "2024-03-02T00:30:15Z" { t.Errorf("%s: %+v", file, list) }
+	}
+}
+
+// 迁移后写回、再读，内容不变且不再迁移
+func TestDataFileRoundTrip(t *testing.T) {
+	for _, file := range []string{"history_v1.json", "history_v2.json", "history_v3.json"} {
+		var list []HistoryRecord
+		if _, err := decodeDataFile(kindHistory, readFixture(t, file), &list); err != nil { t.Fatal(err) }
+		raw, err := encodeDataFile(kindHistory, list)
+		if err != nil { t.Fatal(err) }
+		var again []HistoryRecord
+		res, err := decodeDataFile(kindHistory, raw, &again)
+		if err != nil { t.Fatal(err) }
+		if res.Migrated() { t.Errorf("%s: 写回后仍需迁移 %+v", file, res) }
+		if !reflect.DeepEqual(list, again) { t.Errorf("%s: 往返后不一致\n%+v\n%+v", file, list, again) }
+	}
+}
+
+func TestDecodeDataFileRejects(t *testing.T) {
+	var list []HistoryRecord
+	cases := map[string]string{
+		"kind":    `{"schema_version": 3, "kind": "machines", "records": []}`,
+		"newer":   `{"schema_version": 99, "kind": "history", "records": []}`,
+		"version": `{"kind": "history", "records": []}`,
+		"unknown": `{"schema_version": 3, "kind": "history", "records": [{"id": "x", "bogus": 1}]}`,
+		"time":    `[{"generate_time": "yesterday"}]`,
+	}
+	for name, raw := range cases {
+		if _, err := decodeDataFile(kindHistory, []byte(raw), &list); err == nil { t.Errorf("%s: 应该报错", name) }
+	}
+}
+
+// ================= 字段加密 =================
+
+// 测试专用密钥，只用于 testdata/schema/history_v3_encrypted.json
+var (
+	testDataKeyOld = base64.StdEncoding.EncodeToString([]byte("old-test-key-0123456789abcdefghi"))
+	testDataKey    = base64.StdEncoding.EncodeToString([]byte("new-test-key-0123456789abcdefghi"))
+	testIndexKey   = base64.StdEncoding.EncodeToString([]byte("index-test-key-0123456789abcdefg"))
+)
+
+func withDataKeys(t *testing.T, primary string, encryptMachineID bool) {
+	t.Helper()
+	kr := &keyring{primary: primary, keys: map[string][]byte{}}
+	if err := kr.add("k1", testDataKeyOld); err != nil { t.Fatal(err) }
+	if err := kr.add("k2", testDataKey); err != nil { t.Fatal(err) }
+	var err error
+	if kr.indexKey, err = decodeKey(testIndexKey); err != nil { t.Fatal(err) }
+	savedKeys, savedFlag := dataKeys, EncryptMachineID
+	dataKeys, EncryptMachineID = kr, encryptMachineID
+	t.Cleanup(func() { dataKeys, EncryptMachineID = savedKeys, savedFlag })
+}
+
+func TestEncryptedHistoryRoundTrip(t *testing.T) {
+	withDataKeys(t, "k2", true)
+	var plain []HistoryRecord
+	if _, err := decodeDataFile(kindHistory, readFixture(t, "history_v3.json"), &plain); err != nil { t.Fatal(err) }
+
+	disk, err := encodeHistoryForDisk(plain)
+	if err != nil { t.Fatal(err) }
+	for i, rec := range disk {
+		if !strings.HasPrefix(rec.LicenseCode, encPrefix+"k2:") || !strings.HasPrefix(rec.MachineID, encPrefix+"k2:") { t.Errorf("第 %d 条没有用主密钥加密: %+v", i, rec) }
+		if rec.MachineIDIndex != machineBlindIndex(plain[i].MachineID) { t.Errorf("第 %d 条盲索引不一致", i) }
+	}
+	if plain[0].LicenseCode == disk[0].LicenseCode { t.Fatal("encodeHistoryForDisk 不应修改内存中的记录") }
+
+	raw, err := encodeDataFile(kindHistory, disk)
+	if err != nil { t.Fatal(err) }
+	var back []HistoryRecord
+	if _, err := decodeDataFile(kindHistory, raw, &back); err != nil { t.Fatal(err) }
+	if err := decodeHistoryFromDisk(back); err != nil { t.Fatal(err) }
+	if !reflect.DeepEqual(plain, back) { t.Fatalf("加密往返后不一致\n%+v\n%+v", plain, back) }
+}
+
+func TestEncryptedMachinesRoundTrip(t *testing.T) {
+	withDataKeys(t, "k2", true)
+	var plain []MachineRecord
+	if _, err := decodeDataFile(kindMachines, readFixture(t, "machines_v3.json"), &plain); err != nil { t.Fatal(err) }
+	disk, err := encodeMachinesForDisk(plain)
+	if err != nil { t.Fatal(err) }
+	back := append([]MachineRecord(nil), disk...)
+	if err := decodeMachinesFromDisk(back); err != nil { t.Fatal(err) }
+	if !reflect.DeepEqual(plain, back) { t.Fatalf("加密往返后不一致\n%+v\n%+v", plain, back) }
+}
+
+func TestBlindIndex(t *testing.T) {
+	withDataKeys(t, "k2", true)
+	a, b := machineBlindIndex("MID-AAA"), machineBlindIndex("MID-BBB")
+	if a == "" || a != machineBlindIndex("MID-AAA") || a == b { t.Fatalf("盲索引应确定且区分机器码: %q %q", a, b) }
+	// 盲索引只取决于索引密钥，换主密钥不影响查找
+	withDataKeys(t, "k1", true)
+	if machineBlindIndex("MID-AAA") != a { t.Fatal("换主密钥后盲索引变了") }
+}
+
+// history_v3_encrypted.json 由旧密钥 k1 加密，主密钥换成 k2 后仍能解密
+func TestEncryptedFixtureAfterKeyRotation(t *testing.T) {
+	withDataKeys(t, "k2", true)
+	var list []HistoryRecord
+	if _, err := decodeDataFile(kindHistory, readFixture(t, "history_v3_encrypted.json"), &list); err != nil { t.Fatal(err) }
+	if fieldKeyID(list[0].LicenseCode) != "k1" { t.Fatalf("fixture 应由 k1 加密: %q", list[0].LicenseCode) }
+	if list[0].MachineIDIndex != machineBlindIndex("MID-AAA") { t.Fatal("fixture 的盲索引与当前算法不一致") }
+	if err := decodeHistoryFromDisk(list); err != nil { t.Fatal(err) }
+	if list[0].LicenseCode != "H4sIAAAAAAAA-v3-aaa" || list[0].MachineID != "MID-AAA" || list[1].MachineID != "MID-BBB" { t.Fatalf("解密结果 %+v", list) }
+
+	delete(dataKeys.keys, "k1")
+	var again []HistoryRecord
+	decodeDataFile(kindHistory, readFixture(t, "history_v3_encrypted.json"), &again)
+	if err := decodeHistoryFromDisk(again); err == nil { t.Fatal("缺少旧密钥时应报错") }
+}
+
+func TestDecryptFieldBindsFieldName(t *testing.T) {
+	withDataKeys(t, "k2", false)
+	enc, err := encryptField("license_code", "secret")
+	if err != nil { t.Fatal(err) }
+	if _, err := decryptField("machine_id", enc); err == nil { t.Fatal("换字段名解密应失败") }
+	if got, err := decryptField("license_code", enc); err != nil || got != "secret" { t.Fatalf("解密 %q %v", got, err) }
+	if got, _ := decryptField("license_code", "plain"); got != "plain" { t.Fatal("明文应原样返回") }
+}
+
+func TestWriteDataFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "history.json")
+	want := []HistoryRecord{{ID: "h1", GenerateTime: "2024-03-01T02:00:00Z", MachineID: "M", ExpiryDate: "2025-03-01", LicenseCode: "C"}}
+	if err := writeDataFile(path, kindHistory, want); err != nil { t.Fatal(err) }
+	var got []HistoryRecord
+	if _, err := loadDataFile(path, kindHistory, &got); err != nil || !reflect.DeepEqual(got, want) { t.Fatalf("读回 %+v %v", got, err) }
+	if fi, _ := os.Stat(path); fi.Mode().Perm() != 0600 { t.Errorf("权限 %v，期望 0600", fi.Mode().Perm()) }
+	if err := writeDataFile(filepath.Join(dir, "missing", "history.json"), kindHistory, want); err == nil { t.Fatal("目录不存在时应返回错误") }
+	if left, _ := filepath.Glob(filepath.Join(dir, ".history.json.tmp-*")); len(left) != 0 { t.Fatalf("残留临时文件 %v", left) }
+}
+
+func TestEncryptFieldEncryptsPrefixedPlaintext(t *testing.T) {
+	withDataKeys(t, "k2", false)
+	plain := "enc:v1:k2:AAAA"
+	enc, err := encryptField("license_code", plain)
+	if err != nil || enc == plain { t.Fatalf("带 enc: 前缀的明文也应加密，得到 %q %v", enc, err) }
+	if got, err := decryptField("license_code", enc); err != nil || got != plain { t.Fatalf("解密 %q %v", got, err) }
+}
+
+func TestRejectEncPrefixedMachineID(t *testing.T) {
+	for _, id := range []string{"enc:v1:k2:AAAA", "enc:x", "M\n1", "M\x00"} {
+		if _, err := generateLicenseCore(id, "2999-01-01"); err == nil { t.Errorf("机器码 %q 应被拒绝", id) }
+	}
+	withStore(t, nil, nil)
+	rep := &importReport{Mode: "merge"}
+	if st := importHistory(rep, []HistoryRecord{{MachineID: "enc:v1:k2:AAAA", LicenseCode: "C", GenerateTime: "2024-03-01T02:00:00Z", ExpiryDate: "2025-03-01"}}); st != 422 || rep.Invalid != 1 { t.Fatalf("导入应拒绝，状态 %d 报告 %+v", st, rep) }
+	rep = &importReport{Mode: "merge"}
+	if st := importHistory(rep, []HistoryRecord{{MachineID: "M", LicenseCode: "enc:v1:k2:AAAA", GenerateTime: "2024-03-01T02:00:00Z", ExpiryDate: "2025-03-01"}}); st != 422 { t.Fatalf("license_code 带 enc: 前缀应被拒绝，状态 %d", st) }
+	rep = &importReport{Mode: "merge", DryRun: true}
+	if st := importMachines(rep, []MachineRecord{{MachineID: "enc:v1:x", LastSeen: "2024-03-01T02:00:00Z"}}); st != 422 { t.Fatalf("机器导入应拒绝，状态 %d", st) }
+}
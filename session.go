@@ -0,0 +1,279 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// ================= 登录会话 =================
+// 页面不再通过 URL 传 Token：登录后签发 HttpOnly Cookie，会话保存在服务端内存中。
+// 所有非 GET 请求都要带上 CSRF Token（请求头 X-CSRF-Token 或表单字段 csrf_token）。
+
+const sessionCookieName = "jhm_session"
+
+var (
+	SessionIdleTimeout = newReloadable(getEnvDuration("SESSION_IDLE_TIMEOUT", 30*time.Minute))
+	SessionMaxAge      = newReloadable(getEnvDuration("SESSION_MAX_AGE", 12*time.Hour))
+	// 空值表示自动判断（直连 TLS 或受信代理声明了 https）
+	SessionCookieSecure = getEnv("SESSION_COOKIE_SECURE", "")
+)
+
+type session struct {
+	ID        string
+	TokenID   string // 使用 API Token 登录时
+	UserID    string // 使用用户名密码登录时
+	CSRFToken string
+	CreatedAt time.Time
+	LastSeen  time.Time
+
+	MFAPending    bool      // 密码已通过，等待输入两步验证码
+	MFAVerifiedAt time.Time // 最近一次输入验证码的时间，敏感操作据此判断是否需要再次验证
+	OTPFailures   int
+}
+
+var (
+	sessions     = map[string]*session{}
+	sessionMutex sync.Mutex
+)
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func newSession(p *principal) *session {
+	now := time.Now()
+	s := &session{ID: randomString(32), CSRFToken: randomString(32), CreatedAt: now, LastSeen: now}
+	if p.User != nil { s.UserID = p.User.ID } else { s.TokenID = p.Token.ID }
+	sessionMutex.Lock()
+	sessions[s.ID] = s
+	sessionMutex.Unlock()
+	return s
+}
+
+func (s *session) expired(now time.Time) bool {
+	return now.Sub(s.LastSeen) > SessionIdleTimeout.Get() || now.Sub(s.CreatedAt) > SessionMaxAge.Get()
+}
+
+// currentSession 读取并续期 Cookie 对应的会话，过期的会话会被删除
+func currentSession(r *http.Request) *session {
+	c, err := r.Cookie(sessionCookieName)
+	if err != nil || c.Value == "" { return nil }
+	now := time.Now()
+	sessionMutex.Lock(); defer sessionMutex.Unlock()
+	s, ok := sessions[c.Value]
+	if !ok { return nil }
+	if s.expired(now) { delete(sessions, s.ID); return nil }
+	s.LastSeen = now
+	return s
+}
+
+func (s *session) mfaFresh(now time.Time) bool {
+	sessionMutex.Lock(); defer sessionMutex.Unlock()
+	return !s.MFAVerifiedAt.IsZero() && now.Sub(s.MFAVerifiedAt) <= TOTPStepUpWindow.Get()
+}
+
+func markMFAVerified(s *session) {
+	sessionMutex.Lock()
+	s.MFAVerifiedAt = time.Now()
+	sessionMutex.Unlock()
+}
+
+// completeMFA 通过两步验证后换发新的会话 ID，旧的待验证会话作废
+func completeMFA(old *session) *session {
+	now := time.Now()
+	s := &session{ID: randomString(32), UserID: old.UserID, CSRFToken: randomString(32), CreatedAt: now, LastSeen: now, MFAVerifiedAt: now}
+	sessionMutex.Lock()
+	delete(sessions, old.ID)
+	sessions[s.ID] = s
+	sessionMutex.Unlock()
+	return s
+}
+
+// recordOTPFailure 累计输错次数，超过上限时作废会话并返回 true
+func recordOTPFailure(s *session) bool {
+	sessionMutex.Lock(); defer sessionMutex.Unlock()
+	s.OTPFailures++
+	if s.OTPFailures < maxOTPFailures { return false }
+	delete(sessions, s.ID)
+	return true
+}
+
+func destroySession(id string) {
+	sessionMutex.Lock()
+	delete(sessions, id)
+	sessionMutex.Unlock()
+}
+
+// destroySessionsForToken Token 被吊销时踢掉用它登录的会话
+func destroySessionsForToken(tokenID string) {
+	sessionMutex.Lock(); defer sessionMutex.Unlock()
+	for id, s := range sessions {
+		if s.TokenID == tokenID { delete(sessions, id) }
+	}
+}
+
+// destroySessionsForUser 用户被禁用、改角色或改密码时踢掉其会话
+func destroySessionsForUser(userID string) {
+	sessionMutex.Lock(); defer sessionMutex.Unlock()
+	for id, s := range sessions {
+		if s.UserID == userID { delete(sessions, id) }
+	}
+}
+
+func startSessionSweeper() {
+	go func() {
+		for range time.Tick(5 * time.Minute) {
+			now := time.Now()
+			sessionMutex.Lock()
+			for id, s := range sessions {
+				if s.expired(now) { delete(sessions, id) }
+			}
+			sessionMutex.Unlock()
+		}
+	}()
+}
+
+func cookieSecure(r *http.Request) bool {
+	if SessionCookieSecure != "" { return isTruthy(SessionCookieSecure) }
+	if r.TLS != nil { return true }
+	return TrustProxyHeaders && r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+func setSessionCookie(w http.ResponseWriter, r *http.Request, s *session) {
+	http.SetCookie(w, &http.Cookie{
+		Name: sessionCookieName, Value: s.ID, Path: "/", HttpOnly: true,
+		Secure: cookieSecure(r), SameSite: http.SameSiteLaxMode, MaxAge: int(SessionMaxAge.Get().Seconds()),
+	})
+}
+
+func clearSessionCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: sessionCookieName, Value: "", Path: "/", HttpOnly: true, Secure: cookieSecure(r), SameSite: http.SameSiteLaxMode, MaxAge: -1})
+}
+
+func isSafeMethod(method string) bool {
+	return method == "GET" || method == "HEAD" || method == "OPTIONS"
+}
+
+func validCSRF(r *http.Request, s *session) bool {
+	got := r.Header.Get("X-CSRF-Token")
+	// multipart 表单（导入）要在鉴权之后才解析，只认请求头
+	if got == "" && !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") { got = r.FormValue("csrf_token") }
+	return got != "" && subtle.ConstantTimeCompare([]byte(got), []byte(s.CSRFToken)) == 1
+}
+
+// ================= 页面辅助 =================
+
+// csrfMeta 嵌入页面 <head>，前端脚本从这里读取 CSRF Token
+func csrfMeta(s *session) string {
+	return `<meta name="csrf-token" content="` + s.CSRFToken + `">`
+}
+
+// 页面脚本公用：const CSRF、带 CSRF 头的 send，以及 JSON POST。
+// 敏感操作返回 X-Step-Up 时弹窗要求输入两步验证码并重试一次。
+const csrfJS = `<script>const CSRF=document.querySelector('meta[name=csrf-token]').content;
+async function send(u,o){o.headers=Object.assign({'X-CSRF-Token':CSRF},o.headers||{});var r=await fetch(u,o);if(r.status===401&&r.headers.get('X-Step-Up')){var c=prompt('该操作需要两步验证，请输入验证码');if(!c)return r;o.headers['X-OTP-Code']=c;r=await fetch(u,o)}return r}
+function postJSON(u,b){return send(u,{method:'POST',headers:{'Content-Type':'application/json'},body:JSON.stringify(b)})}</script>`
+
+// navLinks 页面右上角的导航与退出按钮
+func navLinks(p *principal) string {
+	who := html.EscapeString(p.Name)
+	if p.Role != "" { who += " (" + p.Role + ")" }
+	if p.User != nil { who += ` · <a href="/account" style="color:#0071e3;text-decoration:none">两步验证</a>` }
+	return fmt.Sprintf(`<span style="font-size:13px;font-weight:normal;color:#888">%s · <a href="/" style="color:#0071e3;text-decoration:none">首页</a> · <a href="#" onclick="fetch('/logout',{method:'POST',headers:{'X-CSRF-Token':CSRF}}).then(()=>location.href='/login');return false" style="color:#0071e3;text-decoration:none">退出</a></span>`, who)
+}
+
+// ================= 登录 / 退出 =================
+
+// safeNext 登录后的跳转地址只允许站内路径，其他一律回首页。
+// 浏览器会把 \ 当成 /，所以 /\evil.com 和 //evil.com 一样是站外地址
+func safeNext(next string) string {
+	if strings.ContainsRune(next, '\\') || strings.ContainsFunc(next, unicode.IsControl) { return "/" }
+	u, err := url.Parse(next)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil { return "/" }
+	if !strings.HasPrefix(u.Path, "/") || strings.HasPrefix(u.Path, "//") { return "/" }
+	return next
+}
+
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	next := safeNext(r.FormValue("next"))
+
+	errMsg := ""
+	if r.Method == "POST" {
+		username := r.FormValue("username")
+		if !checkLockout(w, r, username) { return }
+		p, err := loginPrincipal(r)
+		if err != nil { recordAuthFailure(r, username, "登录: "+err.Error()) } else { recordAuthSuccess(r, username) }
+		if err == nil && p.User != nil && p.User.totpEnabled() {
+			s := newSession(p)
+			s.MFAPending = true
+			setSessionCookie(w, r, s)
+			http.Redirect(w, r, "/login/otp?next="+url.QueryEscape(next), http.StatusSeeOther)
+			return
+		}
+		if err == nil {
+			s := newSession(p)
+			setSessionCookie(w, r, s)
+			slog.InfoContext(r.Context(), "👤 已登录", "username", p.Name, "ip", clientIP(r).String())
+			http.Redirect(w, r, next, http.StatusSeeOther)
+			return
+		}
+		errMsg = err.Error()
+	}
+	status := 200
+	if errMsg != "" { status = 401 }
+	renderLoginPage(w, next, errMsg, status)
+}
+
+// renderLoginPage 登录表单；启用了 OIDC 时显示 SSO 按钮
+func renderLoginPage(w http.ResponseWriter, next, errMsg string, status int) {
+	sso := ""
+	if oidcEnabled() {
+		sso = `<a href="/login/oidc?next=` + url.QueryEscape(next) + `" style="display:block;text-align:center;padding:12px;margin-bottom:20px;border:1px solid #0071e3;border-radius:6px;color:#0071e3;text-decoration:none">使用 SSO 登录</a>`
+	}
+	page := fmt.Sprintf(`<!DOCTYPE html><html><head><meta charset="UTF-8"><meta name="viewport" content="width=device-width,initial-scale=1.0"><title>登录</title>
+	<style>body{font-family:-apple-system,sans-serif;max-width:400px;margin:80px auto;padding:20px;background:#f5f5f7}.card{background:white;padding:30px;border-radius:12px;box-shadow:0 4px 12px rgba(0,0,0,0.1)}input{width:100%%;padding:10px;margin:5px 0 15px;box-sizing:border-box;border:1px solid #ccc;border-radius:6px}button{width:100%%;padding:12px;background:#0071e3;color:white;border:none;border-radius:6px;cursor:pointer}.err{color:#ff3b30;font-size:13px;margin-bottom:10px}</style></head><body>
+	<div class="card"><h2>🔐 登录</h2>%s%s<form method="POST" action="/login"><input type="hidden" name="next" value="%s"><label>用户名</label><input type="text" name="username" autofocus autocomplete="username"><label>密码</label><input type="password" name="password" autocomplete="current-password"><details style="margin-bottom:15px;font-size:13px;color:#888"><summary>使用 API Token 登录</summary><input type="password" name="token" autocomplete="off"></details><button type="submit">登录</button></form></div></body></html>`,
+		loginError(errMsg), sso, html.EscapeString(next))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write([]byte(page))
+}
+
+// loginPrincipal 填了用户名时按密码登录，否则按 API Token 登录
+func loginPrincipal(r *http.Request) (*principal, error) {
+	if username := r.FormValue("username"); username != "" {
+		u, err := authenticatePassword(username, r.FormValue("password"))
+		if err != nil { return nil, err }
+		return userPrincipal(u), nil
+	}
+	t, err := authenticateToken(r, r.FormValue("token"))
+	if err != nil { return nil, err }
+	return tokenPrincipal(t), nil
+}
+
+func loginError(msg string) string {
+	if msg == "" { return "" }
+	return `<div class="err">` + html.EscapeString(msg) + `</div>`
+}
+
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" { http.Error(w, "Method Not Allowed", 405); return }
+	if s := currentSession(r); s != nil {
+		if !validCSRF(r, s) { http.Error(w, "CSRF 校验失败", 403); return }
+		destroySession(s.ID)
+	}
+	clearSessionCookie(w, r)
+	w.Write([]byte("✅ 已退出"))
+}
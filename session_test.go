@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestSafeNext(t *testing.T) {
+	cases := map[string]string{
+		"":                    "/",
+		"/":                   "/",
+		"/history?page=2":     "/history?page=2",
+		"/machines#top":       "/machines#top",
+		"history":             "/",
+		"//evil.com":          "/",
+		"/\\evil.com":         "/",
+		"\\\\evil.com":        "/",
+		"/%2Fevil.com":        "/",
+		"https://evil.com":    "/",
+		"javascript:alert(1)": "/",
+		"/\tevil":             "/",
+		"/\r\nSet-Cookie: x":  "/",
+		"///evil.com":         "/",
+	}
+	for in, want := range cases {
+		if got := safeNext(in); got != want { t.Errorf("safeNext(%q) = %q，期望 %q", in, got, want) }
+	}
+}
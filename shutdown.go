@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ================= 优雅退出 =================
+// 收到 SIGTERM / SIGINT（Render 重新部署时先发 SIGTERM，约 30 秒后强杀）按顺序：
+//  1. /health 返回 503、gRPC 健康检查置为 NOT_SERVING，不再接受新连接
+//  2. 等待进行中的 HTTP / gRPC 请求结束
+//  3. 等待后台任务（定时备份、归档清理、Webhook 投递）当前这一轮写完，之后不再开始新的一轮
+//  4. 等待还没发完的 Telegram 推送
+//  5. 关闭数据存储：等正在进行的写入完成，之后的写入一律阻塞直到进程退出
+// 以上共用 SHUTDOWN_TIMEOUT 的期限，超时后放弃剩余步骤直接退出。没投递完的 Webhook 留在队列里，重启后继续。
+// 期间再收到一次信号立即退出。
+
+var ShutdownTimeout = getEnvDuration("SHUTDOWN_TIMEOUT", 25*time.Second)
+
+// HTTP 超时：导入 / 导出 Excel 的请求体和响应较大，读写超时放宽
+const (
+	httpReadHeaderTimeout = 10 * time.Second
+	httpReadTimeout       = time.Minute
+	httpWriteTimeout      = 2 * time.Minute
+	httpIdleTimeout       = 2 * time.Minute
+)
+
+var (
+	shutdownCh     = make(chan struct{}) // 开始退出时关闭
+	jobMutex       sync.Mutex
+	jobsClosed     bool
+	backgroundJobs sync.WaitGroup
+)
+
+func shuttingDown() bool {
+	select {
+	case <-shutdownCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// beginJob 后台任务每一轮开始前调用，已经在退出时返回 false；返回 true 时结束后必须调用 endJob
+func beginJob() bool {
+	jobMutex.Lock(); defer jobMutex.Unlock()
+	if jobsClosed { return false }
+	backgroundJobs.Add(1)
+	return true
+}
+
+func endJob() { backgroundJobs.Done() }
+
+// runServer 启动 HTTP 服务并阻塞到退出完成
+func runServer(addr string, handler http.Handler) {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: httpReadHeaderTimeout,
+		ReadTimeout:       httpReadTimeout,
+		WriteTimeout:      httpWriteTimeout,
+		IdleTimeout:       httpIdleTimeout,
+	}
+	errCh := make(chan error, 1)
+	go func() { errCh <- listen(srv) }()
+
+	sig := make(chan os.Signal, 2)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+	select {
+	case err := <-errCh:
+		log.Fatalf(">>> ❌ 致命错误: %v", err)
+	case s := <-sig:
+		slog.Info(">>> 🛑 收到信号，开始退出", "signal", s.String(), "timeout", ShutdownTimeout)
+	}
+	go func() {
+		s := <-sig
+		slog.Warn(">>> ⚠️ 再次收到信号，立即退出", "signal", s.String())
+		os.Exit(1)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	shutdown(ctx, srv)
+	if err := <-errCh; err != nil && !errors.Is(err, http.ErrServerClosed) { slog.Warn("⚠️ HTTP 服务退出", "err", err) }
+	slog.Info(">>> 👋 已退出")
+}
+
+func shutdown(ctx context.Context, srv *http.Server) {
+	jobMutex.Lock()
+	jobsClosed = true
+	close(shutdownCh)
+	jobMutex.Unlock()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if err := srv.Shutdown(ctx); err != nil { slog.Warn("⚠️ 等待 HTTP 请求结束超时", "err", err) }
+	}()
+	go func() { defer wg.Done(); stopGRPCServer(ctx) }()
+	wg.Wait()
+
+	if !waitUntil(ctx, backgroundJobs.Wait) { slog.Warn("⚠️ 等待后台任务超时，未等待完成") }
+	if !waitUntil(ctx, telegramPending.Wait) { slog.Warn("⚠️ Telegram 推送超时，未等待完成") }
+	if !waitUntil(ctx, closeStore) { slog.Warn("⚠️ 等待数据写入超时，未等待完成") }
+}
+
+// waitUntil 在 ctx 到期前等 fn 返回
+func waitUntil(ctx context.Context, fn func()) bool {
+	done := make(chan struct{})
+	go func() { fn(); close(done) }()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// closeStore 按固定顺序拿到所有数据文件的锁且不再释放：正在进行的写入完成后，之后的写入都阻塞到进程退出。
+// 数据在每次修改时已经落盘，这里不再重写文件（加载失败时内存里是空的，重写反而会覆盖原文件）。
+func closeStore() {
+	customerMutex.Lock()
+	mutex.Lock()
+	webhookMutex.Lock()
+	tokenMutex.Lock()
+	userMutex.Lock()
+	slog.Info("💾 数据存储已关闭")
+}
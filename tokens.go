@@ -0,0 +1,411 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ================= API Token =================
+// 每个 Token 有名称、权限范围、可选的过期时间、最长授权天数和 IP 白名单。
+// 只保存密钥的 SHA-256，明文只在创建时返回一次。
+// 环境变量 SECURITY_TOKEN 作为内置的管理员 Token 继续可用（名称 SECURITY_TOKEN）。
+
+const (
+	scopeGenerate    = "generate"
+	scopeReadHistory = "read-history"
+	scopeDelete      = "delete"
+	scopeAdmin       = "admin" // 拥有全部权限，并可管理 Token
+	scopeSetup       = "setup"
+)
+
+var allScopes = []string{scopeGenerate, scopeReadHistory, scopeDelete, scopeAdmin, scopeSetup}
+
+var (
+	tokensFile        = "tokens.json"
+	TrustProxyHeaders = isTruthy(getEnv("TRUST_PROXY_HEADERS", ""))
+)
+
+type APIToken struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	SecretHash string   `json:"secret_hash"`
+	Prefix     string   `json:"prefix"` // 密钥前几位，方便辨认
+	Scopes     []string `json:"scopes"`
+	CreatedAt  string   `json:"created_at"`
+	ExpiresAt  string   `json:"expires_at,omitempty"`
+	MaxDays    int      `json:"max_days,omitempty"`    // 生成激活码的最长有效天数，0 为不限制
+	AllowedIPs []string `json:"allowed_ips,omitempty"` // IP 或 CIDR，为空不限制
+	RevokedAt  string   `json:"revoked_at,omitempty"`
+	LastUsedAt string   `json:"last_used_at,omitempty"`
+
+	// 机器间调用，见 machineauth.go
+	ClientCertSubjects []string `json:"client_cert_subjects,omitempty"` // 客户端证书的 CN 或 SAN
+	HMACSecret         string   `json:"hmac_secret,omitempty"`          // 请求签名密钥，加密保存
+}
+
+var (
+	tokenList  []APIToken
+	tokenMutex sync.Mutex
+)
+
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope || s == scopeAdmin { return true }
+	}
+	return false
+}
+
+func (t *APIToken) Status(now time.Time) string {
+	if t.RevokedAt != "" { return "revoked" }
+	if t.ExpiresAt != "" {
+		if exp, err := parseTimestamp(t.ExpiresAt); err == nil && now.After(exp) { return "expired" }
+	}
+	return "active"
+}
+
+func (t *APIToken) AllowsIP(ip net.IP) bool {
+	if len(t.AllowedIPs) == 0 { return true }
+	if ip == nil { return false }
+	for _, entry := range t.AllowedIPs {
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if cidr.Contains(ip) { return true }
+		} else if allowed := net.ParseIP(entry); allowed != nil && allowed.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// 内置的 SECURITY_TOKEN，不落盘
+var legacyToken = APIToken{ID: "legacy", Name: "SECURITY_TOKEN", Scopes: []string{scopeAdmin}}
+
+func loadTokens() {
+	tokenMutex.Lock(); defer tokenMutex.Unlock()
+	res, err := loadDataFile(tokensFile, "tokens", &tokenList)
+	if os.IsNotExist(err) { return }
+	if err != nil { log.Fatalf(">>> ❌ %v", err) }
+	if res.Migrated() { persistTokens() }
+	slog.Info("🔑 已加载 API Token", "count", len(tokenList))
+}
+
+// 调用方需持有 tokenMutex
+func persistTokens() {
+	data, err := encodeDataFile("tokens", tokenList)
+	if err != nil { slog.Error("❌ 序列化 Token 失败", "err", err); return }
+	if err := writeFileAtomic(tokensFile, data, 0600); err != nil { slog.Error("❌ 保存 Token 失败", "err", err) }
+}
+
+func hashSecret(secret string) string { return sha256Hex([]byte(secret)) }
+
+func newTokenSecret() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return "jhm_" + base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ================= 鉴权 =================
+
+type authError struct {
+	Status  int
+	Message string
+}
+
+func (e *authError) Error() string { return e.Message }
+
+// authenticateToken 校验密钥本身（是否存在、过期、吊销、来源 IP），不检查权限范围
+func authenticateToken(r *http.Request, secret string) (*APIToken, error) {
+	if secret == "" { return nil, errNoCredentials }
+	if SecurityToken != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(SecurityToken)) == 1 {
+		t := legacyToken
+		return &t, nil
+	}
+
+	hash := hashSecret(secret)
+	now := time.Now()
+	tokenMutex.Lock(); defer tokenMutex.Unlock()
+	for i := range tokenList {
+		t := &tokenList[i]
+		if subtle.ConstantTimeCompare([]byte(t.SecretHash), []byte(hash)) != 1 { continue }
+		if err := t.usableFrom(r, now); err != nil { return nil, err }
+		t.touch(now)
+		found := *t
+		return &found, nil
+	}
+	return nil, &authError{401, "Token 错误"}
+}
+
+// touch 更新最近使用时间，每分钟最多落盘一次；调用方需持有 tokenMutex
+func (t *APIToken) touch(now time.Time) {
+	last, _ := parseTimestamp(t.LastUsedAt)
+	t.LastUsedAt = now.UTC().Format(time.RFC3339)
+	if now.Sub(last) > time.Minute { persistTokens() }
+}
+
+func (t *APIToken) usableFrom(r *http.Request, now time.Time) error {
+	switch t.Status(now) {
+	case "revoked":
+		return &authError{401, "Token 已吊销"}
+	case "expired":
+		return &authError{401, "Token 已过期"}
+	}
+	if !t.AllowsIP(clientIP(r)) { return &authError{403, "当前 IP 不在该 Token 的白名单内"} }
+	return nil
+}
+
+// sessionToken 每次请求都重新检查登录所用的 Token，吊销或过期后会话立即失效
+func sessionToken(r *http.Request, s *session) (*APIToken, error) {
+	if s.TokenID == legacyToken.ID {
+		if SecurityToken == "" { return nil, &authError{401, "会话已失效"} }
+		t := legacyToken
+		return &t, nil
+	}
+	tokenMutex.Lock(); defer tokenMutex.Unlock()
+	for i := range tokenList {
+		if tokenList[i].ID != s.TokenID { continue }
+		if err := tokenList[i].usableFrom(r, time.Now()); err != nil { return nil, err }
+		t := tokenList[i]
+		return &t, nil
+	}
+	return nil, &authError{401, "会话已失效"}
+}
+
+// checkLicenseDuration 校验到期日是否超出 Token 允许的最长天数
+func (t *APIToken) checkLicenseDuration(expiry string) error {
+	if t.MaxDays <= 0 { return nil }
+	loc := bizLocation()
+	exp, err := time.ParseInLocation("2006-01-02", expiry, loc)
+	if err != nil { return fmt.Errorf("日期格式错误: %v", err) }
+	now := time.Now().In(loc)
+	limit := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc).AddDate(0, 0, t.MaxDays)
+	if exp.After(limit) { return fmt.Errorf("❌ Token「%s」最多只能生成 %d 天的激活码", t.Name, t.MaxDays) }
+	return nil
+}
+
+// clientIP 默认取直连地址；部署在反向代理后面时设置 TRUST_PROXY_HEADERS=1
+func clientIP(r *http.Request) net.IP {
+	if TrustProxyHeaders {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			if ip := net.ParseIP(strings.TrimSpace(strings.Split(xff, ",")[0])); ip != nil { return ip }
+		}
+		if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil { return ip }
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil { host = r.RemoteAddr }
+	return net.ParseIP(host)
+}
+
+// ================= Token 管理 API =================
+
+type CreateTokenRequest struct {
+	Token      string   `json:"token"`
+	Name       string   `json:"name"`
+	Scopes     []string `json:"scopes"`
+	ExpiresAt  string   `json:"expires_at,omitempty"` // YYYY-MM-DD，当天结束时失效
+	MaxDays    int      `json:"max_days,omitempty"`
+	AllowedIPs []string `json:"allowed_ips,omitempty"`
+
+	ClientCertSubjects []string `json:"client_cert_subjects,omitempty"`
+	HMAC               bool     `json:"hmac,omitempty"` // 同时生成请求签名密钥
+}
+
+type RevokeTokenRequest struct {
+	Token string `json:"token"`
+	ID    string `json:"id"`
+}
+
+func handleCreateToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" { http.Error(w, "Method Not Allowed", 405); return }
+	var req CreateTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "JSON Error", 400); return }
+	admin, ok := authorize(w, r, req.Token, scopeAdmin)
+	if !ok { return }
+
+	t, secret, hmacKey, err := createToken(req)
+	if err != nil { http.Error(w, err.Error(), 400); return }
+	slog.InfoContext(r.Context(), "🔑 创建了 Token", "principal", admin.Name, "token_name", t.Name, "scopes", strings.Join(t.Scopes, ","))
+	t.SecretHash, t.HMACSecret = "", ""
+	resp := map[string]interface{}{"token": t, "secret": secret}
+	if hmacKey != "" { resp["hmac_key_id"], resp["hmac_secret"] = t.ID, hmacKey }
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ================= 创建与修改共用的校验 =================
+
+func validateScopes(scopes []string) error {
+	if len(scopes) == 0 { return fmt.Errorf("至少选择一个权限") }
+	for _, s := range scopes {
+		if !containsString(allScopes, s) { return fmt.Errorf("未知权限: %s", s) }
+	}
+	return nil
+}
+
+func normalizeIPs(list []string) ([]string, error) {
+	var ips []string
+	for _, ip := range list {
+		if ip = strings.TrimSpace(ip); ip == "" { continue }
+		if _, _, err := net.ParseCIDR(ip); err != nil && net.ParseIP(ip) == nil { return nil, fmt.Errorf("IP 格式错误: %s", ip) }
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+func normalizeSubjects(list []string) []string {
+	var out []string
+	for _, s := range list {
+		if s = strings.TrimSpace(s); s != "" { out = append(out, s) }
+	}
+	return out
+}
+
+// tokenExpiry YYYY-MM-DD 转为当天结束时刻，空字符串表示永不过期
+func tokenExpiry(day string) (string, error) {
+	if day == "" { return "", nil }
+	exp, err := time.ParseInLocation("2006-01-02", day, bizLocation())
+	if err != nil { return "", fmt.Errorf("过期日期格式错误") }
+	return exp.Add(24*time.Hour - time.Second).UTC().Format(time.RFC3339), nil
+}
+
+// checkTokenConflicts 名称和证书名称在未吊销的 Token 之间不能重复；调用方需持有 tokenMutex
+// conflictError 与已有数据冲突（REST 接口返回 409），其他校验错误返回 422
+type conflictError string
+
+func (e conflictError) Error() string { return string(e) }
+
+func checkTokenConflicts(exceptID, name string, subjects []string) error {
+	for _, existing := range tokenList {
+		if existing.RevokedAt != "" || existing.ID == exceptID { continue }
+		if existing.Name == name { return conflictError(fmt.Sprintf("名称「%s」已被使用", name)) }
+		for _, s := range subjects {
+			if containsString(existing.ClientCertSubjects, s) { return conflictError(fmt.Sprintf("证书名称「%s」已对应 Token「%s」", s, existing.Name)) }
+		}
+	}
+	return nil
+}
+
+// createToken 返回 Token、访问密钥，以及请求了 HMAC 时的签名密钥
+func createToken(req CreateTokenRequest) (APIToken, string, string, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" { return APIToken{}, "", "", fmt.Errorf("名称不能为空") }
+	if err := validateScopes(req.Scopes); err != nil { return APIToken{}, "", "", err }
+	if req.MaxDays < 0 { return APIToken{}, "", "", fmt.Errorf("max_days 不能为负数") }
+	ips, err := normalizeIPs(req.AllowedIPs)
+	if err != nil { return APIToken{}, "", "", err }
+	expiresAt, err := tokenExpiry(req.ExpiresAt)
+	if err != nil { return APIToken{}, "", "", err }
+	subjects := normalizeSubjects(req.ClientCertSubjects)
+
+	secret := newTokenSecret()
+	t := APIToken{
+		ID: newRecordID(), Name: name, SecretHash: hashSecret(secret), Prefix: secret[:8], Scopes: req.Scopes, CreatedAt: nowTimestamp(),
+		ExpiresAt: expiresAt, MaxDays: req.MaxDays, AllowedIPs: ips, ClientCertSubjects: subjects,
+	}
+	hmacKey := ""
+	if req.HMAC {
+		hmacKey = newTokenSecret()
+		enc, err := encryptField("hmac_secret", hmacKey)
+		if err != nil { return APIToken{}, "", "", fmt.Errorf("加密签名密钥失败: %v", err) }
+		t.HMACSecret = enc
+	}
+
+	tokenMutex.Lock(); defer tokenMutex.Unlock()
+	if err := checkTokenConflicts("", name, subjects); err != nil { return APIToken{}, "", "", err }
+	tokenList = append(tokenList, t)
+	persistTokens()
+	return t, secret, hmacKey, nil
+}
+
+func handleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" { http.Error(w, "Method Not Allowed", 405); return }
+	var req RevokeTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "JSON Error", 400); return }
+	admin, ok := authorize(w, r, req.Token, scopeAdmin)
+	if !ok { return }
+
+	tokenMutex.Lock(); defer tokenMutex.Unlock()
+	for i := range tokenList {
+		if tokenList[i].ID != req.ID { continue }
+		if tokenList[i].RevokedAt != "" { http.Error(w, "Token 已吊销", 409); return }
+		tokenList[i].RevokedAt = nowTimestamp()
+		persistTokens()
+		destroySessionsForToken(tokenList[i].ID)
+		slog.InfoContext(r.Context(), "🔑 吊销了 Token", "principal", admin.Name, "token_name", tokenList[i].Name)
+		w.Write([]byte("✅ Token 已吊销"))
+		return
+	}
+	http.Error(w, "Token 未找到", 404)
+}
+
+// ================= Token 管理页面 =================
+
+func handleTokens(w http.ResponseWriter, r *http.Request) {
+	admin, sess, ok := authorizePage(w, r, scopeAdmin)
+	if !ok { return }
+
+	now := time.Now()
+	tokenMutex.Lock()
+	rowsHtml := ""
+	for i := len(tokenList) - 1; i >= 0; i-- {
+		t := tokenList[i]
+		status := t.Status(now)
+		color := map[string]string{"active": "#34c759", "expired": "#888", "revoked": "#ff3b30"}[status]
+		expires, maxDays, ips, lastUsed := "永不", "不限", "不限", "-"
+		if t.ExpiresAt != "" { expires = displayTime(t.ExpiresAt) }
+		if t.MaxDays > 0 { maxDays = fmt.Sprintf("%d 天", t.MaxDays) }
+		if len(t.AllowedIPs) > 0 { ips = strings.Join(t.AllowedIPs, "<br>") }
+		if t.LastUsedAt != "" { lastUsed = displayTime(t.LastUsedAt) }
+		machine := ""
+		for _, s := range t.ClientCertSubjects { machine += "mTLS: " + html.EscapeString(s) + "<br>" }
+		if t.HMACSecret != "" { machine += "HMAC: <code>" + t.ID + "</code>" }
+		if machine == "" { machine = "-" }
+		action := ""
+		if status == "active" { action = fmt.Sprintf(`<button class="del-btn" onclick="revoke('%s')">吊销</button>`, t.ID) }
+		rowsHtml += fmt.Sprintf(`<tr><td><b>%s</b><br><code style="color:#888">%s…</code></td><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td style="color:%s">%s</td><td>%s</td></tr>`,
+			html.EscapeString(t.Name), t.Prefix, strings.Join(t.Scopes, "<br>"), expires, maxDays, ips, machine, lastUsed, color, status, action)
+	}
+	tokenMutex.Unlock()
+
+	scopeBoxes := ""
+	for _, s := range allScopes { scopeBoxes += fmt.Sprintf(`<label style="margin-right:10px"><input type="checkbox" name="scope" value="%s"> %s</label>`, s, s) }
+
+	page := fmt.Sprintf(`<!DOCTYPE html><html><head><meta charset="UTF-8"><meta name="viewport" content="width=device-width,initial-scale=1.0">%s<title>Token 管理</title>
+	<style>body{font-family:-apple-system,sans-serif;max-width:1000px;margin:20px auto;padding:10px;background:#f5f5f7}.card{background:white;padding:20px;border-radius:12px;box-shadow:0 2px 10px rgba(0,0,0,0.1);margin-bottom:20px}table{width:100%%;border-collapse:collapse;margin-top:10px;font-size:13px}th{text-align:left;background:#fafafa;padding:10px;border-bottom:2px solid #eee}td{padding:10px;border-bottom:1px solid #f5f5f5;color:#333;vertical-align:top}input[type=text],input[type=number],input[type=date]{padding:6px;border:1px solid #ccc;border-radius:4px;margin:4px 0 10px;width:100%%;box-sizing:border-box}.del-btn{background:#fff;border:1px solid #ff3b30;color:#ff3b30;padding:4px 8px;border-radius:4px;cursor:pointer;font-size:12px}.del-btn:hover{background:#ff3b30;color:white}.btn{padding:8px 16px;background:#0071e3;color:white;border:none;border-radius:6px;cursor:pointer}#secret{display:none;margin-top:10px;padding:10px;background:#fff8e1;border-radius:6px;word-break:break-all;font-family:monospace}</style></head><body>
+	<div class="card"><h2 style="display:flex;justify-content:space-between">🔑 Token 管理 %s</h2>
+	<table><thead><tr><th>名称</th><th>权限</th><th>过期</th><th>最长授权</th><th>IP 白名单</th><th>机器认证</th><th>最近使用</th><th>状态</th><th></th></tr></thead><tbody>%s</tbody></table></div>
+	<div class="card"><h3>新建 Token</h3>
+	<label>名称</label><input type="text" id="name" placeholder="如: billing-webhook">
+	<div>%s</div>
+	<label>过期日期（可选）</label><input type="date" id="exp">
+	<label>最长授权天数（可选，0 为不限）</label><input type="number" id="maxdays" value="0" min="0">
+	<label>IP 白名单（可选，逗号分隔，支持 CIDR）</label><input type="text" id="ips" placeholder="10.0.0.0/8, 203.0.113.7">
+	<label>客户端证书名称（可选，逗号分隔，匹配证书 CN 或 SAN）</label><input type="text" id="subjects" placeholder="billing.internal">
+	<label style="display:block;margin-bottom:10px"><input type="checkbox" id="hmac"> 生成 HMAC 请求签名密钥</label>
+	<button class="btn" onclick="create()">创建</button><div id="secret"></div></div>
+	%s<script>
+	async function create(){var scopes=[...document.querySelectorAll('input[name=scope]:checked')].map(e=>e.value);
+	var body={name:document.getElementById('name').value,scopes:scopes,expires_at:document.getElementById('exp').value,max_days:parseInt(document.getElementById('maxdays').value||'0'),allowed_ips:document.getElementById('ips').value.split(',').map(s=>s.trim()).filter(s=>s),client_cert_subjects:document.getElementById('subjects').value.split(',').map(s=>s.trim()).filter(s=>s),hmac:document.getElementById('hmac').checked};
+	var r=await postJSON('/api/tokens',body);
+	if(!r.ok)return alert(await r.text());var d=await r.json();var box=document.getElementById('secret');box.style.display='block';box.innerText='⚠️ 请立即保存，此密钥只显示一次:\n'+d.secret+(d.hmac_secret?'\n\nHMAC Key ID: '+d.hmac_key_id+'\nHMAC 签名密钥: '+d.hmac_secret:'');}
+	async function revoke(id){if(!confirm('确定吊销该 Token 吗？'))return;var r=await postJSON('/api/tokens/revoke',{id:id});if(r.ok)location.reload();else alert(await r.text())}</script></body></html>`, csrfMeta(sess), navLinks(admin), rowsHtml, scopeBoxes, csrfJS)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(page))
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s { return true }
+	}
+	return false
+}
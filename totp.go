@@ -0,0 +1,349 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"rsc.io/qr"
+)
+
+// ================= 两步验证 (TOTP, RFC 6238) =================
+// 用户可自行开启；TOTP_REQUIRED_ROLES 中的角色或被管理员标记的用户必须开启后才能使用系统。
+// 开启后登录需要输入验证码；生成新密钥、批量删除、长期激活码等敏感操作还要在
+// TOTP_STEP_UP_WINDOW 内验证过一次，否则返回 401 + X-Step-Up 头，由页面弹窗补输验证码。
+
+var (
+	TOTPIssuer        = newReloadable(getEnv("TOTP_ISSUER", "License Keygen"))
+	TOTPRequiredRoles = newReloadable(strings.Split(getEnv("TOTP_REQUIRED_ROLES", ""), ","))
+	TOTPStepUpWindow  = newReloadable(getEnvDuration("TOTP_STEP_UP_WINDOW", 5*time.Minute))
+	TOTPStepUpDays    = newReloadable(getEnvInt("TOTP_STEP_UP_DAYS", 14)) // 超过该天数的激活码视为长期激活码（全局上限见 LICENSE_MAX_MONTHS）
+)
+
+const (
+	totpPeriod        = 30
+	totpDigits        = 6
+	recoveryCodeCount = 10
+	maxOTPFailures    = 5 // 登录第二步连续输错这么多次后作废会话
+)
+
+var b32NoPad = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func newTOTPSecret() string {
+	b := make([]byte, 20)
+	rand.Read(b)
+	return b32NoPad.EncodeToString(b)
+}
+
+func totpCode(key []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	off := sum[len(sum)-1] & 0x0f
+	v := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, v%1000000)
+}
+
+// verifyTOTP 允许前后各一个周期的时钟误差；返回匹配的计数器，不接受 <= lastCounter 的（防重放）
+func verifyTOTP(secret, code string, lastCounter int64, now time.Time) (int64, bool) {
+	key, err := b32NoPad.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits { return 0, false }
+	cur := now.Unix() / totpPeriod
+	for c := cur - 1; c <= cur+1; c++ {
+		if c <= lastCounter { continue }
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, c)), []byte(code)) == 1 { return c, true }
+	}
+	return 0, false
+}
+
+func provisioningURI(username, secret string) string {
+	label := url.PathEscape(TOTPIssuer.Get() + ":" + username)
+	q := url.Values{"secret": {secret}, "issuer": {TOTPIssuer.Get()}, "algorithm": {"SHA1"}, "digits": {fmt.Sprint(totpDigits)}, "period": {fmt.Sprint(totpPeriod)}}
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// qrDataURI 把 otpauth 链接编码成可以直接放进 <img> 的二维码
+func qrDataURI(text string) (string, error) {
+	c, err := qr.Encode(text, qr.M)
+	if err != nil { return "", err }
+	c.Scale = 5
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(c.PNG()), nil
+}
+
+// newRecoveryCodes 返回明文（只展示一次）和落盘用的哈希
+func newRecoveryCodes() ([]string, []string) {
+	var plain, hashes []string
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		rand.Read(b)
+		s := strings.ToLower(b32NoPad.EncodeToString(b))
+		code := s[:4] + "-" + s[4:]
+		plain = append(plain, code)
+		hashes = append(hashes, hashSecret(code))
+	}
+	return plain, hashes
+}
+
+func (u *User) totpEnabled() bool { return u.TOTPEnabledAt != "" }
+
+func (u *User) totpRequired() bool {
+	return u.TOTPRequired || containsString(TOTPRequiredRoles.Get(), u.Role)
+}
+
+// checkSecondFactor 校验验证码或恢复码，调用方需持有 userMutex；成功时会落盘（计数器/已用的恢复码）
+func (u *User) checkSecondFactor(code string) error {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if code == "" { return &authError{401, "请输入验证码"} }
+	if !u.totpEnabled() { return &authError{400, "未启用两步验证"} }
+	if len(code) == totpDigits {
+		secret, err := decryptField("totp_secret", u.TOTPSecret)
+		if err != nil { return &authError{500, "读取两步验证密钥失败"} }
+		counter, ok := verifyTOTP(secret, code, u.TOTPLastCounter, time.Now())
+		if !ok { return &authError{401, "验证码错误"} }
+		u.TOTPLastCounter = counter
+		persistUsers()
+		return nil
+	}
+	hash := hashSecret(code)
+	for i, h := range u.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) != 1 { continue }
+		u.RecoveryCodes = append(u.RecoveryCodes[:i], u.RecoveryCodes[i+1:]...)
+		persistUsers()
+		slog.Info("🔐 使用了恢复码", "username", u.Username, "remaining", len(u.RecoveryCodes))
+		return nil
+	}
+	return &authError{401, "验证码错误"}
+}
+
+func verifySecondFactor(userID, code string) error {
+	userMutex.Lock(); defer userMutex.Unlock()
+	for i := range userList {
+		if userList[i].ID == userID { return userList[i].checkSecondFactor(code) }
+	}
+	return &authError{401, "会话已失效"}
+}
+
+// ================= 敏感操作二次确认 =================
+
+// requireStepUp 已开启两步验证的用户执行敏感操作前，需要在最近一段时间内输入过验证码。
+// 可以在请求头 X-OTP-Code 里直接带上验证码。API Token 没有第二因素，受 Token 自身的权限和限制约束。
+func requireStepUp(w http.ResponseWriter, r *http.Request, p *principal, action string) bool {
+	if p.User == nil || !p.User.totpEnabled() { return true }
+	s := currentSession(r)
+	if s == nil { writeError(w, r, 401, "unauthorized", "会话已失效"); return false }
+	if s.mfaFresh(time.Now()) { return true }
+	if code := r.Header.Get("X-OTP-Code"); code != "" {
+		if !checkLockout(w, r, p.Name) { return false }
+		if err := verifySecondFactor(p.User.ID, code); err != nil {
+			recordAuthFailure(r, p.Name, "二次验证: "+err.Error())
+			ae := err.(*authError)
+			w.Header().Set("X-Step-Up", "totp")
+			writeError(w, r, ae.Status, "step_up_required", ae.Message)
+			return false
+		}
+		markMFAVerified(s)
+		slog.InfoContext(r.Context(), "🔐 通过二次验证", "principal", p.Name, "action", action)
+		return true
+	}
+	w.Header().Set("X-Step-Up", "totp")
+	writeError(w, r, 401, "step_up_required", "「"+action+"」需要输入两步验证码")
+	return false
+}
+
+// isLongLicense 到期日超过 TOTP_STEP_UP_DAYS 天的激活码
+func isLongLicense(expiry string) bool {
+	if TOTPStepUpDays.Get() <= 0 { return false }
+	exp, err := time.ParseInLocation("2006-01-02", expiry, bizLocation())
+	if err != nil { return false }
+	return exp.After(time.Now().AddDate(0, 0, TOTPStepUpDays.Get()))
+}
+
+// ================= 登录第二步 =================
+
+func handleLoginOTP(w http.ResponseWriter, r *http.Request) {
+	next := safeNext(r.FormValue("next"))
+	s := currentSession(r)
+	if s == nil || !s.MFAPending { http.Redirect(w, r, "/login?next="+url.QueryEscape(next), http.StatusSeeOther); return }
+
+	errMsg := ""
+	if r.Method == "POST" {
+		if !validCSRF(r, s) { http.Error(w, "CSRF 校验失败", 403); return }
+		username := ""
+		if u, err := activeUser(s.UserID); err == nil { username = u.Username }
+		if !checkLockout(w, r, username) { return }
+		err := verifySecondFactor(s.UserID, r.FormValue("code"))
+		if err != nil { recordAuthFailure(r, username, "两步验证: "+err.Error()) } else { recordAuthSuccess(r, username) }
+		if err == nil {
+			ns := completeMFA(s)
+			setSessionCookie(w, r, ns)
+			slog.InfoContext(r.Context(), "👤 已登录，通过两步验证", "username", username, "ip", clientIP(r).String())
+			http.Redirect(w, r, next, http.StatusSeeOther)
+			return
+		}
+		if recordOTPFailure(s) {
+			clearSessionCookie(w, r)
+			http.Redirect(w, r, "/login?next="+url.QueryEscape(next), http.StatusSeeOther)
+			return
+		}
+		errMsg = err.Error()
+		w.WriteHeader(401)
+	}
+
+	page := fmt.Sprintf(`<!DOCTYPE html><html><head><meta charset="UTF-8"><meta name="viewport" content="width=device-width,initial-scale=1.0"><title>两步验证</title>
+	<style>body{font-family:-apple-system,sans-serif;max-width:400px;margin:80px auto;padding:20px;background:#f5f5f7}.card{background:white;padding:30px;border-radius:12px;box-shadow:0 4px 12px rgba(0,0,0,0.1)}input{width:100%%;padding:10px;margin:5px 0 15px;box-sizing:border-box;border:1px solid #ccc;border-radius:6px;font-size:18px;letter-spacing:4px;text-align:center}button{width:100%%;padding:12px;background:#0071e3;color:white;border:none;border-radius:6px;cursor:pointer}.err{color:#ff3b30;font-size:13px;margin-bottom:10px}</style></head><body>
+	<div class="card"><h2>🔐 两步验证</h2>%s<form method="POST" action="/login/otp"><input type="hidden" name="next" value="%s"><input type="hidden" name="csrf_token" value="%s"><label>验证器中的 6 位验证码，或一个恢复码</label><input type="text" name="code" autofocus autocomplete="one-time-code" inputmode="numeric"><button type="submit">验证</button></form></div></body></html>`,
+		loginError(errMsg), html.EscapeString(next), s.CSRFToken)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(page))
+}
+
+// ================= 账号安全 API =================
+
+type OTPRequest struct {
+	Code string `json:"code"`
+}
+
+// POST /api/account/totp/setup 生成待确认的密钥，返回二维码
+func handleTOTPSetup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" { http.Error(w, "Method Not Allowed", 405); return }
+	p, ok := authorize(w, r, "", scopeSelf)
+	if !ok { return }
+	if p.User == nil { http.Error(w, "API Token 登录无法设置两步验证", 400); return }
+	if p.User.totpEnabled() { http.Error(w, "已启用两步验证，请先关闭", 409); return }
+
+	secret := newTOTPSecret()
+	enc, err := encryptField("totp_secret", secret)
+	if err != nil { http.Error(w, "加密失败", 500); return }
+	if !updateUser(p.User.ID, func(u *User) { u.TOTPPending = enc }) { http.Error(w, "用户未找到", 404); return }
+
+	uri := provisioningURI(p.User.Username, secret)
+	img, err := qrDataURI(uri)
+	if err != nil { http.Error(w, "生成二维码失败", 500); return }
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]string{"secret": secret, "uri": uri, "qr": img})
+}
+
+// POST /api/account/totp/enable {code} 用验证码确认密钥，返回恢复码（只显示一次）
+func handleTOTPEnable(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" { http.Error(w, "Method Not Allowed", 405); return }
+	var req OTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "JSON Error", 400); return }
+	p, ok := authorize(w, r, "", scopeSelf)
+	if !ok { return }
+	if p.User == nil { http.Error(w, "API Token 登录无法设置两步验证", 400); return }
+
+	codes, hashes := newRecoveryCodes()
+	var failure string
+	updateUser(p.User.ID, func(u *User) {
+		if u.TOTPPending == "" { failure = "请先生成密钥"; return }
+		secret, err := decryptField("totp_secret", u.TOTPPending)
+		if err != nil { failure = "读取密钥失败"; return }
+		counter, valid := verifyTOTP(secret, strings.TrimSpace(req.Code), 0, time.Now())
+		if !valid { failure = "验证码错误，请检查手机时间是否准确"; return }
+		u.TOTPSecret, u.TOTPPending = u.TOTPPending, ""
+		u.TOTPEnabledAt = nowTimestamp()
+		u.TOTPLastCounter = counter
+		u.RecoveryCodes = hashes
+	})
+	if failure != "" { http.Error(w, failure, 400); return }
+	if s := currentSession(r); s != nil { markMFAVerified(s) }
+	slog.InfoContext(r.Context(), "🔐 启用了两步验证", "principal", p.Name)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]interface{}{"recovery_codes": codes})
+}
+
+// POST /api/account/totp/disable {code}
+func handleTOTPDisable(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" { http.Error(w, "Method Not Allowed", 405); return }
+	var req OTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "JSON Error", 400); return }
+	p, ok := authorize(w, r, "", scopeSelf)
+	if !ok { return }
+	if p.User == nil { http.Error(w, "API Token 登录无法设置两步验证", 400); return }
+	if p.User.totpRequired() { http.Error(w, "你的账号必须开启两步验证", 403); return }
+	if !verifyAccountCode(w, r, p, req.Code, "关闭两步验证") { return }
+	updateUser(p.User.ID, resetTOTP)
+	slog.InfoContext(r.Context(), "🔐 关闭了两步验证", "principal", p.Name)
+	w.Write([]byte("✅ 已关闭两步验证"))
+}
+
+// POST /api/account/recovery-codes {code} 重新生成恢复码，旧的全部作废
+func handleRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" { http.Error(w, "Method Not Allowed", 405); return }
+	var req OTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "JSON Error", 400); return }
+	p, ok := authorize(w, r, "", scopeSelf)
+	if !ok { return }
+	if p.User == nil { http.Error(w, "API Token 登录无法设置两步验证", 400); return }
+	if !verifyAccountCode(w, r, p, req.Code, "重新生成恢复码") { return }
+	codes, hashes := newRecoveryCodes()
+	updateUser(p.User.ID, func(u *User) { u.RecoveryCodes = hashes })
+	slog.InfoContext(r.Context(), "🔐 重新生成了恢复码", "principal", p.Name)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]interface{}{"recovery_codes": codes})
+}
+
+// verifyAccountCode 与 requireStepUp 一样先检查锁定、失败计入锁定，否则拿到会话的人可以在这里无限猜验证码
+func verifyAccountCode(w http.ResponseWriter, r *http.Request, p *principal, code, action string) bool {
+	if !checkLockout(w, r, p.Name) { return false }
+	if err := verifySecondFactor(p.User.ID, code); err != nil {
+		recordAuthFailure(r, p.Name, action+": "+err.Error())
+		ae := err.(*authError)
+		http.Error(w, ae.Message, ae.Status)
+		return false
+	}
+	return true
+}
+
+func resetTOTP(u *User) {
+	u.TOTPSecret, u.TOTPPending, u.TOTPEnabledAt = "", "", ""
+	u.TOTPLastCounter = 0
+	u.RecoveryCodes = nil
+}
+
+// ================= 账号安全页面 =================
+
+func handleAccount(w http.ResponseWriter, r *http.Request) {
+	p, sess, ok := authorizePage(w, r, scopeSelf)
+	if !ok { return }
+
+	body := `<p>当前使用 API Token 登录，两步验证只适用于用户账号。</p>`
+	if u := p.User; u != nil {
+		switch {
+		case u.totpEnabled():
+			body = fmt.Sprintf(`<p style="color:#34c759">✅ 已启用两步验证（%s 起），剩余恢复码 %d 个</p>
+			<input type="text" id="code" placeholder="当前验证码" autocomplete="one-time-code"><button class="btn" onclick="regen()">重新生成恢复码</button> <button class="btn gray" onclick="disable()">关闭两步验证</button><pre id="codes"></pre>`, displayTime(u.TOTPEnabledAt), len(u.RecoveryCodes))
+		default:
+			warn := ""
+			if u.totpRequired() { warn = `<p style="color:#ff3b30">⚠️ 你的账号必须启用两步验证后才能继续使用。</p>` }
+			body = warn + `<p>使用 Google Authenticator、1Password 等验证器扫描二维码。</p><button class="btn" onclick="setup()">开始设置</button>
+			<div id="enroll" style="display:none;margin-top:15px"><img id="qr"><p style="font-family:monospace;word-break:break-all" id="secret"></p><input type="text" id="code" placeholder="输入验证器显示的 6 位数字" autocomplete="one-time-code"><button class="btn" onclick="enable()">确认启用</button></div><pre id="codes"></pre>`
+		}
+	}
+
+	page := fmt.Sprintf(`<!DOCTYPE html><html><head><meta charset="UTF-8"><meta name="viewport" content="width=device-width,initial-scale=1.0">%s<title>账号安全</title>
+	<style>body{font-family:-apple-system,sans-serif;max-width:600px;margin:20px auto;padding:10px;background:#f5f5f7}.card{background:white;padding:20px;border-radius:12px;box-shadow:0 2px 10px rgba(0,0,0,0.1)}input{padding:8px;border:1px solid #ccc;border-radius:4px;margin:4px 0 10px;width:100%%;box-sizing:border-box}.btn{padding:8px 16px;background:#0071e3;color:white;border:none;border-radius:6px;cursor:pointer}.gray{background:#888}pre{background:#fff8e1;padding:10px;border-radius:6px;display:none}</style></head><body>
+	<div class="card"><h2 style="display:flex;justify-content:space-between">🛡️ 两步验证 %s</h2>%s</div>
+	%s<script>
+	function showCodes(d){var b=document.getElementById('codes');b.style.display='block';b.innerText='⚠️ 恢复码只显示这一次，每个只能使用一次，请妥善保存:\n\n'+d.recovery_codes.join('\n')}
+	async function setup(){var r=await postJSON('/api/account/totp/setup',{});if(!r.ok)return alert(await r.text());var d=await r.json();document.getElementById('enroll').style.display='block';document.getElementById('qr').src=d.qr;document.getElementById('secret').innerText=d.secret}
+	async function enable(){var r=await postJSON('/api/account/totp/enable',{code:document.getElementById('code').value});if(!r.ok)return alert(await r.text());showCodes(await r.json());document.getElementById('enroll').style.display='none'}
+	async function regen(){var r=await postJSON('/api/account/recovery-codes',{code:document.getElementById('code').value});if(!r.ok)return alert(await r.text());showCodes(await r.json())}
+	async function disable(){if(!confirm('确定关闭两步验证吗？'))return;var r=await postJSON('/api/account/totp/disable',{code:document.getElementById('code').value});alert(await r.text());if(r.ok)location.reload()}</script></body></html>`, csrfMeta(sess), navLinks(p), body, csrfJS)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(page))
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAccountCodeLockout(t *testing.T) {
+	withDataDir(t, t.TempDir())
+	saved := userList
+	u := User{ID: "u1", Username: "alice", Role: "viewer", TOTPSecret: "JBSWY3DPEHPK3PXP", TOTPEnabledAt: "2026-01-01T00:00:00Z"}
+	userList = []User{u}
+	t.Cleanup(func() { userList = saved })
+	p := &principal{Name: "alice", Role: "viewer", Scopes: []string{scopeSelf}, User: &u}
+
+	for name, h := range map[string]http.HandlerFunc{"disable": handleTOTPDisable, "recovery": handleRecoveryCodes} {
+		withLockouts(t, 3)
+		call := func() int {
+			r := httptest.NewRequest("POST", "/api/account/"+name, strings.NewReader(`{"code":"wrong-code"}`))
+			r.RemoteAddr = "198.51.100.20:4000"
+			w := httptest.NewRecorder()
+			h(w, r.WithContext(context.WithValue(r.Context(), ctxPrincipal, p)))
+			return w.Code
+		}
+		for i := 0; i < 3; i++ {
+			if code := call(); code != 401 { t.Fatalf("%s 第 %d 次: 期望 401，实际 %d", name, i+1, code) }
+		}
+		if code := call(); code != 429 { t.Fatalf("%s: 连续失败后应被锁定，实际 %d", name, code) }
+	}
+}
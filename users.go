@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ================= 用户账号 =================
+// 每个人使用自己的账号登录，操作记录里写的是用户名，权限由角色决定（见 authz.go）。
+// 密码使用 bcrypt 保存。
+
+var usersFile = "users.json"
+
+const kindUsers = "users"
+
+type User struct {
+	ID           string `json:"id"`
+	Username     string `json:"username"`
+	PasswordHash string `json:"password_hash,omitempty"`
+	Role         string `json:"role"`
+	CreatedAt    string `json:"created_at"`
+	DisabledAt   string `json:"disabled_at,omitempty"`
+	LastLoginAt  string `json:"last_login_at,omitempty"`
+
+	// 两步验证，见 totp.go
+	TOTPSecret      string   `json:"totp_secret,omitempty"`       // 加密保存
+	TOTPPending     string   `json:"totp_pending,omitempty"`      // 已生成但还没用验证码确认的密钥
+	TOTPEnabledAt   string   `json:"totp_enabled_at,omitempty"`
+	TOTPLastCounter int64    `json:"totp_last_counter,omitempty"` // 最近一次使用的时间片，防止验证码重放
+	TOTPRequired    bool     `json:"totp_required,omitempty"`     // 管理员要求该用户必须开启
+	RecoveryCodes   []string `json:"recovery_codes,omitempty"`    // SHA-256
+
+	// 通过 SSO 创建的用户，见 oidc.go
+	OIDCIssuer  string `json:"oidc_issuer,omitempty"`
+	OIDCSubject string `json:"oidc_subject,omitempty"`
+}
+
+var (
+	userList  []User
+	userMutex sync.Mutex
+)
+
+// 用户不存在时也做一次 bcrypt 比较，避免通过响应时间枚举用户名
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+
+func loadUsers() {
+	userMutex.Lock(); defer userMutex.Unlock()
+	res, err := loadDataFile(usersFile, kindUsers, &userList)
+	if os.IsNotExist(err) { return }
+	if err != nil { log.Fatalf(">>> ❌ %v", err) }
+	if res.Migrated() { persistUsers() }
+	slog.Info("👥 已加载用户", "count", len(userList))
+}
+
+// 调用方需持有 userMutex
+func persistUsers() {
+	data, err := encodeDataFile(kindUsers, userList)
+	if err != nil { slog.Error("❌ 序列化用户失败", "err", err); return }
+	if err := writeFileAtomic(usersFile, data, 0600); err != nil { slog.Error("❌ 保存用户失败", "err", err) }
+}
+
+func hashPassword(password string) (string, error) {
+	if len(password) < 10 { return "", fmt.Errorf("密码至少 10 位") }
+	if containsString(weakSecrets, strings.ToLower(password)) { return "", fmt.Errorf("密码太常见") }
+	h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil { return "", fmt.Errorf("密码无法使用: %v", err) }
+	return string(h), nil
+}
+
+// authenticatePassword 校验用户名密码，成功时记录最近登录时间
+func authenticatePassword(username, password string) (*User, error) {
+	userMutex.Lock(); defer userMutex.Unlock()
+	for i := range userList {
+		u := &userList[i]
+		if u.Username != username { continue }
+		if bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) != nil { return nil, &authError{401, "用户名或密码错误"} }
+		if u.DisabledAt != "" { return nil, &authError{403, "账号已禁用"} }
+		u.LastLoginAt = nowTimestamp()
+		persistUsers()
+		found := *u
+		return &found, nil
+	}
+	bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+	return nil, &authError{401, "用户名或密码错误"}
+}
+
+// activeUser 按 ID 查找未禁用的用户
+func activeUser(id string) (*User, error) {
+	userMutex.Lock(); defer userMutex.Unlock()
+	for i := range userList {
+		if userList[i].ID != id { continue }
+		if userList[i].DisabledAt != "" { return nil, &authError{403, "账号已禁用"} }
+		u := userList[i]
+		return &u, nil
+	}
+	return nil, &authError{401, "会话已失效"}
+}
+
+// updateUser 修改并落盘，用户不存在时返回 false
+func updateUser(id string, fn func(u *User)) bool {
+	userMutex.Lock(); defer userMutex.Unlock()
+	for i := range userList {
+		if userList[i].ID != id { continue }
+		fn(&userList[i])
+		persistUsers()
+		return true
+	}
+	return false
+}
+
+func hasAdminUser() bool {
+	userMutex.Lock(); defer userMutex.Unlock()
+	for _, u := range userList {
+		if u.Role == roleAdmin && u.DisabledAt == "" { return true }
+	}
+	return false
+}
+
+func createUser(username, password, role string) (User, error) {
+	username = strings.TrimSpace(username)
+	if username == "" { return User{}, fmt.Errorf("用户名不能为空") }
+	if !containsString(allRoles, role) { return User{}, fmt.Errorf("未知角色: %s", role) }
+	hash, err := hashPassword(password)
+	if err != nil { return User{}, err }
+
+	userMutex.Lock(); defer userMutex.Unlock()
+	for _, u := range userList {
+		if u.Username == username { return User{}, fmt.Errorf("用户名「%s」已存在", username) }
+	}
+	u := User{ID: newRecordID(), Username: username, PasswordHash: hash, Role: role, CreatedAt: nowTimestamp()}
+	userList = append(userList, u)
+	persistUsers()
+	return u, nil
+}
+
+// ================= 用户管理 API =================
+
+type CreateUserRequest struct {
+	Token    string `json:"token"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+// UpdateUserRequest 只修改填写了的字段
+type UpdateUserRequest struct {
+	Token    string `json:"token"`
+	ID       string `json:"id"`
+	Role     string `json:"role,omitempty"`
+	Password string `json:"password,omitempty"`
+	Disabled *bool  `json:"disabled,omitempty"`
+
+	TOTPRequired *bool `json:"totp_required,omitempty"`
+	ResetTOTP    bool  `json:"reset_totp,omitempty"` // 用户丢失验证器和恢复码时使用
+}
+
+func handleCreateUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" { http.Error(w, "Method Not Allowed", 405); return }
+	var req CreateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "JSON Error", 400); return }
+	admin, ok := authorize(w, r, req.Token, scopeAdmin)
+	if !ok { return }
+
+	u, err := createUser(req.Username, req.Password, req.Role)
+	if err != nil { http.Error(w, err.Error(), 400); return }
+	slog.InfoContext(r.Context(), "👥 创建了用户", "principal", admin.Name, "username", u.Username, "role", u.Role)
+	u.PasswordHash = ""
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(u)
+}
+
+func handleUpdateUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" { http.Error(w, "Method Not Allowed", 405); return }
+	var req UpdateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "JSON Error", 400); return }
+	admin, ok := authorize(w, r, req.Token, scopeAdmin)
+	if !ok { return }
+	if req.Role != "" && !containsString(allRoles, req.Role) { http.Error(w, "未知角色: "+req.Role, 400); return }
+	var hash string
+	if req.Password != "" {
+		h, err := hashPassword(req.Password)
+		if err != nil { http.Error(w, err.Error(), 400); return }
+		hash = h
+	}
+	if admin.User != nil && admin.User.ID == req.ID && (req.Role != "" && req.Role != roleAdmin || req.Disabled != nil && *req.Disabled) {
+		http.Error(w, "不能禁用自己或取消自己的管理员角色", 400)
+		return
+	}
+
+	userMutex.Lock(); defer userMutex.Unlock()
+	for i := range userList {
+		u := &userList[i]
+		if u.ID != req.ID { continue }
+		var changes []string
+		if req.Role != "" && req.Role != u.Role { changes = append(changes, "角色 "+u.Role+" -> "+req.Role); u.Role = req.Role }
+		if hash != "" { changes = append(changes, "重置密码"); u.PasswordHash = hash }
+		if req.Disabled != nil {
+			if *req.Disabled && u.DisabledAt == "" { changes = append(changes, "禁用"); u.DisabledAt = nowTimestamp() }
+			if !*req.Disabled && u.DisabledAt != "" { changes = append(changes, "启用"); u.DisabledAt = "" }
+		}
+		if req.TOTPRequired != nil && *req.TOTPRequired != u.TOTPRequired {
+			u.TOTPRequired = *req.TOTPRequired
+			if u.TOTPRequired { changes = append(changes, "要求两步验证") } else { changes = append(changes, "取消两步验证要求") }
+		}
+		if req.ResetTOTP && (u.totpEnabled() || u.TOTPPending != "") { changes = append(changes, "重置两步验证"); resetTOTP(u) }
+		if len(changes) == 0 { w.Write([]byte("没有变化")); return }
+		persistUsers()
+		destroySessionsForUser(u.ID)
+		slog.InfoContext(r.Context(), "👥 修改了用户", "principal", admin.Name, "username", u.Username, "changes", strings.Join(changes, ", "))
+		w.Write([]byte("✅ 已保存"))
+		return
+	}
+	http.Error(w, "用户未找到", 404)
+}
+
+// ================= 用户管理页面 =================
+
+func handleUsers(w http.ResponseWriter, r *http.Request) {
+	admin, sess, ok := authorizePage(w, r, scopeAdmin)
+	if !ok { return }
+
+	roleOptions := func(selected string) string {
+		out := ""
+		for _, role := range allRoles {
+			sel := ""
+			if role == selected { sel = " selected" }
+			out += fmt.Sprintf(`<option value="%s"%s>%s</option>`, role, sel, role)
+		}
+		return out
+	}
+
+	userMutex.Lock()
+	rowsHtml := ""
+	for _, u := range userList {
+		status, color, toggle := "active", "#34c759", "true"
+		if u.DisabledAt != "" { status, color, toggle = "disabled", "#ff3b30", "false" }
+		lastLogin := "-"
+		if u.LastLoginAt != "" { lastLogin = displayTime(u.LastLoginAt) }
+		toggleLabel := map[string]string{"true": "禁用", "false": "启用"}[toggle]
+		mfa := "未启用"
+		if u.totpEnabled() { mfa = fmt.Sprintf(`✅ 已启用 <button class="del-btn" onclick="if(confirm('确定重置该用户的两步验证吗？'))update('%s',{reset_totp:true})">重置</button>`, u.ID) }
+		checked := ""
+		if u.TOTPRequired { checked = " checked" }
+		mfa += fmt.Sprintf(`<br><label style="font-size:12px;color:#888"><input type="checkbox" style="width:auto;margin:0"%s onchange="update('%s',{totp_required:this.checked})"> 必须开启</label>`, checked, u.ID)
+		sso := ""
+		if u.OIDCSubject != "" { sso = ` <span style="font-size:11px;color:#888" title="角色在每次 SSO 登录时按 IdP 组同步">SSO</span>` }
+		rowsHtml += fmt.Sprintf(`<tr><td><b>%s</b>%s</td><td><select onchange="update('%s',{role:this.value})">%s</select></td><td>%s</td><td>%s</td><td>%s</td><td style="color:%s">%s</td><td><button class="del-btn" onclick="update('%s',{disabled:%s})">%s</button> <button class="del-btn" onclick="resetPwd('%s')">重置密码</button></td></tr>`,
+			html.EscapeString(u.Username), sso, u.ID, roleOptions(u.Role), displayTime(u.CreatedAt), lastLogin, mfa, color, status, u.ID, toggle, toggleLabel, u.ID)
+	}
+	userMutex.Unlock()
+
+	page := fmt.Sprintf(`<!DOCTYPE html><html><head><meta charset="UTF-8"><meta name="viewport" content="width=device-width,initial-scale=1.0">%s<title>用户管理</title>
+	<style>body{font-family:-apple-system,sans-serif;max-width:1000px;margin:20px auto;padding:10px;background:#f5f5f7}.card{background:white;padding:20px;border-radius:12px;box-shadow:0 2px 10px rgba(0,0,0,0.1);margin-bottom:20px}table{width:100%%;border-collapse:collapse;margin-top:10px;font-size:13px}th{text-align:left;background:#fafafa;padding:10px;border-bottom:2px solid #eee}td{padding:10px;border-bottom:1px solid #f5f5f5;color:#333}input,select{padding:6px;border:1px solid #ccc;border-radius:4px;margin:4px 0 10px;box-sizing:border-box}input{width:100%%}.del-btn{background:#fff;border:1px solid #ff3b30;color:#ff3b30;padding:4px 8px;border-radius:4px;cursor:pointer;font-size:12px}.del-btn:hover{background:#ff3b30;color:white}.btn{padding:8px 16px;background:#0071e3;color:white;border:none;border-radius:6px;cursor:pointer}</style></head><body>
+	<div class="card"><h2 style="display:flex;justify-content:space-between">👥 用户管理 %s</h2>
+	<table><thead><tr><th>用户名</th><th>角色</th><th>创建时间</th><th>最近登录</th><th>两步验证</th><th>状态</th><th></th></tr></thead><tbody>%s</tbody></table>
+	<p style="font-size:12px;color:#888">admin: 全部权限 · operator: 生成与查看 · viewer: 只读 · reseller: 生成，只能看到自己客户的机器</p></div>
+	<div class="card"><h3>新建用户</h3>
+	<label>用户名</label><input type="text" id="username">
+	<label>初始密码（至少 10 位）</label><input type="password" id="password" autocomplete="new-password">
+	<label>角色</label><br><select id="role">%s</select><br>
+	<button class="btn" onclick="create()">创建</button></div>
+	%s<script>
+	async function create(){var r=await postJSON('/api/users',{username:document.getElementById('username').value,password:document.getElementById('password').value,role:document.getElementById('role').value});if(r.ok)location.reload();else alert(await r.text())}
+	async function update(id,body){body.id=id;var r=await postJSON('/api/users/update',body);if(r.ok)location.reload();else alert(await r.text())}
+	function resetPwd(id){var p=prompt('新密码（至少 10 位）');if(p)update(id,{password:p})}</script></body></html>`, csrfMeta(sess), navLinks(admin), rowsHtml, roleOptions(roleViewer), csrfJS)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(page))
+}
+
+// ================= 命令行: user =================
+
+// ./server user add -username alice -role admin   密码从 USER_PASSWORD 或标准输入读取
+// ./server user passwd -username alice
+// ./server user list
+func cmdUser(args []string) error {
+	if len(args) == 0 { return fmt.Errorf("用法: user add|passwd|list") }
+	fs := flag.NewFlagSet("user "+args[0], flag.ExitOnError)
+	username := fs.String("username", "", "用户名")
+	role := fs.String("role", roleViewer, "角色: "+strings.Join(allRoles, "/"))
+	fs.Parse(args[1:])
+
+	loadUsers()
+	switch args[0] {
+	case "list":
+		userMutex.Lock(); defer userMutex.Unlock()
+		for _, u := range userList {
+			status := "active"
+			if u.DisabledAt != "" { status = "disabled" }
+			if u.OIDCSubject != "" { status += " sso" }
+			fmt.Printf("%-20s %-10s %s\n", u.Username, u.Role, status)
+		}
+		return nil
+	case "add":
+		password, err := readPassword()
+		if err != nil { return err }
+		u, err := createUser(*username, password, *role)
+		if err != nil { return err }
+		fmt.Printf("✅ 已创建用户 %s (%s)\n", u.Username, u.Role)
+		return nil
+	case "passwd":
+		password, err := readPassword()
+		if err != nil { return err }
+		hash, err := hashPassword(password)
+		if err != nil { return err }
+		userMutex.Lock(); defer userMutex.Unlock()
+		for i := range userList {
+			if userList[i].Username != *username { continue }
+			userList[i].PasswordHash = hash
+			persistUsers()
+			fmt.Printf("✅ 已修改 %s 的密码\n", *username)
+			return nil
+		}
+		return fmt.Errorf("用户 %s 不存在", *username)
+	}
+	return fmt.Errorf("未知子命令: %s", args[0])
+}
+
+func readPassword() (string, error) {
+	if p := os.Getenv("USER_PASSWORD"); p != "" { return p, nil }
+	fmt.Fprint(os.Stderr, "密码: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" { return "", fmt.Errorf("读取密码失败: %v", err) }
+	return strings.TrimRight(line, "\r\n"), nil
+}
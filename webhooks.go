@@ -0,0 +1,497 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ================= Webhook =================
+// 管理员在 /webhooks 页面订阅事件，事件发生时向订阅地址 POST JSON：
+//   {"id": "evt_...", "type": "license.generated", "created_at": "...", "data": {...}}
+// 请求头:
+//   X-Webhook-Id         事件 ID，重试和重放时不变，接收方可据此去重
+//   X-Webhook-Event      事件类型
+//   X-Webhook-Timestamp  Unix 秒
+//   X-Webhook-Signature  sha256=hex(HMAC-SHA256(订阅密钥, 时间戳 + "." + 请求体))
+// 非 2xx 或超时按 30 秒起翻倍重试（最长间隔 6 小时），最多 WEBHOOK_MAX_ATTEMPTS 次。
+// 待投递的队列和投递记录保存在 webhook_deliveries.json，重启后继续重试。
+//
+// 事件:
+//   license.generated  生成激活码（data 同 /api/v1/licenses）
+//   license.revoked    吊销激活码（PATCH /api/v1/licenses/{id} {"revoked": true} 或 gRPC RevokeLicense），记录保留
+//   license.deleted    删除历史记录；已发出的激活码是离线验签的，删除记录并不会让它失效
+//   license.expiring   激活码将在 WEBHOOK_EXPIRING_DAYS 天内到期，每条记录只通知一次
+//   machine.deleted    删除机器码
+//   webhook.ping       页面上的「测试」按钮，只发给对应的订阅
+// 需求里的 voucher.redeemed 没有实现：本服务没有兑换码 / 代金券功能，没有可以触发它的地方，加上功能后再补。
+
+var (
+	webhooksFile        = "webhooks.json"
+	webhookDeliveryFile = "webhook_deliveries.json"
+	WebhookMaxAttempts  = newReloadable(getEnvInt("WEBHOOK_MAX_ATTEMPTS", 10))
+	WebhookTimeout      = getEnvDuration("WEBHOOK_TIMEOUT", 10*time.Second)
+	WebhookExpiringDays = newReloadable(getEnvInt("WEBHOOK_EXPIRING_DAYS", 3))
+	WebhookLogLimit     = newReloadable(getEnvInt("WEBHOOK_LOG_LIMIT", 500)) // 保留的已结束投递记录条数
+	webhookHTTPClient   = &http.Client{Timeout: WebhookTimeout}
+)
+
+const (
+	kindWebhooks          = "webhooks"
+	kindWebhookDeliveries = "webhook_deliveries"
+
+	eventLicenseGenerated = "license.generated"
+	eventLicenseRevoked   = "license.revoked"
+	eventLicenseDeleted   = "license.deleted"
+	eventLicenseExpiring  = "license.expiring"
+	eventMachineDeleted   = "machine.deleted"
+	eventWebhookPing      = "webhook.ping"
+
+	webhookMaxBackoff = 6 * time.Hour
+)
+
+var webhookEvents = []string{eventLicenseGenerated, eventLicenseRevoked, eventLicenseDeleted, eventLicenseExpiring, eventMachineDeleted}
+
+type Webhook struct {
+	ID          string   `json:"id"`
+	URL         string   `json:"url"`
+	Events      []string `json:"events"`
+	Description string   `json:"description,omitempty"`
+	Secret      string   `json:"secret,omitempty"` // 签名密钥，加密保存
+	CreatedAt   string   `json:"created_at"`
+	CreatedBy   string   `json:"created_by,omitempty"`
+}
+
+func (h *Webhook) wants(event string) bool { return event == eventWebhookPing || containsString(h.Events, event) }
+
+type WebhookDelivery struct {
+	ID            string `json:"id"`
+	WebhookID     string `json:"webhook_id"`
+	EventID       string `json:"event_id"`
+	Event         string `json:"event"`
+	Payload       string `json:"payload"` // 请求体，加密保存
+	CreatedAt     string `json:"created_at"`
+	Attempts      int    `json:"attempts"`
+	NextAttemptAt string `json:"next_attempt_at,omitempty"` // 为空表示已结束
+	LastAttemptAt string `json:"last_attempt_at,omitempty"`
+	LastStatus    int    `json:"last_status,omitempty"`
+	LastError     string `json:"last_error,omitempty"`
+	DeliveredAt   string `json:"delivered_at,omitempty"`
+	ReplayOf      string `json:"replay_of,omitempty"` // 手动重放时为原投递 ID
+}
+
+func (d *WebhookDelivery) Status() string {
+	switch {
+	case d.DeliveredAt != "":
+		return "delivered"
+	case d.NextAttemptAt != "":
+		return "pending"
+	}
+	return "failed"
+}
+
+var (
+	webhookList  []Webhook
+	deliveryList []WebhookDelivery
+	webhookMutex sync.Mutex
+	webhookWake  = make(chan struct{}, 1)
+)
+
+func loadWebhooks() {
+	webhookMutex.Lock(); defer webhookMutex.Unlock()
+	for _, f := range []struct {
+		path, kind string
+		out        interface{}
+		persist    func()
+	}{{webhooksFile, kindWebhooks, &webhookList, persistWebhooks}, {webhookDeliveryFile, kindWebhookDeliveries, &deliveryList, persistDeliveries}} {
+		res, err := loadDataFile(f.path, f.kind, f.out)
+		if os.IsNotExist(err) { continue }
+		if err != nil { log.Fatalf(">>> ❌ %v", err) }
+		if res.Migrated() { f.persist() }
+	}
+	if len(webhookList) > 0 { slog.Info("🪝 已加载 Webhook 订阅", "count", len(webhookList)) }
+}
+
+// 调用方需持有 webhookMutex
+func persistWebhooks() {
+	data, err := encodeDataFile(kindWebhooks, webhookList)
+	if err != nil { slog.Error("❌ 序列化 Webhook 失败", "err", err); return }
+	if err := writeFileAtomic(webhooksFile, data, 0600); err != nil { slog.Error("❌ 保存 Webhook 失败", "err", err) }
+}
+
+// 调用方需持有 webhookMutex；超出 WebhookLogLimit 的已结束记录从最旧的开始丢弃
+func persistDeliveries() {
+	finished := 0
+	for _, d := range deliveryList {
+		if d.NextAttemptAt == "" { finished++ }
+	}
+	if drop := finished - WebhookLogLimit.Get(); drop > 0 {
+		kept := deliveryList[:0]
+		for _, d := range deliveryList {
+			if d.NextAttemptAt == "" && drop > 0 { drop--; continue }
+			kept = append(kept, d)
+		}
+		deliveryList = kept
+	}
+	data, err := encodeDataFile(kindWebhookDeliveries, deliveryList)
+	if err != nil { slog.Error("❌ 序列化 Webhook 投递记录失败", "err", err); return }
+	if err := writeFileAtomic(webhookDeliveryFile, data, 0600); err != nil { slog.Error("❌ 保存 Webhook 投递记录失败", "err", err) }
+}
+
+func wakeWebhookWorker() {
+	select {
+	case webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// emitEvent 为每个订阅了该事件的 Webhook 排队一次投递，立即返回
+func emitEvent(event string, data interface{}) { emitEventTo("", event, data) }
+
+func emitEventTo(webhookID, event string, data interface{}) {
+	now, eventID := nowTimestamp(), "evt_"+newRecordID()
+	body, err := json.Marshal(map[string]interface{}{"id": eventID, "type": event, "created_at": now, "data": data})
+	if err != nil { slog.Error("❌ 序列化事件失败", "event", event, "err", err); return }
+	payload, err := encryptField("webhook_payload", string(body))
+	if err != nil { slog.Error("❌ 加密事件失败", "event", event, "err", err); return }
+
+	webhookMutex.Lock(); defer webhookMutex.Unlock()
+	queued := 0
+	for _, h := range webhookList {
+		if webhookID != "" && h.ID != webhookID || webhookID == "" && !h.wants(event) { continue }
+		deliveryList = append(deliveryList, WebhookDelivery{ID: newRecordID(), WebhookID: h.ID, EventID: eventID, Event: event, Payload: payload, CreatedAt: now, NextAttemptAt: now})
+		queued++
+	}
+	if queued == 0 { return }
+	persistDeliveries()
+	wakeWebhookWorker()
+}
+
+func hasWebhookFor(event string) bool {
+	webhookMutex.Lock(); defer webhookMutex.Unlock()
+	for _, h := range webhookList {
+		if h.wants(event) { return true }
+	}
+	return false
+}
+
+// webhookBackoff 第 n 次失败后的等待时间：30s、1m、2m……最长 6 小时
+func webhookBackoff(attempts int) time.Duration {
+	d := 30 * time.Second
+	for i := 1; i < attempts && d < webhookMaxBackoff; i++ { d *= 2 }
+	return min(d, webhookMaxBackoff)
+}
+
+func signWebhook(secret, timestamp string, body []byte) string {
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write([]byte(timestamp + "."))
+	m.Write(body)
+	return "sha256=" + hex.EncodeToString(m.Sum(nil))
+}
+
+// sendWebhook 执行一次投递，返回 HTTP 状态码（连接失败时为 0）和错误
+func sendWebhook(h Webhook, d WebhookDelivery) (int, error) {
+	secret, err := decryptField("webhook_secret", h.Secret)
+	if err != nil { return 0, err }
+	body, err := decryptField("webhook_payload", d.Payload)
+	if err != nil { return 0, err }
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequest("POST", h.URL, strings.NewReader(body))
+	if err != nil { return 0, err }
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "license-server-webhook")
+	req.Header.Set("X-Webhook-Id", d.EventID)
+	req.Header.Set("X-Webhook-Event", d.Event)
+	req.Header.Set("X-Webhook-Timestamp", ts)
+	req.Header.Set("X-Webhook-Signature", signWebhook(secret, ts, []byte(body)))
+	resp, err := webhookHTTPClient.Do(req)
+	if err != nil { return 0, err }
+	defer resp.Body.Close()
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 { return resp.StatusCode, fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(snippet))) }
+	return resp.StatusCode, nil
+}
+
+// processWebhookQueue 投递所有到期的记录；发送时不持有锁。没有任何记录变化时不写文件
+func processWebhookQueue(now time.Time) {
+	type job struct {
+		hook     Webhook
+		delivery WebhookDelivery
+	}
+	var jobs []job
+	dropped := false
+	webhookMutex.Lock()
+	hooks := map[string]Webhook{}
+	for _, h := range webhookList { hooks[h.ID] = h }
+	for i := range deliveryList {
+		d := &deliveryList[i]
+		if d.NextAttemptAt == "" { continue }
+		if due, err := parseTimestamp(d.NextAttemptAt); err == nil && due.After(now) { continue }
+		h, ok := hooks[d.WebhookID]
+		if !ok { d.NextAttemptAt, d.LastError = "", "订阅已删除"; dropped = true; continue }
+		jobs = append(jobs, job{h, *d})
+	}
+	webhookMutex.Unlock()
+
+	sent := 0
+	for _, j := range jobs {
+		// 退出时剩下的留在队列里，重启后再投递
+		if shuttingDown() { break }
+		status, err := sendWebhook(j.hook, j.delivery)
+		recordNotification("webhook", err == nil)
+		sent++
+		at := time.Now()
+		webhookMutex.Lock()
+		for i := range deliveryList {
+			d := &deliveryList[i]
+			if d.ID != j.delivery.ID { continue }
+			d.Attempts++
+			d.LastAttemptAt, d.LastStatus, d.LastError = at.UTC().Format(time.RFC3339), status, ""
+			switch {
+			case err == nil:
+				d.DeliveredAt, d.NextAttemptAt = d.LastAttemptAt, ""
+			case d.Attempts >= WebhookMaxAttempts.Get():
+				d.LastError, d.NextAttemptAt = err.Error(), ""
+				slog.Error("❌ Webhook 投递失败次数达到上限，放弃", "url", j.hook.URL, "event", d.Event, "attempts", d.Attempts, "err", err)
+			default:
+				d.LastError = err.Error()
+				d.NextAttemptAt = at.Add(webhookBackoff(d.Attempts)).UTC().Format(time.RFC3339)
+				slog.Warn("⚠️ Webhook 投递失败，稍后重试", "url", j.hook.URL, "event", d.Event, "attempts", d.Attempts, "retry_in", webhookBackoff(d.Attempts), "err", err)
+			}
+			break
+		}
+		webhookMutex.Unlock()
+	}
+
+	if sent == 0 && !dropped { return }
+	webhookMutex.Lock()
+	persistDeliveries()
+	webhookMutex.Unlock()
+}
+
+// checkExpiringLicenses 对即将到期、还没通知过的激活码发出 license.expiring
+func checkExpiringLicenses(now time.Time) {
+	if WebhookExpiringDays.Get() <= 0 || !hasWebhookFor(eventLicenseExpiring) { return }
+	loc := bizLocation()
+	day, until := now.In(loc).Format("2006-01-02"), now.In(loc).AddDate(0, 0, WebhookExpiringDays.Get()).Format("2006-01-02")
+	var due []HistoryRecord
+	mutex.Lock()
+	for i := range historyList {
+		h := &historyList[i]
+		if h.ExpiryNotifiedAt != "" || h.RevokedAt != "" || h.ExpiryDate < day || h.ExpiryDate > until { continue }
+		h.ExpiryNotifiedAt = nowTimestamp()
+		due = append(due, *h)
+	}
+	if len(due) > 0 { persistHistory() }
+	mutex.Unlock()
+	for _, rec := range due { emitEvent(eventLicenseExpiring, toLicenseV1(rec, day)) }
+}
+
+func startWebhookWorker() {
+	go func() {
+		tick := time.NewTicker(5 * time.Second)
+		lastExpiryCheck := time.Time{}
+		for {
+			select {
+			case <-tick.C:
+			case <-webhookWake:
+			case <-shutdownCh:
+				return
+			}
+			if !beginJob() { return }
+			now := time.Now()
+			if now.Sub(lastExpiryCheck) >= time.Hour { checkExpiringLicenses(now); lastExpiryCheck = now }
+			processWebhookQueue(now)
+			endJob()
+		}
+	}()
+}
+
+// ================= 管理接口 =================
+
+type CreateWebhookRequest struct {
+	URL         string   `json:"url"`
+	Events      []string `json:"events"`
+	Description string   `json:"description,omitempty"`
+}
+
+type WebhookIDRequest struct {
+	ID string `json:"id"`
+}
+
+func validateWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" { return fmt.Errorf("URL 必须是 http:// 或 https:// 开头的完整地址") }
+	return nil
+}
+
+// POST /api/webhooks 创建订阅，签名密钥只在响应里出现一次
+func handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" { http.Error(w, "Method Not Allowed", 405); return }
+	var req CreateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "JSON Error", 400); return }
+	admin, ok := authorize(w, r, "", scopeAdmin)
+	if !ok { return }
+	req.URL = strings.TrimSpace(req.URL)
+	if err := validateWebhookURL(req.URL); err != nil { http.Error(w, err.Error(), 400); return }
+	if len(req.Events) == 0 { http.Error(w, "至少选择一个事件", 400); return }
+	for _, e := range req.Events {
+		if !containsString(webhookEvents, e) { http.Error(w, "未知事件: "+e, 400); return }
+	}
+
+	secret := "whsec_" + randomString(24)
+	enc, err := encryptField("webhook_secret", secret)
+	if err != nil { http.Error(w, "加密签名密钥失败", 500); return }
+	h := Webhook{ID: newRecordID(), URL: req.URL, Events: req.Events, Description: strings.TrimSpace(req.Description), Secret: enc, CreatedAt: nowTimestamp(), CreatedBy: admin.Name}
+	webhookMutex.Lock()
+	webhookList = append(webhookList, h)
+	persistWebhooks()
+	webhookMutex.Unlock()
+	slog.InfoContext(r.Context(), "🪝 添加了 Webhook", "principal", admin.Name, "url", h.URL, "events", strings.Join(h.Events, ","))
+
+	h.Secret = ""
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]interface{}{"webhook": h, "secret": secret})
+}
+
+// POST /api/webhooks/delete 删除订阅，未投递的记录不再重试
+func handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" { http.Error(w, "Method Not Allowed", 405); return }
+	var req WebhookIDRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "JSON Error", 400); return }
+	admin, ok := authorize(w, r, "", scopeAdmin)
+	if !ok { return }
+	webhookMutex.Lock(); defer webhookMutex.Unlock()
+	for i, h := range webhookList {
+		if h.ID != req.ID { continue }
+		webhookList = append(webhookList[:i], webhookList[i+1:]...)
+		for j := range deliveryList {
+			if d := &deliveryList[j]; d.WebhookID == h.ID && d.NextAttemptAt != "" { d.NextAttemptAt, d.LastError = "", "订阅已删除" }
+		}
+		persistWebhooks()
+		persistDeliveries()
+		slog.InfoContext(r.Context(), "🪝 删除了 Webhook", "principal", admin.Name, "url", h.URL)
+		w.Write([]byte("✅ Webhook 已删除"))
+		return
+	}
+	http.Error(w, "Webhook 未找到", 404)
+}
+
+// POST /api/webhooks/test 向指定订阅发送 webhook.ping
+func handleTestWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" { http.Error(w, "Method Not Allowed", 405); return }
+	var req WebhookIDRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "JSON Error", 400); return }
+	admin, ok := authorize(w, r, "", scopeAdmin)
+	if !ok { return }
+	webhookMutex.Lock()
+	found := false
+	for _, h := range webhookList { found = found || h.ID == req.ID }
+	webhookMutex.Unlock()
+	if !found { http.Error(w, "Webhook 未找到", 404); return }
+	emitEventTo(req.ID, eventWebhookPing, map[string]string{"sent_by": admin.Name})
+	w.Write([]byte("✅ 已加入投递队列"))
+}
+
+// POST /api/webhooks/replay 把一条投递记录用同一个事件 ID 重新投递一次
+func handleReplayWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" { http.Error(w, "Method Not Allowed", 405); return }
+	var req WebhookIDRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "JSON Error", 400); return }
+	admin, ok := authorize(w, r, "", scopeAdmin)
+	if !ok { return }
+	webhookMutex.Lock(); defer webhookMutex.Unlock()
+	for _, d := range deliveryList {
+		if d.ID != req.ID { continue }
+		if d.NextAttemptAt != "" { http.Error(w, "该投递仍在重试队列中", 409); return }
+		exists := false
+		for _, h := range webhookList { exists = exists || h.ID == d.WebhookID }
+		if !exists { http.Error(w, "订阅已删除，无法重放", 409); return }
+		now := nowTimestamp()
+		deliveryList = append(deliveryList, WebhookDelivery{ID: newRecordID(), WebhookID: d.WebhookID, EventID: d.EventID, Event: d.Event, Payload: d.Payload, CreatedAt: now, NextAttemptAt: now, ReplayOf: d.ID})
+		persistDeliveries()
+		wakeWebhookWorker()
+		slog.InfoContext(r.Context(), "🪝 重放了 Webhook 投递", "principal", admin.Name, "delivery_id", d.ID, "event", d.Event)
+		w.Write([]byte("✅ 已重新加入投递队列"))
+		return
+	}
+	http.Error(w, "投递记录未找到", 404)
+}
+
+// ================= 页面 =================
+
+func handleWebhooks(w http.ResponseWriter, r *http.Request) {
+	admin, sess, ok := authorizePage(w, r, scopeAdmin)
+	if !ok { return }
+
+	webhookMutex.Lock()
+	urls := map[string]string{}
+	hooksHtml := ""
+	for _, h := range webhookList {
+		urls[h.ID] = h.URL
+		desc := ""
+		if h.Description != "" { desc = `<br><span style="color:#888">` + html.EscapeString(h.Description) + `</span>` }
+		hooksHtml += fmt.Sprintf(`<tr><td><code>%s</code>%s</td><td>%s</td><td>%s<br><span style="color:#888">%s</span></td><td style="white-space:nowrap"><button class="btn-sm" onclick="act('/api/webhooks/test','%s')">测试</button> <button class="del-btn" onclick="if(confirm('确定删除该 Webhook 吗？'))act('/api/webhooks/delete','%s')">删除</button></td></tr>`,
+			html.EscapeString(h.URL), desc, strings.Join(h.Events, "<br>"), displayTime(h.CreatedAt), html.EscapeString(h.CreatedBy), h.ID, h.ID)
+	}
+	recent := append([]WebhookDelivery{}, deliveryList...)
+	webhookMutex.Unlock()
+	if hooksHtml == "" { hooksHtml = `<tr><td colspan="4" style="color:#888">还没有订阅</td></tr>` }
+
+	sort.SliceStable(recent, func(i, j int) bool { return recent[i].CreatedAt > recent[j].CreatedAt })
+	if len(recent) > 100 { recent = recent[:100] }
+	logHtml := ""
+	for _, d := range recent {
+		status := d.Status()
+		color := map[string]string{"delivered": "#34c759", "pending": "#ff9500", "failed": "#ff3b30"}[status]
+		detail := ""
+		if d.LastStatus > 0 { detail = fmt.Sprintf("HTTP %d", d.LastStatus) }
+		if d.LastError != "" { detail += " " + html.EscapeString(d.LastError) }
+		if d.NextAttemptAt != "" && d.Attempts > 0 { detail += "<br>下次重试: " + displayTime(d.NextAttemptAt) }
+		if d.ReplayOf != "" { detail += "<br>重放自 <code>" + d.ReplayOf + "</code>" }
+		action := ""
+		if d.NextAttemptAt == "" && urls[d.WebhookID] != "" { action = fmt.Sprintf(`<button class="btn-sm" onclick="act('/api/webhooks/replay','%s')">重放</button>`, d.ID) }
+		target := urls[d.WebhookID]
+		if target == "" { target = "(已删除)" }
+		logHtml += fmt.Sprintf(`<tr><td>%s</td><td>%s<br><code style="color:#888">%s</code></td><td><code>%s</code></td><td>%d</td><td style="color:%s">%s</td><td style="max-width:280px;word-break:break-all">%s</td><td>%s</td></tr>`,
+			displayTime(d.CreatedAt), d.Event, d.EventID, html.EscapeString(target), d.Attempts, color, status, detail, action)
+	}
+	if logHtml == "" { logHtml = `<tr><td colspan="7" style="color:#888">暂无投递记录</td></tr>` }
+
+	eventBoxes := ""
+	for _, e := range webhookEvents { eventBoxes += fmt.Sprintf(`<label style="margin-right:10px"><input type="checkbox" name="event" value="%s"> %s</label>`, e, e) }
+
+	page := fmt.Sprintf(`<!DOCTYPE html><html><head><meta charset="UTF-8"><meta name="viewport" content="width=device-width,initial-scale=1.0">%s<title>Webhook</title>
+	<style>body{font-family:-apple-system,sans-serif;max-width:1100px;margin:20px auto;padding:10px;background:#f5f5f7}.card{background:white;padding:20px;border-radius:12px;box-shadow:0 2px 10px rgba(0,0,0,0.1);margin-bottom:20px}table{width:100%%;border-collapse:collapse;margin-top:10px;font-size:13px}th{text-align:left;background:#fafafa;padding:10px;border-bottom:2px solid #eee}td{padding:10px;border-bottom:1px solid #f5f5f5;color:#333;vertical-align:top}input[type=text]{padding:6px;border:1px solid #ccc;border-radius:4px;margin:4px 0 10px;width:100%%;box-sizing:border-box}.del-btn{background:#fff;border:1px solid #ff3b30;color:#ff3b30;padding:4px 8px;border-radius:4px;cursor:pointer;font-size:12px}.del-btn:hover{background:#ff3b30;color:white}.btn-sm{background:#fff;border:1px solid #0071e3;color:#0071e3;padding:4px 8px;border-radius:4px;cursor:pointer;font-size:12px}.btn{padding:8px 16px;background:#0071e3;color:white;border:none;border-radius:6px;cursor:pointer}#secret{display:none;margin-top:10px;padding:10px;background:#fff8e1;border-radius:6px;word-break:break-all;font-family:monospace}</style></head><body>
+	<div class="card"><h2 style="display:flex;justify-content:space-between">🪝 Webhook %s</h2>
+	<table><thead><tr><th>地址</th><th>事件</th><th>创建</th><th></th></tr></thead><tbody>%s</tbody></table></div>
+	<div class="card"><h3>添加订阅</h3>
+	<label>URL</label><input type="text" id="url" placeholder="https://billing.example.com/hooks/license">
+	<label>备注（可选）</label><input type="text" id="desc">
+	<div style="margin-bottom:10px">%s</div>
+	<button class="btn" onclick="create()">添加</button><div id="secret"></div>
+	<p style="font-size:12px;color:#888">请求头 X-Webhook-Signature = sha256=hex(HMAC-SHA256(签名密钥, X-Webhook-Timestamp + "." + 请求体))，失败后自动按指数退避重试。</p></div>
+	<div class="card"><h3>最近投递</h3>
+	<table><thead><tr><th>时间</th><th>事件</th><th>地址</th><th>尝试</th><th>状态</th><th>详情</th><th></th></tr></thead><tbody>%s</tbody></table></div>
+	%s<script>
+	async function create(){var events=[...document.querySelectorAll('input[name=event]:checked')].map(e=>e.value);
+	var r=await postJSON('/api/webhooks',{url:document.getElementById('url').value,events:events,description:document.getElementById('desc').value});
+	if(!r.ok)return alert(await r.text());var d=await r.json();var box=document.getElementById('secret');box.style.display='block';box.innerText='⚠️ 请立即保存，签名密钥只显示一次:\n'+d.secret+'\n\n保存后刷新页面';}
+	async function act(u,id){var r=await postJSON(u,{id:id});if(r.ok)setTimeout(()=>location.reload(),1000);else alert(await r.text())}</script></body></html>`, csrfMeta(sess), navLinks(admin), hooksHtml, eventBoxes, logHtml, csrfJS)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(page))
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSignWebhook(t *testing.T) {
+	got := signWebhook("whsec_test", "1700000000", []byte(`{"a":1}`))
+	if want := "sha256=38877139021993b830af32feea6e18a8da83eb2f6e49ee50bd9e4cf4ca4d3789"; got != want { t.Fatalf("签名 %s，期望 %s", got, want) }
+	if signWebhook("whsec_test", "1700000001", []byte(`{"a":1}`)) == got { t.Fatal("时间戳应参与签名") }
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	cases := map[int]time.Duration{1: 30 * time.Second, 2: time.Minute, 3: 2 * time.Minute, 10: 256 * time.Minute, 11: webhookMaxBackoff, 100: webhookMaxBackoff}
+	for n, want := range cases {
+		if got := webhookBackoff(n); got != want { t.Errorf("webhookBackoff(%d) = %v，期望 %v", n, got, want) }
+	}
+}
+
+// webhookReceiver 按 codes 依次返回状态码，并校验签名
+func webhookReceiver(t *testing.T, codes ...int) (*httptest.Server, *int32) {
+	var n int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := atomic.AddInt32(&n, 1) - 1
+		body, err := io.ReadAll(r.Body)
+		if err != nil { t.Error(err) }
+		if sig := signWebhook("whsec_test", r.Header.Get("X-Webhook-Timestamp"), body); r.Header.Get("X-Webhook-Signature") != sig { t.Error("签名不匹配") }
+		w.WriteHeader(codes[min(int(i), len(codes)-1)])
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &n
+}
+
+func queuedDelivery(id, hookID string, at time.Time) WebhookDelivery {
+	ts := at.UTC().Format(time.RFC3339)
+	return WebhookDelivery{ID: id, WebhookID: hookID, EventID: "evt_" + id, Event: eventWebhookPing, Payload: `{"type":"webhook.ping"}`, CreatedAt: ts, NextAttemptAt: ts}
+}
+
+func TestWebhookRetryThenDeliver(t *testing.T) {
+	srv, calls := webhookReceiver(t, 500, 200)
+	withStore(t, nil, []Webhook{{ID: "w1", URL: srv.URL, Secret: "whsec_test"}})
+	now := time.Now()
+	deliveryList = []WebhookDelivery{queuedDelivery("d1", "w1", now)}
+
+	processWebhookQueue(now)
+	d := deliveryList[0]
+	if d.Attempts != 1 || d.LastStatus != 500 || d.NextAttemptAt == "" || d.DeliveredAt != "" { t.Fatalf("第一次失败后应排队重试: %+v", d) }
+	processWebhookQueue(now) // 还没到重试时间
+	if atomic.LoadInt32(calls) != 1 { t.Fatalf("未到期时不应投递，调用了 %d 次", *calls) }
+
+	processWebhookQueue(now.Add(webhookBackoff(1) + time.Second))
+	d = deliveryList[0]
+	if d.Attempts != 2 || d.DeliveredAt == "" || d.NextAttemptAt != "" || d.Status() != "delivered" { t.Fatalf("重试应成功: %+v", d) }
+}
+
+func TestWebhookGivesUpAfterMaxAttempts(t *testing.T) {
+	srv, _ := webhookReceiver(t, 500)
+	withStore(t, nil, []Webhook{{ID: "w1", URL: srv.URL, Secret: "whsec_test"}})
+	saved := WebhookMaxAttempts.Get()
+	WebhookMaxAttempts.Set(2)
+	t.Cleanup(func() { WebhookMaxAttempts.Set(saved) })
+	now := time.Now()
+	deliveryList = []WebhookDelivery{queuedDelivery("d1", "w1", now)}
+
+	processWebhookQueue(now)
+	processWebhookQueue(now.Add(time.Hour))
+	if d := deliveryList[0]; d.Attempts != 2 || d.NextAttemptAt != "" || d.Status() != "failed" { t.Fatalf("达到上限后应放弃: %+v", d) }
+}
+
+func TestWebhookQueueIdleDoesNotWrite(t *testing.T) {
+	withStore(t, nil, []Webhook{{ID: "w1", URL: "http://127.0.0.1:1", Secret: "whsec_test"}})
+	deliveryList = []WebhookDelivery{{ID: "d1", WebhookID: "w1", Event: eventWebhookPing, DeliveredAt: "2026-01-01T00:00:00Z"}}
+	processWebhookQueue(time.Now())
+	if _, err := os.Stat(webhookDeliveryFile); !os.IsNotExist(err) { t.Fatalf("没有到期的投递时不应写文件: %v", err) }
+
+	// 订阅已删除的记录被标记后需要保存
+	deliveryList = append(deliveryList, queuedDelivery("d2", "gone", time.Now()))
+	processWebhookQueue(time.Now())
+	if _, err := os.Stat(webhookDeliveryFile); err != nil { t.Fatalf("标记了订阅已删除后应保存: %v", err) }
+	if d := deliveryList[1]; d.NextAttemptAt != "" || d.LastError != "订阅已删除" { t.Fatalf("记录应被标记: %+v", d) }
+}
+
+func replayRequest(id string) *http.Request {
+	r := httptest.NewRequest("POST", "/api/webhooks/replay", strings.NewReader(`{"id":"`+id+`"}`))
+	return r.WithContext(context.WithValue(r.Context(), ctxPrincipal, &principal{Name: "ops", Scopes: []string{scopeAdmin}}))
+}
+
+func TestWebhookReplay(t *testing.T) {
+	withStore(t, nil, []Webhook{{ID: "w1", URL: "http://127.0.0.1:1", Secret: "whsec_test"}})
+	done := queuedDelivery("d1", "w1", time.Now())
+	done.NextAttemptAt, done.DeliveredAt = "", done.CreatedAt
+	deliveryList = []WebhookDelivery{done, queuedDelivery("d2", "w1", time.Now()), {ID: "d3", WebhookID: "gone", EventID: "evt_d3"}}
+
+	cases := map[string]int{"d1": 200, "d2": 409, "d3": 409, "nope": 404}
+	for id, want := range cases {
+		w := httptest.NewRecorder()
+		handleReplayWebhook(w, replayRequest(id))
+		if w.Code != want { t.Errorf("重放 %s: 状态 %d，期望 %d %s", id, w.Code, want, w.Body.String()) }
+	}
+	if len(deliveryList) != 4 { t.Fatalf("应新增一条投递，实际 %d 条", len(deliveryList)) }
+	r := deliveryList[3]
+	if r.ReplayOf != "d1" || r.EventID != done.EventID || r.Payload != done.Payload || r.NextAttemptAt == "" { t.Fatalf("重放记录不对: %+v", r) }
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ================= 极简 XLSX 读写 =================
+// 只处理单个工作表、纯文本单元格，够对账用，不引入第三方库。
+
+const xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`
+
+const xlsxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`
+
+const xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`
+
+func writeXLSX(w io.Writer, sheetName string, rows [][]string) error {
+	zw := zip.NewWriter(w)
+	files := []struct{ name, body string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+		{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="` + xmlEscape(sheetName) + `" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+		{"xl/worksheets/sheet1.xml", xlsxSheetXML(rows)},
+	}
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil { return err }
+		if _, err := io.WriteString(fw, f.body); err != nil { return err }
+	}
+	return zw.Close()
+}
+
+func xlsxSheetXML(rows [][]string) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+	b.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	for i, row := range rows {
+		fmt.Fprintf(&b, `<row r="%d">`, i+1)
+		for j, cell := range row {
+			fmt.Fprintf(&b, `<c r="%s%d" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, xlsxColName(j), i+1, xmlEscape(cell))
+		}
+		b.WriteString(`</row>`)
+	}
+	b.WriteString(`</sheetData></worksheet>`)
+	return b.String()
+}
+
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// 0 -> A, 25 -> Z, 26 -> AA
+func xlsxColName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 { name = string(rune('A'+(i-1)%26)) + name }
+	return name
+}
+
+// Excel 最多 16384 列（A..XFD）；解压后的单个部件不超过 xlsxMaxPartSize，防止压缩炸弹
+const (
+	xlsxMaxCol      = 16383
+	xlsxMaxPartSize = 64 << 20
+)
+
+// "AB12" -> 27；没有列字母或超过 XFD 时返回 -1
+func xlsxColIndex(ref string) int {
+	idx := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' { break }
+		idx = idx*26 + int(r-'A'+1)
+		if idx > xlsxMaxCol+1 { return -1 }
+	}
+	return idx - 1
+}
+
+type xlsxSST struct {
+	Items []struct {
+		T    string `xml:"t"`
+		Runs []struct {
+			T string `xml:"t"`
+		} `xml:"r"`
+	} `xml:"si"`
+}
+
+type xlsxSheet struct {
+	Rows []struct {
+		Cells []struct {
+			Ref    string `xml:"r,attr"`
+			Type   string `xml:"t,attr"`
+			Value  string `xml:"v"`
+			Inline struct {
+				T string `xml:"t"`
+			} `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// readXLSX 读取第一个工作表，兼容 Excel 保存时改用的共享字符串表
+func readXLSX(data []byte) ([][]string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil { return nil, fmt.Errorf("不是有效的 XLSX 文件: %v", err) }
+
+	var shared []string
+	var sheetFile *zip.File
+	for _, f := range zr.File {
+		switch f.Name {
+		case "xl/sharedStrings.xml":
+			var sst xlsxSST
+			if err := xlsxDecode(f, &sst); err != nil { return nil, err }
+			for _, si := range sst.Items {
+				s := si.T
+				for _, r := range si.Runs { s += r.T }
+				shared = append(shared, s)
+			}
+		case "xl/worksheets/sheet1.xml":
+			sheetFile = f
+		}
+	}
+	if sheetFile == nil { return nil, fmt.Errorf("XLSX 中未找到工作表") }
+
+	var sheet xlsxSheet
+	if err := xlsxDecode(sheetFile, &sheet); err != nil { return nil, err }
+
+	rows := make([][]string, 0, len(sheet.Rows))
+	for _, r := range sheet.Rows {
+		var row []string
+		for i, c := range r.Cells {
+			col := i
+			if c.Ref != "" { col = xlsxColIndex(c.Ref) }
+			if col < 0 || col > xlsxMaxCol { return nil, fmt.Errorf("单元格位置错误: %q", c.Ref) }
+			for len(row) <= col { row = append(row, "") }
+			switch c.Type {
+			case "inlineStr":
+				row[col] = c.Inline.T
+			case "s":
+				n, err := strconv.Atoi(c.Value)
+				if err != nil || n < 0 || n >= len(shared) { return nil, fmt.Errorf("共享字符串索引错误: %s", c.Ref) }
+				row[col] = shared[n]
+			default:
+				row[col] = c.Value
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func xlsxDecode(f *zip.File, v interface{}) error {
+	rc, err := f.Open()
+	if err != nil { return err }
+	defer rc.Close()
+	if f.UncompressedSize64 > xlsxMaxPartSize { return fmt.Errorf("%s 解压后超过 %d MB", f.Name, xlsxMaxPartSize>>20) }
+	// 声明的大小可以伪造，实际读取时再限制一次
+	lr := &io.LimitedReader{R: rc, N: xlsxMaxPartSize + 1}
+	if err := xml.NewDecoder(lr).Decode(v); err != nil {
+		if lr.N <= 0 { return fmt.Errorf("%s 解压后超过 %d MB", f.Name, xlsxMaxPartSize>>20) }
+		return fmt.Errorf("解析 %s 失败: %v", f.Name, err)
+	}
+	return nil
+}
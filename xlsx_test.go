@@ -0,0 +1,69 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// xlsxWithSheet 只带一个 sheet1.xml 的最小 XLSX
+func xlsxWithSheet(t *testing.T, sheetXML string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	fw, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil { t.Fatal(err) }
+	if _, err := fw.Write([]byte(sheetXML)); err != nil { t.Fatal(err) }
+	if err := zw.Close(); err != nil { t.Fatal(err) }
+	return buf.Bytes()
+}
+
+func sheetWithCell(ref string) string {
+	return `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData><row r="1">` +
+		`<c r="` + ref + `" t="inlineStr"><is><t>x</t></is></c></row></sheetData></worksheet>`
+}
+
+func TestXLSXRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	in := [][]string{{"机器码", "到期日"}, {"M-1", "2026-01-01"}, {"<&>", ""}}
+	if err := writeXLSX(&buf, "历史", in); err != nil { t.Fatal(err) }
+	out, err := readXLSX(buf.Bytes())
+	if err != nil { t.Fatal(err) }
+	if len(out) != len(in) { t.Fatalf("行数 %d，期望 %d", len(out), len(in)) }
+	// 行尾的空单元格不写入文件，读回来时可能变短
+	for i := range in {
+		for j, want := range in[i] {
+			got := ""
+			if j < len(out[i]) { got = out[i][j] }
+			if got != want { t.Errorf("(%d,%d) = %q，期望 %q", i, j, got, want) }
+		}
+	}
+}
+
+func TestXLSXColIndex(t *testing.T) {
+	cases := map[string]int{"A1": 0, "Z9": 25, "AA1": 26, "AB12": 27, "XFD1": xlsxMaxCol, "XFE1": -1, "1": -1, "ZZZZZZZZ1": -1}
+	for ref, want := range cases {
+		if got := xlsxColIndex(ref); got != want { t.Errorf("xlsxColIndex(%q) = %d，期望 %d", ref, got, want) }
+	}
+}
+
+func TestReadXLSXRejectsRefWithoutColumn(t *testing.T) {
+	if _, err := readXLSX(xlsxWithSheet(t, sheetWithCell("1"))); err == nil { t.Fatal(`r="1" 应该报错而不是 panic`) }
+}
+
+func TestReadXLSXRejectsHugeColumn(t *testing.T) {
+	for _, ref := range []string{"XFE1", "ZZZZZZZZ1", "ZZZZZZZZZZZZZZZZZZZZ1"} {
+		if _, err := readXLSX(xlsxWithSheet(t, sheetWithCell(ref))); err == nil { t.Errorf("r=%q 应该报错", ref) }
+	}
+	rows, err := readXLSX(xlsxWithSheet(t, sheetWithCell("XFD1")))
+	if err != nil { t.Fatal(err) }
+	if len(rows[0]) != xlsxMaxCol+1 || rows[0][xlsxMaxCol] != "x" { t.Fatalf("XFD1 读取错误: %d 列", len(rows[0])) }
+}
+
+func TestReadXLSXRejectsOversizedPart(t *testing.T) {
+	// 全是空白，压缩后很小，解压后超过上限
+	sheet := `<worksheet><sheetData>` + strings.Repeat(" ", xlsxMaxPartSize+1) + `</sheetData></worksheet>`
+	_, err := readXLSX(xlsxWithSheet(t, sheet))
+	if err == nil || !strings.Contains(err.Error(), "超过") { t.Fatalf("期望大小超限错误，实际: %v", err) }
+}